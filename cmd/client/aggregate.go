@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reconnects to an upstream device back off from minReconnect, doubling up to maxReconnect.
+const (
+	minReconnect = time.Second
+	maxReconnect = 30 * time.Second
+)
+
+// zone is one upstream device the aggregator follows, by the name it was given.
+type zone struct {
+	name     string
+	host     string
+	httpBase string
+	wsBase   string
+
+	mu        sync.Mutex
+	connected bool
+	lastError string
+	frame     *coil.CoilFrame
+}
+
+// zoneStatus is a zone as GET /zones reports it; Frame is the latest frame from the device, if there has been one.
+type zoneStatus struct {
+	Name      string          `json:"name"`
+	Host      string          `json:"host"`
+	Connected bool            `json:"connected"`
+	Error     string          `json:"error,omitempty"`
+	Frame     *coil.CoilFrame `json:"frame,omitempty"`
+}
+
+type zonesResponse struct {
+	Zones []zoneStatus `json:"zones"`
+}
+
+// zoneFrame is a frame from one zone, as streamed on /zones/ws.
+type zoneFrame struct {
+	Zone  string         `json:"zone"`
+	Frame coil.CoilFrame `json:"frame"`
+}
+
+// aggregator follows several devices at once and serves their frames together, tagged with each zone's name.
+type aggregator struct {
+	zones  []*zone
+	dialer *websocket.Dialer
+
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+
+	infoLog *log.Logger
+	errLog  *log.Logger
+}
+
+var zoneUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		writeError(w, status, reason.Error())
+	},
+}
+
+// errorResponse is the JSON body of every error the aggregator itself responds with, the same as a device's.
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+// writeError responds with status and msg in an errorResponse.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	payload, _ := json.Marshal(&errorResponse{Error: msg, Code: status})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(payload)
+}
+
+// parseZones reads name=host arguments, e.g. kiln=10.0.0.2:8080, with the same host forms as -h.
+func parseZones(args []string, useTLS bool) ([]*zone, error) {
+	if len(args) == 0 {
+		return nil, errors.New("-aggregate needs at least one upstream device as name=host")
+	}
+	seen := make(map[string]bool)
+	var zones []*zone
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.Contains(parts[0], "/") {
+			return nil, errors.New("upstream devices must be given as name=host, not " + arg)
+		}
+		if seen[parts[0]] {
+			return nil, errors.New("zone " + parts[0] + " is given more than once")
+		}
+		seen[parts[0]] = true
+		httpBase, wsBase := endpoints(parts[1], useTLS)
+		zones = append(zones, &zone{name: parts[0], host: parts[1], httpBase: httpBase, wsBase: wsBase})
+	}
+	return zones, nil
+}
+
+func newAggregator(zones []*zone, dialer *websocket.Dialer, infoLog, errLog *log.Logger) *aggregator {
+	return &aggregator{
+		zones:       zones,
+		dialer:      dialer,
+		subscribers: make(map[chan []byte]struct{}),
+		infoLog:     infoLog,
+		errLog:      errLog,
+	}
+}
+
+// serve follows every zone and serves them on addr until the server fails.
+func (a *aggregator) serve(addr string) error {
+	for _, z := range a.zones {
+		go a.follow(z)
+	}
+	a.infoLog.Printf("aggregating %d devices on %s\n", len(a.zones), addr)
+	return http.ListenAndServe(addr, a.router())
+}
+
+// router serves the aggregator's own endpoints and proxies each zone's.
+func (a *aggregator) router() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/zones", a.handleZones()).Methods("GET")
+	router.HandleFunc("/zones/ws", a.handleZonesWS()).Methods("GET")
+	for _, z := range a.zones {
+		prefix := "/zones/" + z.name
+		router.PathPrefix(prefix + "/").Handler(http.StripPrefix(prefix, a.proxy(z)))
+	}
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "no such endpoint or zone: "+r.URL.Path)
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+	})
+	return router
+}
+
+// follow keeps a websocket open to z's device, reconnecting with backoff whenever it drops,
+// independently of every other zone.
+func (a *aggregator) follow(z *zone) {
+	backoff := minReconnect
+	for {
+		err := a.stream(z, func() { backoff = minReconnect })
+		z.mu.Lock()
+		z.connected = false
+		z.lastError = err.Error()
+		z.mu.Unlock()
+		a.errLog.Printf("zone %s: %s; reconnecting in %s\n", z.name, err.Error(), backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxReconnect {
+			backoff = maxReconnect
+		}
+	}
+}
+
+// stream reads frames from z's device until the connection ends, calling connected once it's open.
+func (a *aggregator) stream(z *zone, connected func()) error {
+	ws, _, err := a.dialer.Dial(z.wsBase+"/ws", nil)
+	if err != nil {
+		return errors.New("error while dialing websocket connection: " + err.Error())
+	}
+	defer ws.Close()
+	connected()
+	z.mu.Lock()
+	z.connected, z.lastError = true, ""
+	z.mu.Unlock()
+	a.infoLog.Printf("zone %s: connected to %s\n", z.name, z.host)
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return errors.New("error while reading websocket message: " + err.Error())
+		}
+		for _, frame := range dropHeartbeats(decodeFrames(data)) {
+			if frame.Terminated {
+				return errors.New("device shut down")
+			}
+			f := frame
+			z.mu.Lock()
+			z.frame = &f
+			z.mu.Unlock()
+			a.broadcast(zoneFrame{Zone: z.name, Frame: frame})
+		}
+	}
+}
+
+// broadcast sends frame to every /zones/ws subscriber, dropping it for any that have fallen behind.
+func (a *aggregator) broadcast(frame zoneFrame) {
+	payload, err := json.Marshal(&frame)
+	if err != nil {
+		a.errLog.Printf("error while marshaling JSON for zone frame: %s\n", err.Error())
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for sub := range a.subscribers {
+		select {
+		case sub <- payload:
+		default:
+		}
+	}
+}
+
+func (a *aggregator) handleZones() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := zonesResponse{Zones: make([]zoneStatus, 0, len(a.zones))}
+		for _, z := range a.zones {
+			z.mu.Lock()
+			resp.Zones = append(resp.Zones, zoneStatus{
+				Name: z.name, Host: z.host, Connected: z.connected, Error: z.lastError, Frame: z.frame,
+			})
+			z.mu.Unlock()
+		}
+		payload, err := json.Marshal(&resp)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(payload)
+	}
+}
+
+// handleZonesWS streams every zone's frames as zoneFrames.
+func (a *aggregator) handleZonesWS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ws, err := zoneUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		sub := make(chan []byte, 64)
+		a.mu.Lock()
+		a.subscribers[sub] = struct{}{}
+		a.mu.Unlock()
+		defer func() {
+			a.mu.Lock()
+			delete(a.subscribers, sub)
+			a.mu.Unlock()
+		}()
+		// Nothing is read from subscribers, but reading notices when they go away
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := ws.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		for {
+			select {
+			case payload := <-sub:
+				if err := ws.WriteMessage(websocket.TextMessage, payload); err != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	}
+}
+
+// proxy passes requests under /zones/{name}/ on to z's device, so its whole API, websockets included,
+// is reachable through the aggregator.
+func (a *aggregator) proxy(z *zone) http.Handler {
+	target, _ := url.Parse(z.httpBase)
+	p := httputil.NewSingleHostReverseProxy(target)
+	p.Transport = &http.Transport{TLSClientConfig: a.dialer.TLSClientConfig}
+	p.ErrorLog = a.errLog
+	p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		a.errLog.Printf("error while proxying %s to zone %s: %s\n", r.URL.Path, z.name, err.Error())
+		writeError(w, http.StatusBadGateway, "error while reaching zone "+z.name+": "+err.Error())
+	}
+	return p
+}
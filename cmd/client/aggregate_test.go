@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// checkError fails t unless resp carries an errorResponse with status.
+func checkError(t *testing.T, what string, resp *http.Response, status int) {
+	t.Helper()
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Errorf("%s: status = %d, want %d", what, resp.StatusCode, status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s: Content-Type = %q, want application/json", what, ct)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("%s: error while decoding body: %s", what, err)
+	}
+	if len(body) != 2 {
+		t.Errorf("%s: body has fields %v, want only error and code", what, body)
+	}
+	if msg, ok := body["error"].(string); !ok || msg == "" {
+		t.Errorf("%s: error = %v, want a message", what, body["error"])
+	}
+	if code, ok := body["code"].(float64); !ok || int(code) != status {
+		t.Errorf("%s: code = %v, want %d", what, body["code"], status)
+	}
+}
+
+// deadHost returns an address that refuses connections.
+func deadHost(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestAggregatorErrorEnvelope(t *testing.T) {
+	zones, err := parseZones([]string{"kiln=" + deadHost(t)}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discard := log.New(ioutil.Discard, "", 0)
+	a := newAggregator(zones, websocket.DefaultDialer, discard, discard)
+	ts := httptest.NewServer(a.router())
+	defer ts.Close()
+
+	for _, req := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/zones/ws", http.StatusBadRequest}, // not a websocket handshake
+		{"GET", "/zones/kiln/", http.StatusBadGateway},
+		{"GET", "/zones/nonesuch/", http.StatusNotFound},
+		{"GET", "/nonesuch", http.StatusNotFound},
+		{"POST", "/zones", http.StatusMethodNotAllowed},
+	} {
+		r, err := http.NewRequest(req.method, ts.URL+req.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("%s %s: %s", req.method, req.path, err)
+		}
+		checkError(t, req.method+" "+req.path, resp, req.status)
+	}
+
+	resp, err := http.Get(ts.URL + "/zones")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var zonesResp zonesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&zonesResp); err != nil {
+		t.Fatalf("error while decoding zones: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK || len(zonesResp.Zones) != 1 || zonesResp.Zones[0].Name != "kiln" {
+		t.Errorf("GET /zones = %d %+v, want kiln", resp.StatusCode, zonesResp)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+// autotune starts an autotune around target, follows its progress and prints the suggested gains,
+// writing them to configFile as well if it is set. Interrupting it aborts the autotune on the device.
+// It returns the exit code. The gains are printed to output and the progress to infoLog.
+func autotune(httpClient *http.Client, dialer *websocket.Dialer, httpBase, wsBase string, target float64, unit, configFile string, output, infoLog, errLog *log.Logger) int {
+	// Follow first so the end of a quick autotune can't be missed
+	ws, _, err := dialer.Dial(wsBase+"/ws", nil)
+	if err != nil {
+		errLog.Printf("error while dialing websocket connection: %s\n", err.Error())
+		return 1
+	}
+	defer ws.Close()
+
+	query := fmt.Sprintf("?target=%.2f", target)
+	if unit != "" {
+		query += "&unit=" + url.QueryEscape(unit)
+	}
+	resp, err := httpClient.Post(httpBase+"/autotune"+query, "", nil)
+	if err != nil {
+		errLog.Printf("error while starting autotune: %s\n", err.Error())
+		return 1
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		errLog.Printf("error while starting autotune: %s: %s\n", resp.Status, errorMessage(body))
+		return 1
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		if err := abortAutotune(httpClient, httpBase); err != nil {
+			errLog.Printf("\nerror while aborting autotune: %s\n", err.Error())
+			os.Exit(1)
+		}
+		errLog.Printf("\naborted autotune\n")
+		os.Exit(1)
+	}()
+
+	// The progress line is only drawn when infoLog is printing to a terminal
+	terminal := isTerminal(os.Stdout) && infoLog.Writer() == os.Stdout
+	lastCycle := -1
+	started := false
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			if terminal {
+				fmt.Println()
+			}
+			errLog.Printf("autotune stopped following: %s\n", err.Error())
+			return 1
+		}
+		for _, frame := range dropHeartbeats(decodeFrames(data)) {
+			status := frame.Autotune
+			if status == nil {
+				if started {
+					errLog.Printf("autotune aborted: a new target was set\n")
+					return 1
+				}
+				// Frames from before the device started the autotune
+				continue
+			}
+			started = true
+			switch status.State {
+			case coil.AutotuneRunning:
+				if terminal {
+					fmt.Printf("\rautotune: oscillation %d of %d, temp %.2f   ", status.Cycle, status.Cycles, frame.Temp)
+				} else if status.Cycle != lastCycle {
+					infoLog.Printf("autotune: oscillation %d of %d\n", status.Cycle, status.Cycles)
+				}
+				lastCycle = status.Cycle
+				continue
+			case coil.AutotuneDone:
+				if terminal {
+					fmt.Println()
+				}
+				g := status.Gains
+				output.Printf("suggested gains: p=%.4g i=%.4g d=%.4g (Ku=%.4g, Tu=%.1fs)\n", g.P, g.I, g.D, g.Ku, g.Tu)
+				if configFile != "" {
+					if err := writeGains(configFile, g); err != nil {
+						errLog.Printf("%s\n", err.Error())
+						return 1
+					}
+					infoLog.Printf("wrote gains to %s\n", configFile)
+				}
+				return 0
+			default:
+				if terminal {
+					fmt.Println()
+				}
+				errLog.Printf("autotune %s: %s\n", strings.Replace(status.State, "_", " ", -1), status.Reason)
+				return 1
+			}
+		}
+	}
+}
+
+// writeGains sets the PID gain variables in a .env style file, e.g. PI_HEATER_CONFIG_FILE,
+// keeping every other line and creating the file if needed.
+func writeGains(file string, g *coil.AutotuneGains) error {
+	values := map[string]string{
+		"PI_HEATER_PID_P": fmt.Sprintf("%g", g.P),
+		"PI_HEATER_PID_I": fmt.Sprintf("%g", g.I),
+		"PI_HEATER_PID_D": fmt.Sprintf("%g", g.D),
+	}
+	var lines []string
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error while reading %s: %s", file, err.Error())
+	}
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	for i, line := range lines {
+		key := strings.TrimSpace(strings.SplitN(line, "=", 2)[0])
+		if value, ok := values[key]; ok {
+			lines[i] = key + "=" + value
+			delete(values, key)
+		}
+	}
+	for _, key := range []string{"PI_HEATER_PID_P", "PI_HEATER_PID_I", "PI_HEATER_PID_D"} {
+		if value, ok := values[key]; ok {
+			lines = append(lines, key+"="+value)
+		}
+	}
+	if err := ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("error while writing %s: %s", file, err.Error())
+	}
+	return nil
+}
+
+// abortAutotune asks the device to stop the autotune in progress.
+func abortAutotune(httpClient *http.Client, httpBase string) error {
+	req, err := http.NewRequest("DELETE", httpBase+"/autotune", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/raphaelreyna/pi-heater/internal/mdns"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// discoverTimeout is how long -discover waits for servers to answer.
+const discoverTimeout = 2 * time.Second
+
+// discover browses for servers advertising over mDNS and returns the address of the one to connect to,
+// reading which one to pick from in when several answer, and whether it serves TLS.
+func discover(in io.Reader, infoLog *log.Logger) (string, bool, error) {
+	instances, err := mdns.Browse(discoverTimeout)
+	if err != nil {
+		return "", false, err
+	}
+	switch len(instances) {
+	case 0:
+		return "", false, errors.New("no devices found; is PI_HEATER_MDNS set on them?")
+	case 1:
+		infoLog.Printf("found %s at %s\n", instances[0].Name, instances[0].Address())
+		return instances[0].Address(), instances[0].Value("tls") == "true", nil
+	}
+	for i, instance := range instances {
+		infoLog.Printf("%d) %s at %s\n", i+1, instance.Name, instance.Address())
+	}
+	fmt.Print("connect to which device? ")
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		return "", false, errors.New("error while reading choice of device: " + err.Error())
+	}
+	choice, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || choice < 1 || choice > len(instances) {
+		return "", false, errors.New("no device numbered " + strings.TrimSpace(line))
+	}
+	instance := instances[choice-1]
+	return instance.Address(), instance.Value("tls") == "true", nil
+}
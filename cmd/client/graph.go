@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline is a scrolling graph of temperature drawn on a single terminal line.
+type sparkline struct {
+	temps  []float64
+	target float64
+	width  int
+}
+
+func (s *sparkline) add(frame coil.CoilFrame) {
+	s.target = frame.Target
+	s.temps = append(s.temps, frame.Temp)
+	if len(s.temps) > s.width {
+		s.temps = s.temps[len(s.temps)-s.width:]
+	}
+}
+
+// draw redraws the line in place; the target is included in the scale so the graph shows how close it is.
+func (s *sparkline) draw(w io.Writer) {
+	if len(s.temps) == 0 {
+		return
+	}
+	min, max := s.target, s.target
+	for _, t := range s.temps {
+		min = math.Min(min, t)
+		max = math.Max(max, t)
+	}
+	var b strings.Builder
+	for _, t := range s.temps {
+		level := 0
+		if max > min {
+			level = int((t - min) / (max - min) * float64(len(sparks)-1))
+		}
+		b.WriteRune(sparks[level])
+	}
+	fmt.Fprintf(w, "\r\033[K%s %.2f -> %.2f", b.String(), s.temps[len(s.temps)-1], s.target)
+}
+
+// newSparkline sizes the graph to leave room for the temperature labels.
+func newSparkline(termWidth int) *sparkline {
+	width := termWidth - 20
+	if width < 10 {
+		width = 10
+	}
+	return &sparkline{width: width}
+}
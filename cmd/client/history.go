@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// printHistory prints the device's recorded frames since the given time or duration ago, as they stream in.
+func printHistory(httpClient *http.Client, httpBase, since string, out *printer, errLog *log.Logger) {
+	query := ""
+	if since != "" {
+		query = "?since=" + url.QueryEscape(since)
+	}
+	resp, err := httpClient.Get(httpBase + "/history" + query)
+	if err != nil {
+		errLog.Fatalf("error while requesting history: %s\n", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		errLog.Fatalf("error while requesting history: received non-200 status code: %s\n", resp.Status)
+	}
+	// One frame per line, so the whole history never has to fit in memory
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var frame coil.CoilFrame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			errLog.Fatalf("error while decoding history: %s\n", err.Error())
+		}
+		out.print(scanner.Bytes(), []coil.CoilFrame{frame})
+	}
+	if err := scanner.Err(); err != nil {
+		errLog.Fatalf("error while reading history: %s\n", err.Error())
+	}
+}
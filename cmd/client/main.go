@@ -1,86 +1,253 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/gorilla/websocket"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"time"
 )
 
+// defaultHost is where the server listens when run on the same machine with its default PI_HEATER_HTTP_PORT.
+const defaultHost = "127.0.0.1:8080"
+
 func main() {
 	var follow bool
 	var target float64
 	var host string
+	var every int
+	var useTLS bool
+	var insecure bool
+	var compress bool
+	var graph bool
+	var format string
+	var tmpl string
+	var showSummary bool
+	var onceThenWatch bool
+	var unit string
+	var history string
+	var estop bool
+	var discoverHost bool
+	var autotuneTarget float64
+	var autotuneConfig string
+	var textfile string
+	var textfilePrefix string
+	var quiet bool
+	var verbose bool
+	var aggregate string
+	var httpClient *http.Client
 	var ws *websocket.Conn
 	var wsDialer *websocket.Dialer
 	var resp *http.Response
 	var err error
-	var runWebSocket bool
 	var wg *sync.WaitGroup
+	var quit chan struct{}
 
-	infoLog := log.New(os.Stdout, "", 0)
+	// output is what was asked for, e.g. frames or gains; infoLog is everything else said along the way
+	output := log.New(os.Stdout, "", 0)
+	infoLog := output
 	errLog := log.New(os.Stderr, "", log.LstdFlags)
+	debugLog := log.New(ioutil.Discard, "", 0)
 
 	flag.BoolVar(&follow, "f", false, "follow the device status live (default: false)")
 	flag.Float64Var(&target, "t", -1.0, "set the target temperature, negative values will be ignored (default: -1.0)")
-	flag.StringVar(&host, "h", "127.0.0.1", "hostname of the device (default: 127.0.0.1)")
+	flag.StringVar(&unit, "unit", "", "unit of the target temperature: F, C or K (default: the device's unit)")
+	flag.StringVar(&host, "h", defaultHost, "host and port of the device (default: "+defaultHost+")")
+	flag.IntVar(&every, "every", 1, "when following, only receive every nth frame (default: 1)")
+	flag.BoolVar(&discoverHost, "discover", false, "find devices advertising over mDNS instead of using -h, choosing between them if there are several (default: false)")
+	flag.BoolVar(&useTLS, "tls", false, "connect over https:// and wss://; implied by an https:// or wss:// host (default: false)")
+	flag.BoolVar(&insecure, "insecure", false, "skip TLS certificate verification, e.g. for self-signed certificates (default: false)")
+	flag.BoolVar(&graph, "graph", false, "when following, draw a live sparkline of the temperature if stdout is a terminal (default: false)")
+	flag.BoolVar(&compress, "compress", false, "ask the device to compress the followed frame stream (default: false)")
+
+	flag.StringVar(&format, "o", "raw", "output format: raw (as sent by the device), json, text or csv (default: raw)")
+	flag.StringVar(&history, "history", "", "print the frames the device recorded since an RFC 3339 time or a duration ago, e.g. 1h, or all of them with -history all")
+	flag.StringVar(&tmpl, "template", "", "format each frame with a Go text/template instead of -o, e.g. '{{.Temp}}'; "+
+		"frames have the fields Temp, Target, FrameStart, FrameDuration, FireTime, TimeToTarget, ContinuousOn, IdleRemaining, Fault, "+
+		"PIDReset, ColdJunction, SensorFault and Terminated, and the funcs duty, celsius and kelvin are available")
+	flag.BoolVar(&showSummary, "summary", true, "when following, print session statistics on exit (default: true)")
+	flag.Float64Var(&autotuneTarget, "autotune", -1.0, "run an autotune around this target, in -unit, and print the suggested gains; negative values are ignored (default: -1.0)")
+	flag.StringVar(&autotuneConfig, "autotune-config", "", "also write the gains -autotune suggests to this .env style file, e.g. the device's PI_HEATER_CONFIG_FILE")
+	flag.BoolVar(&estop, "estop", false, "turn the coil off now and latch an emergency stop until the device's fault is cleared (default: false)")
+	flag.StringVar(&textfile, "textfile", "", "write the current status to this .prom file for node_exporter's textfile collector instead of printing it, e.g. from cron")
+	flag.StringVar(&textfilePrefix, "textfile-prefix", "pi_heater", "prefix of the metric names -textfile writes (default: pi_heater)")
+	flag.BoolVar(&quiet, "q", false, "only print the frames, gains or other output asked for; errors are still printed and exit non-zero (default: false)")
+	flag.BoolVar(&verbose, "v", false, "print timing and connection diagnostics to stderr (default: false)")
+	flag.StringVar(&aggregate, "aggregate", "", "follow the devices given as name=host arguments and serve them together on this address, e.g. :8090, "+
+		"at GET /zones, the /zones/ws websocket and each device's own API under /zones/{name}/")
+	flag.BoolVar(&onceThenWatch, "once-then-watch", false, "print the current status, then follow (default: false)")
 
 	flag.Parse()
 
+	if onceThenWatch {
+		follow = true
+	}
+	if quiet && verbose {
+		errLog.Fatalf("-q and -v can't be used together\n")
+	}
+	if quiet {
+		infoLog = log.New(ioutil.Discard, "", 0)
+	}
+	if verbose {
+		debugLog = log.New(os.Stderr, "", log.LstdFlags|log.Lmicroseconds)
+	}
+	out, err := newPrinter(format, output)
+	if err != nil {
+		errLog.Fatalf("%s\n", err.Error())
+	}
+	if tmpl != "" {
+		if err = out.setTemplate(tmpl); err != nil {
+			errLog.Fatalf("%s\n", err.Error())
+		}
+	}
+	stats := &summary{}
+
+	if discoverHost {
+		var discoveredTLS bool
+		// The choice between several devices has to be shown even under -q
+		host, discoveredTLS, err = discover(os.Stdin, output)
+		if err != nil {
+			errLog.Fatalf("error while discovering devices: %s\n", err.Error())
+		}
+		useTLS = useTLS || discoveredTLS
+	}
+	httpBase, wsBase := endpoints(host, useTLS)
+	debugLog.Printf("using %s and %s\n", httpBase, wsBase)
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	httpClient = &http.Client{Transport: &timedTransport{next: &http.Transport{TLSClientConfig: tlsConfig}, log: debugLog}}
+
+	if aggregate != "" {
+		zones, err := parseZones(flag.Args(), useTLS)
+		if err != nil {
+			errLog.Fatalf("%s\n", err.Error())
+		}
+		agg := newAggregator(zones, &websocket.Dialer{TLSClientConfig: tlsConfig}, infoLog, errLog)
+		errLog.Fatalf("error while aggregating devices: %s\n", agg.serve(aggregate).Error())
+	}
+
+	if format == "text" && tmpl == "" {
+		if display, err := fetchDisplay(httpClient, httpBase); err != nil {
+			debugLog.Printf("%s; rendering temperatures with the default display\n", err.Error())
+		} else {
+			out.display = display
+		}
+	}
+
+	if estop {
+		if !emergencyStop(httpClient, httpBase, infoLog, errLog) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if autotuneTarget >= 0.0 {
+		dialer := &websocket.Dialer{TLSClientConfig: tlsConfig}
+		os.Exit(autotune(httpClient, dialer, httpBase, wsBase, autotuneTarget, unit, autotuneConfig, output, infoLog, errLog))
+	}
+
+	if history != "" {
+		if history == "all" {
+			history = ""
+		}
+		printHistory(httpClient, httpBase, history, out, errLog)
+		os.Exit(0)
+	}
+
 	wg = &sync.WaitGroup{}
+	quit = make(chan struct{})
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, os.Kill)
 
 	if follow {
-		wsDialer = &websocket.Dialer{}
-		ws, _, err = wsDialer.Dial("ws://"+host+"/ws", nil)
+		wsDialer = &websocket.Dialer{TLSClientConfig: tlsConfig, EnableCompression: compress}
+		dialStart := time.Now()
+		var wsResp *http.Response
+		ws, wsResp, err = wsDialer.Dial(wsBase+"/ws", nil)
 		if err != nil {
 			errLog.Fatalf("error while dialing websocket connection")
 		}
+		debugLog.Printf("websocket connected in %s, extensions %q\n", time.Since(dialStart), wsResp.Header.Get("Sec-WebSocket-Extensions"))
+		if every > 1 {
+			err = ws.WriteJSON(map[string]int{"every": every})
+			if err != nil {
+				errLog.Fatalf("error while requesting every %d frames: %s\n", every, err.Error())
+			}
+		}
+		var spark *sparkline
+		if graph && isTerminal(os.Stdout) {
+			spark = newSparkline(terminalWidth(os.Stdout))
+		}
+		wg.Add(1)
 		go func() {
-			runWebSocket = true
-			wg.Add(1)
-			for runWebSocket {
+			defer wg.Done()
+			lastMessage := time.Now()
+			for {
 				_, data, err := ws.ReadMessage()
 				if err != nil {
 					ws.Close()
+					select {
+					case <-quit:
+						return
+					default:
+					}
 					errLog.Fatalf("error while reading websocket message from device\nexit\n")
 				}
-				infoLog.Println(string(data))
+				debugLog.Printf("received %d bytes %s after the previous message\n", len(data), time.Since(lastMessage))
+				lastMessage = time.Now()
+				frames := dropHeartbeats(decodeFrames(data))
+				if len(frames) == 0 {
+					continue
+				}
+				for _, frame := range frames {
+					stats.add(frame)
+				}
+				if spark != nil {
+					for _, frame := range frames {
+						spark.add(frame)
+					}
+					spark.draw(os.Stdout)
+				} else {
+					out.print(data, frames)
+				}
+				for _, frame := range frames {
+					if frame.Terminated {
+						ws.Close()
+						errLog.Printf("device shut down cleanly\nexit\n")
+						if showSummary {
+							stats.print(format, infoLog)
+						}
+						os.Exit(0)
+					}
+				}
 			}
-			wg.Done()
 		}()
 	}
 
-	if target >= 0.0 {
-		query := fmt.Sprintf("?target=%.2f", target)
-		req, err := http.NewRequest("POST", "http://"+host+"/"+query, nil)
-		if err != nil {
-			errLog.Printf("error while creating request for setting target temperature: %s\n", err.Error())
-		}
-		resp, err = http.DefaultClient.Do(req)
-		if err != nil {
-			errLog.Printf("error while carrying out request for setting target temperature: %s\n", err.Error())
-		}
-		if resp.StatusCode != http.StatusOK {
-			errLog.Printf("error while carrying out request for setting target temperature: received non-200 status code:  %s\n", resp.Status)
-		}
+	exitCode := 0
+	if target >= 0.0 && !setTarget(httpClient, httpBase, target, unit, infoLog, errLog) {
+		exitCode = 1
 	}
 
-	if !follow {
+	if !follow || onceThenWatch {
 		frame := func() []byte {
-			resp, err = http.DefaultClient.Get("http://" + host + "/")
+			resp, err = httpClient.Get(httpBase + "/")
 			if err != nil {
 				errLog.Printf("error while requesting device status: %s", err.Error())
 				return nil
 			}
 			if resp.StatusCode != http.StatusOK {
-				errLog.Printf("error while requesting device status: received non-200 status code: %s\n")
+				errLog.Printf("error while requesting device status: received non-200 status code: %s\n", resp.Status)
 				return nil
 			}
 			body, err := ioutil.ReadAll(resp.Body)
@@ -90,18 +257,152 @@ func main() {
 			}
 			return body
 		}()
-		if frame != nil {
-			infoLog.Println(string(frame))
+		if textfile != "" && !follow {
+			frames := decodeFrames(frame)
+			if len(frames) == 0 {
+				errLog.Fatalf("error while writing %s: no frame to write\n", textfile)
+			}
+			if err = writeTextfile(textfile, textfilePrefix, frames[0]); err != nil {
+				errLog.Fatalf("%s\n", err.Error())
+			}
+		} else if frame != nil {
+			out.print(frame, decodeFrames(frame))
 		} else {
 			errLog.Printf("error while getting frame; couldn't determine the error though ...\n")
+			exitCode = 1
 		}
 	}
 
 	if follow {
 		<-sig
-		runWebSocket = false
+		close(quit)
+		ws.Close()
 		wg.Wait()
-		os.Exit(0)
+		if graph && isTerminal(os.Stdout) {
+			output.Println()
+		}
+		if showSummary {
+			stats.print(format, infoLog)
+		}
+	}
+	os.Exit(exitCode)
+}
+
+// setTarget asks the device to heat to target and reports what it accepted, returning whether it did.
+func setTarget(httpClient *http.Client, httpBase string, target float64, unit string, infoLog, errLog *log.Logger) bool {
+	query := fmt.Sprintf("?target=%.2f", target)
+	if unit != "" {
+		query += "&unit=" + url.QueryEscape(unit)
+	}
+	req, err := http.NewRequest("POST", httpBase+"/"+query, nil)
+	if err != nil {
+		errLog.Printf("error while creating request for setting target temperature: %s\n", err.Error())
+		return false
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		errLog.Printf("error while carrying out request for setting target temperature: %s\n", err.Error())
+		return false
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		errLog.Printf("error while reading response for setting target temperature: %s\n", err.Error())
+		return false
+	}
+	if resp.StatusCode != http.StatusOK {
+		errLog.Printf("error while carrying out request for setting target temperature: %s: %s\n", resp.Status, errorMessage(body))
+		return false
+	}
+	var accepted struct {
+		Target float64 `json:"target"`
+		Unit   string  `json:"unit"`
+	}
+	if err := json.Unmarshal(body, &accepted); err != nil {
+		errLog.Printf("target set, but could not decode the device's confirmation: %s\n", err.Error())
+		return true
+	}
+	infoLog.Printf("target set to %.2f %s\n", accepted.Target, accepted.Unit)
+	return true
+}
+
+// emergencyStop asks the device to turn the coil off and latch a fault, reporting whether it did.
+func emergencyStop(httpClient *http.Client, httpBase string, infoLog, errLog *log.Logger) bool {
+	resp, err := httpClient.Post(httpBase+"/estop", "", nil)
+	if err != nil {
+		errLog.Printf("error while requesting emergency stop: %s\n", err.Error())
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		errLog.Printf("error while requesting emergency stop: %s: %s\n", resp.Status, errorMessage(body))
+		return false
+	}
+	infoLog.Printf("emergency stop latched; the coil stays off until the fault is cleared\n")
+	return true
+}
+
+// errorMessage pulls the message out of the device's JSON error body, falling back to the body itself.
+func errorMessage(body []byte) string {
+	var e struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &e); err == nil && e.Error != "" {
+		return e.Error
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// endpoints returns the HTTP and websocket base URLs for host.
+// A scheme on host selects TLS on its own, otherwise useTLS decides.
+func endpoints(host string, useTLS bool) (string, string) {
+	for _, scheme := range []string{"https://", "wss://"} {
+		if strings.HasPrefix(host, scheme) {
+			host = strings.TrimPrefix(host, scheme)
+			useTLS = true
+		}
+	}
+	for _, scheme := range []string{"http://", "ws://"} {
+		host = strings.TrimPrefix(host, scheme)
+	}
+	host = strings.TrimSuffix(host, "/")
+	if useTLS {
+		return "https://" + host, "wss://" + host
+	}
+	return "http://" + host, "ws://" + host
+}
+
+// decodeFrames decodes every frame in a websocket message.
+// Queued frames may arrive in a single message separated by newlines.
+func decodeFrames(data []byte) []coil.CoilFrame {
+	var frames []coil.CoilFrame
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var frame coil.CoilFrame
+		if err := dec.Decode(&frame); err != nil {
+			return frames
+		}
+		frames = append(frames, frame)
+	}
+}
+
+// dropHeartbeats filters out heartbeat frames, which only say the stream is alive.
+func dropHeartbeats(frames []coil.CoilFrame) []coil.CoilFrame {
+	kept := frames[:0]
+	for _, frame := range frames {
+		if !frame.Heartbeat {
+			kept = append(kept, frame)
+		}
+	}
+	return kept
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
 	}
-	os.Exit(0)
+	return info.Mode()&os.ModeCharDevice != 0
 }
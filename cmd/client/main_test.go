@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+)
+
+func TestEndpoints(t *testing.T) {
+	for _, tc := range []struct {
+		host     string
+		useTLS   bool
+		http, ws string
+	}{
+		{defaultHost, false, "http://127.0.0.1:8080", "ws://127.0.0.1:8080"},
+		{defaultHost, true, "https://127.0.0.1:8080", "wss://127.0.0.1:8080"},
+		{"kiln.local:8080/", false, "http://kiln.local:8080", "ws://kiln.local:8080"},
+		{"http://kiln.local:8080", false, "http://kiln.local:8080", "ws://kiln.local:8080"},
+		{"https://kiln.local", false, "https://kiln.local", "wss://kiln.local"},
+		{"wss://kiln.local", false, "https://kiln.local", "wss://kiln.local"},
+	} {
+		httpBase, wsBase := endpoints(tc.host, tc.useTLS)
+		if httpBase != tc.http || wsBase != tc.ws {
+			t.Errorf("endpoints(%q, %v) = %q, %q, want %q, %q", tc.host, tc.useTLS, httpBase, wsBase, tc.http, tc.ws)
+		}
+	}
+}
+
+func TestTerminatedFrameRoundTrip(t *testing.T) {
+	last := coil.CoilFrame{Seq: 41, Temp: 212, Target: 300, FrameStart: time.Date(2020, 1, 1, 10, 3, 0, 0, time.UTC), FrameDuration: 1000}
+	terminated := last
+	terminated.Terminated = true
+	var data []byte
+	// A frame, a heartbeat and the terminated frame, as the hub might batch them in one message
+	for _, frame := range []coil.CoilFrame{last, {Seq: 41, Heartbeat: true}, terminated} {
+		payload, err := json.Marshal(&frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(append(data, payload...), '\n')
+	}
+
+	frames := dropHeartbeats(decodeFrames(data))
+	if len(frames) != 2 || frames[0].Terminated || !frames[1].Terminated {
+		t.Fatalf("decoded %+v, want the frame then the terminated one", frames)
+	}
+	if frames[1].Seq != last.Seq || frames[1].Temp != last.Temp {
+		t.Errorf("terminated frame decoded as %+v, want the last frame's values", frames[1])
+	}
+	// The terminated frame repeats the last one, so it mustn't count twice
+	var stats summary
+	for _, frame := range frames {
+		stats.add(frame)
+	}
+	if stats.Frames != 1 {
+		t.Errorf("summary counted %d frames, want 1", stats.Frames)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+)
+
+// printer writes frames in the format selected with -o, or with the -template if one is given.
+type printer struct {
+	format string
+	tmpl   *template.Template
+	out    *log.Logger
+	// csvHeader is set once the csv header has been written
+	csvHeader bool
+	// display renders temperatures in text output; machine formats always carry them as sent
+	display coil.Display
+}
+
+// templateFuncs are available to -template on top of the coil.CoilFrame fields:
+// duty gives a frame's duty cycle in percent, and celsius and kelvin convert a temperature from the device's unit.
+var templateFuncs = template.FuncMap{
+	"duty": func(frame coil.CoilFrame) float64 {
+		if frame.FrameDuration == 0 {
+			return 0
+		}
+		return 100 * float64(frame.FireTime) / float64(frame.FrameDuration)
+	},
+	"celsius": func(temp float64) float64 {
+		return coil.Celsius.FromInternal(temp)
+	},
+	"kelvin": func(temp float64) float64 {
+		return coil.Kelvin.FromInternal(temp)
+	},
+}
+
+// setTemplate parses text as a text/template executed against each coil.CoilFrame.
+func (p *printer) setTemplate(text string) error {
+	tmpl, err := template.New("frame").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return errors.New("error while parsing template: " + err.Error())
+	}
+	p.tmpl = tmpl
+	return nil
+}
+
+func newPrinter(format string, out *log.Logger) (*printer, error) {
+	switch format {
+	case "raw", "json", "text", "csv":
+		return &printer{format: format, out: out, display: coil.DefaultDisplay}, nil
+	}
+	return nil, errors.New("unknown output format: " + format)
+}
+
+// print writes the frames decoded from data; raw output writes data exactly as the device sent it.
+func (p *printer) print(data []byte, frames []coil.CoilFrame) {
+	if p.tmpl != nil {
+		for _, frame := range frames {
+			var buf bytes.Buffer
+			if err := p.tmpl.Execute(&buf, frame); err != nil {
+				p.out.Printf("error while executing template: %s\n", err.Error())
+				return
+			}
+			p.out.Println(strings.TrimSuffix(buf.String(), "\n"))
+		}
+		return
+	}
+	if p.format == "raw" {
+		p.out.Println(string(data))
+		return
+	}
+	for _, frame := range frames {
+		switch p.format {
+		case "json":
+			payload, err := json.Marshal(&frame)
+			if err == nil {
+				p.out.Println(string(payload))
+			}
+		case "text":
+			p.out.Printf("%s temp=%s target=%s fire=%dms/%dms\n",
+				frame.FrameStart.Format("15:04:05"), p.display.Format(frame.Temp), p.display.Format(frame.Target),
+				frame.FireTime, frame.FrameDuration,
+			)
+		case "csv":
+			// The first two columns are what the server's replay mode reads
+			if !p.csvHeader {
+				p.out.Println("timestamp,temp,target,fire_time,frame_duration,fault")
+				p.csvHeader = true
+			}
+			p.out.Printf("%s,%g,%g,%d,%d,%s\n",
+				frame.FrameStart.Format(time.RFC3339Nano), frame.Temp, frame.Target, frame.FireTime, frame.FrameDuration, csvField(frame.Fault),
+			)
+		}
+	}
+}
+
+// fetchDisplay gets the device's display hints from GET /config; devices from before display hints get the default.
+func fetchDisplay(httpClient *http.Client, httpBase string) (coil.Display, error) {
+	resp, err := httpClient.Get(httpBase + "/config")
+	if err != nil {
+		return coil.Display{}, errors.New("error while requesting config: " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return coil.Display{}, errors.New("error while requesting config: received non-200 status code: " + resp.Status)
+	}
+	var cfg struct {
+		Display *coil.Display `json:"display"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		return coil.Display{}, errors.New("error while decoding config: " + err.Error())
+	}
+	if cfg.Display == nil {
+		return coil.DefaultDisplay, nil
+	}
+	return *cfg.Display, nil
+}
+
+// csvField quotes s if it holds a character that would break the row.
+func csvField(s string) string {
+	if strings.ContainsAny(s, ",\"\r\n") {
+		return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+	}
+	return s
+}
+
+// summary accumulates statistics over the frames seen while following.
+type summary struct {
+	Frames        int     `json:"frames"`
+	MinTemp       float64 `json:"min_temp"`
+	MaxTemp       float64 `json:"max_temp"`
+	AvgTemp       float64 `json:"avg_temp"`
+	TotalFireTime int64   `json:"total_fire_time"` // milliseconds
+	DutyCycle     float64 `json:"duty_cycle"`      // percent
+	// MaxOvershoot is the largest overshoot any frame reported, if one did.
+	MaxOvershoot *float64 `json:"max_overshoot,omitempty"`
+
+	sum         float64
+	totalWindow int64
+}
+
+func (s *summary) add(frame coil.CoilFrame) {
+	if frame.Terminated {
+		return
+	}
+	if s.Frames == 0 {
+		s.MinTemp, s.MaxTemp = frame.Temp, frame.Temp
+	}
+	s.Frames++
+	s.MinTemp = math.Min(s.MinTemp, frame.Temp)
+	s.MaxTemp = math.Max(s.MaxTemp, frame.Temp)
+	s.sum += frame.Temp
+	s.AvgTemp = s.sum / float64(s.Frames)
+	s.TotalFireTime += frame.FireTime
+	s.totalWindow += frame.FrameDuration
+	if s.totalWindow > 0 {
+		s.DutyCycle = 100 * float64(s.TotalFireTime) / float64(s.totalWindow)
+	}
+	if frame.MaxOvershoot != nil && (s.MaxOvershoot == nil || *frame.MaxOvershoot > *s.MaxOvershoot) {
+		overshoot := *frame.MaxOvershoot
+		s.MaxOvershoot = &overshoot
+	}
+}
+
+// print writes the summary as JSON under -o json and as text otherwise.
+func (s *summary) print(format string, out *log.Logger) {
+	if format == "json" {
+		payload, err := json.Marshal(s)
+		if err == nil {
+			out.Println(string(payload))
+		}
+		return
+	}
+	out.Printf("frames: %d\nmin temp: %.2f\nmax temp: %.2f\navg temp: %.2f\ntotal fire time: %.1fs\nduty cycle: %.1f%%\n",
+		s.Frames, s.MinTemp, s.MaxTemp, s.AvgTemp, float64(s.TotalFireTime)/1000, s.DutyCycle,
+	)
+	if s.MaxOvershoot != nil {
+		out.Printf("max overshoot: %.2f\n", *s.MaxOvershoot)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+)
+
+var testFrame = coil.CoilFrame{
+	Temp:          212.3456,
+	Target:        300,
+	FrameStart:    time.Date(2020, 1, 1, 10, 3, 0, 0, time.UTC),
+	FrameDuration: 1000,
+	FireTime:      250,
+}
+
+// render returns what a printer for format, with display, writes for testFrame.
+func render(t *testing.T, format string, display coil.Display) string {
+	t.Helper()
+	var buf bytes.Buffer
+	p, err := newPrinter(format, log.New(&buf, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.display = display
+	p.print(nil, []coil.CoilFrame{testFrame})
+	return buf.String()
+}
+
+func TestTextHonoursDisplay(t *testing.T) {
+	for _, tc := range []struct {
+		display coil.Display
+		want    string
+	}{
+		{coil.DefaultDisplay, "10:03:00 temp=212.35°F target=300.00°F fire=250ms/1000ms\n"},
+		{coil.Display{Unit: coil.Fahrenheit, Symbol: "°F", Decimals: 0}, "10:03:00 temp=212°F target=300°F fire=250ms/1000ms\n"},
+		{coil.Display{Unit: coil.Celsius, Symbol: "°C", Decimals: 1}, "10:03:00 temp=100.2°C target=148.9°C fire=250ms/1000ms\n"},
+		{coil.Display{Unit: coil.Kelvin, Symbol: "K", Decimals: 3}, "10:03:00 temp=373.342K target=422.039K fire=250ms/1000ms\n"},
+		{coil.Display{Decimals: 4}, "10:03:00 temp=212.3456 target=300.0000 fire=250ms/1000ms\n"},
+	} {
+		if got := render(t, "text", tc.display); got != tc.want {
+			t.Errorf("text with %+v:\n got %q\nwant %q", tc.display, got, tc.want)
+		}
+	}
+}
+
+func TestMachineFormatsIgnoreDisplay(t *testing.T) {
+	coarse := coil.Display{Unit: coil.Celsius, Symbol: "°C", Decimals: 0}
+	for _, format := range []string{"json", "csv"} {
+		want := render(t, format, coil.DefaultDisplay)
+		if got := render(t, format, coarse); got != want {
+			t.Errorf("%s changed with the display hints:\n got %q\nwant %q", format, got, want)
+		}
+		if !strings.Contains(want, "212.3456") {
+			t.Errorf("%s doesn't carry the temperature as sent: %q", format, want)
+		}
+	}
+}
+
+func TestFetchDisplay(t *testing.T) {
+	for _, tc := range []struct {
+		config string
+		want   coil.Display
+	}{
+		{`{"display": {"unit": "C", "symbol": "°C", "decimals": 1}}`, coil.Display{Unit: coil.Celsius, Symbol: "°C", Decimals: 1}},
+		{`{"display": {"decimals": 3}}`, coil.Display{Decimals: 3}},
+		{`{"target": 100}`, coil.DefaultDisplay},
+	} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/config" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(tc.config))
+		}))
+		got, err := fetchDisplay(http.DefaultClient, ts.URL)
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: %s", tc.config, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: display %+v, want %+v", tc.config, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import "os"
+
+// terminalWidth returns the width of the terminal attached to f, or 80 if it can't be determined.
+func terminalWidth(f *os.File) int {
+	return 80
+}
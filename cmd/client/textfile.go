@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// metricPrefix is what a Prometheus metric name may start with and be made of.
+var metricPrefix = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// writeTextfile writes frame's metrics, named with prefix, to file in the node_exporter textfile collector's format.
+// It writes a temporary file and renames it over file, so the collector never reads one half written.
+func writeTextfile(file, prefix string, frame coil.CoilFrame) error {
+	if !metricPrefix.MatchString(prefix) {
+		return errors.New("invalid metric prefix: " + prefix)
+	}
+	var b bytes.Buffer
+	metric := func(name, help, labels string, value float64) {
+		name = prefix + "_" + name
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s%s %g\n", name, help, name, name, labels, value)
+	}
+	unit := `{unit="` + string(coil.InternalUnit) + `"}`
+	metric("temperature_degrees", "Temperature read in the most recent window.", unit, frame.Temp)
+	metric("target_temperature_degrees", "Target temperature in the most recent window.", unit, frame.Target)
+	metric("fire_time_milliseconds", "Time the coil fired for in the most recent window.", "", float64(frame.FireTime))
+	metric("window_milliseconds", "Length of the most recent window.", "", float64(frame.FrameDuration))
+	faulted := 0.0
+	if frame.Fault != "" {
+		faulted = 1
+	}
+	metric("faulted", "Whether a fault is latched.", "", faulted)
+	metric("frame_timestamp_seconds", "When the device built the frame, in seconds since the epoch.", "",
+		float64(frame.Timestamp.UnixNano())/1e9,
+	)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return errors.New("error while creating textfile: " + err.Error())
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(b.Bytes()); err != nil {
+		tmp.Close()
+		return errors.New("error while writing textfile: " + err.Error())
+	}
+	// The collector runs as another user more often than not
+	if err = tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return errors.New("error while writing textfile: " + err.Error())
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.New("error while writing textfile: " + err.Error())
+	}
+	if err = os.Rename(tmp.Name(), file); err != nil {
+		return errors.New("error while replacing textfile: " + err.Error())
+	}
+	return nil
+}
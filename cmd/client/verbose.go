@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// timedTransport logs how long each request to the device takes under -v.
+type timedTransport struct {
+	next http.RoundTripper
+	log  *log.Logger
+}
+
+func (t *timedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.log.Printf("%s %s failed after %s: %s\n", req.Method, req.URL, time.Since(start), err.Error())
+		return nil, err
+	}
+	t.log.Printf("%s %s: %s in %s\n", req.Method, req.URL, resp.Status, time.Since(start))
+	return resp, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// logSettings logs every effective setting in one block, once the coil's configuration has been validated and
+// before its run loop starts. Webhook URLs are redacted down to their host, since they often embed tokens.
+func logSettings(c *coil.Coil, port string, infoLog *log.Logger) {
+	cfg := c.Config()
+	settings := [][2]string{}
+	add := func(name, format string, args ...interface{}) {
+		settings = append(settings, [2]string{name, fmt.Sprintf(format, args...)})
+	}
+
+	if cfg.ControlMode == coil.ControlBangBang {
+		add("control", "bangbang, hysteresis %g°%s", cfg.Hysteresis, cfg.Unit)
+	} else {
+		add("control", "pid, p=%g i=%g d=%g, derivative on %s", cfg.P, cfg.I, cfg.D, cfg.DerivativeMode)
+	}
+	add("window", "%dms, firing at most %dms", cfg.Window, cfg.MaxFire)
+	add("unit", "%s", cfg.Unit)
+	targets := fmt.Sprintf("min %g", cfg.MinTarget)
+	if cfg.MaxTarget != 0 {
+		targets += fmt.Sprintf(", max %g", cfg.MaxTarget)
+	}
+	add("targets", "%s", targets)
+	add("at temperature", "within %g° for %dms", cfg.Tolerance, cfg.Dwell)
+	if cfg.TempFloor != nil || cfg.TempCeiling != nil {
+		var limits []string
+		if cfg.TempFloor != nil {
+			limits = append(limits, fmt.Sprintf("floor %g", *cfg.TempFloor))
+		}
+		if cfg.TempCeiling != nil {
+			limits = append(limits, fmt.Sprintf("ceiling %g", *cfg.TempCeiling))
+		}
+		add("plausible readings", "%s", strings.Join(limits, ", "))
+	}
+
+	if cfg.MonitorOnly {
+		add("monitor only", "the coil is never turned on")
+	}
+	switch {
+	case cfg.Simulated:
+		add("devices", "simulated")
+	case os.Getenv("PI_HEATER_REPLAY_FILE") != "":
+		add("devices", "replaying %s", os.Getenv("PI_HEATER_REPLAY_FILE"))
+	default:
+		source := os.Getenv("PI_HEATER_TEMP_SOURCE")
+		if source == "" {
+			source = "file"
+		}
+		add("thermocouple", "%s (%s)", os.Getenv("PI_HEATER_TEMP_DEV_FILE"), source)
+		if second := os.Getenv("PI_HEATER_TEMP_DEV_FILE_2"); second != "" {
+			add("thermocouple 2", "%s", second)
+		}
+		if os.Getenv("PI_HEATER_STATUS_BACKEND") == "gpio" {
+			add("status device", "gpio pin %s", os.Getenv("PI_HEATER_STATUS_GPIO_PIN"))
+		} else {
+			add("status device", "%s", os.Getenv("PI_HEATER_STATUS_DEV_FILE"))
+		}
+	}
+
+	add("bind address", ":%s", port)
+	if os.Getenv("PI_HEATER_TLS_CERT") != "" {
+		add("tls", "on, certificate %s", os.Getenv("PI_HEATER_TLS_CERT"))
+	} else {
+		add("tls", "off")
+	}
+	// Only /logs authenticates requests; say so rather than leave it to be guessed
+	if os.Getenv("PI_HEATER_LOG_BUFFER") != "" {
+		add("auth", "bearer token for /logs only")
+	} else {
+		add("auth", "off")
+	}
+	for _, name := range []string{"PI_HEATER_WEBHOOK_FAULT", "PI_HEATER_WEBHOOK_REACHED", "PI_HEATER_WEBHOOK_DONE"} {
+		if u := os.Getenv(name); u != "" {
+			add(strings.ToLower(strings.TrimPrefix(name, "PI_HEATER_")), "%s", redactURL(u))
+		}
+	}
+	for _, name := range []string{
+		"PI_HEATER_INSTANCE_LABEL", "PI_HEATER_MDNS", "PI_HEATER_PPROF", "PI_HEATER_WS_COMPRESSION", "PI_HEATER_FRAME_RATE", "PI_HEATER_HEARTBEAT", "PI_HEATER_HTTP_MAX_CONNS",
+		"PI_HEATER_DEBUG", "PI_HEATER_SOFTSTART_WINDOWS", "PI_HEATER_MAX_DUTY", "PI_HEATER_NOMINAL_VOLTAGE", "PI_HEATER_IDLE_TIMEOUT", "PI_HEATER_DEADMAN_TIMEOUT", "PI_HEATER_RISE_TIMEOUT", "PI_HEATER_SHUTDOWN_RAMP", "PI_HEATER_PRESETS_FILE", "PI_HEATER_CONFIG_FILE",
+	} {
+		if v := os.Getenv(name); v != "" {
+			add(strings.ToLower(strings.TrimPrefix(name, "PI_HEATER_")), "%s", v)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("effective settings:\n")
+	for _, setting := range settings {
+		fmt.Fprintf(&b, "  %-16s %s\n", setting[0]+":", setting[1])
+	}
+	infoLog.Print(b.String())
+}
+
+// redactURL keeps only the scheme and host of u.
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return "(redacted)"
+	}
+	return parsed.Scheme + "://" + parsed.Host + "/(redacted)"
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// setEnv replaces every PI_HEATER_ variable with env, restoring them when t ends.
+func setEnv(t *testing.T, env map[string]string) {
+	var saved []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "PI_HEATER_") {
+			saved = append(saved, kv)
+			os.Unsetenv(kv[:strings.Index(kv, "=")])
+		}
+	}
+	t.Cleanup(func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+		for _, kv := range saved {
+			i := strings.Index(kv, "=")
+			os.Setenv(kv[:i], kv[i+1:])
+		}
+	})
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+}
+
+// syncBuffer is a bytes.Buffer that loggers on several goroutines can share.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// testLoggers returns error and info loggers that both write to the returned buffer.
+func testLoggers() (*log.Logger, *log.Logger, *syncBuffer) {
+	buf := &syncBuffer{}
+	return log.New(buf, "ERROR: ", 0), log.New(buf, "INFO: ", 0), buf
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rejection is sent to plain HTTP connections over the limit, in the server's JSON error envelope.
+const rejection = `{"error":"too many connections","code":503}`
+
+// limitListener sets TCP keepalive on every connection it accepts and, if max is set, holds at most max of them open
+// at once. Connections over the limit are answered with a 503, or just closed when serving TLS, rather than left
+// waiting in the backlog.
+type limitListener struct {
+	net.Listener
+	keepAlive time.Duration // zero disables keepalive
+	sem       chan struct{}
+	tls       bool
+}
+
+func newLimitListener(ln net.Listener, max int, keepAlive time.Duration, tls bool) *limitListener {
+	l := &limitListener{Listener: ln, keepAlive: keepAlive, tls: tls}
+	if max > 0 {
+		l.sem = make(chan struct{}, max)
+	}
+	return l
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetKeepAlive(l.keepAlive > 0)
+			if l.keepAlive > 0 {
+				tcp.SetKeepAlivePeriod(l.keepAlive)
+			}
+		}
+		if l.sem == nil {
+			return conn, nil
+		}
+		select {
+		case l.sem <- struct{}{}:
+			return &limitConn{Conn: conn, release: func() { <-l.sem }}, nil
+		default:
+			go l.reject(conn)
+		}
+	}
+}
+
+// reject turns away a connection over the limit without reading its request.
+func (l *limitListener) reject(conn net.Conn) {
+	defer conn.Close()
+	if l.tls {
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte("HTTP/1.1 503 Service Unavailable\r\nContent-Type: application/json\r\nRetry-After: 1\r\n" +
+		"Connection: close\r\nContent-Length: " + strconv.Itoa(len(rejection)) + "\r\n\r\n" + rejection))
+}
+
+// limitConn frees its place under the limit when it is closed, including after a websocket hijacks it.
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
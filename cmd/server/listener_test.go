@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// get sends a keep-alive GET over conn and returns the response status and body.
+func get(t *testing.T, conn net.Conn) (int, string) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n")); err != nil {
+		return 0, err.Error()
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestLimitListenerRefusesConnectionsOverCap(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	go srv.Serve(newLimitListener(ln, 2, time.Minute, false))
+	defer srv.Close()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	// Both stay open and idle after their requests, holding their places
+	held := []net.Conn{dial(), dial()}
+	for i, conn := range held {
+		defer conn.Close()
+		if status, body := get(t, conn); status != http.StatusOK {
+			t.Fatalf("connection %d under the cap: %d %s", i, status, body)
+		}
+	}
+
+	over := dial()
+	defer over.Close()
+	status, body := get(t, over)
+	if status != http.StatusServiceUnavailable || body != rejection {
+		t.Errorf("connection over the cap: %d %q, want 503 %q", status, body, rejection)
+	}
+	over.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := over.Read(make([]byte, 1)); err == nil {
+		t.Error("the connection over the cap was left open")
+	}
+
+	// Closing a held connection frees its place once the server notices
+	held[0].Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn := dial()
+		status, _ := get(t, conn)
+		conn.Close()
+		if status == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("a closed connection's place was never freed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLimitListenerUnlimited(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go srv.Serve(newLimitListener(ln, 0, 0, false))
+	defer srv.Close()
+	for i := 0; i < 10; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if status, body := get(t, conn); status != http.StatusOK {
+			t.Fatalf("connection %d without a cap: %d %s", i, status, body)
+		}
+	}
+}
@@ -3,61 +3,278 @@
 // Author: Raphael Reyna
 //
 // Environment Variables:
+// PI_HEATER_CONFIG_FILE - File of these variables in .env format, loaded like .env; edits to the PID gains, PID_MAX and REACHED_TOLERANCE in it apply without a restart
+// PI_HEATER_SIMULATE - Simulate the thermocouple and heating element instead of using device files (default: false)
+// PI_HEATER_SIM_HEAT_RATE - Degrees per second the simulated element heats at while on; 0 simulates a dead element (default: 5)
+// PI_HEATER_REPLAY_FILE - Play back a CSV of timestamp,temp readings instead of using device files
+// PI_HEATER_REPLAY_FAST - Replay as fast as possible on the recorded timeline rather than in real time (default: false)
 // PI_HEATER_TEMP_DEV_FILE - Device file from which to read temperature
+// PI_HEATER_TEMP_DEV_FILE_2 - Optional device file for a second, redundant thermocouple
+// PI_HEATER_TC_STRATEGY - How to combine two thermocouples for control: average (default), min or max
+// PI_HEATER_TC_DISAGREE_MAX - Fault if two thermocouples disagree by more than this many degrees; 0 disables (default: 50)
+// PI_HEATER_TEMP_SOURCE - How to read PI_HEATER_TEMP_DEV_FILE: file (plain numeric readings, default) or max31855
+// PI_HEATER_TEMP_DIVISOR - What plain file readings are divided by to get degrees Celsius, e.g. 1000 for hwmon's millidegrees ; applied before the unit conversion, and ignored by max31855 (default: 4, i.e. quarter degrees)
+// PI_HEATER_STATUS_BACKEND - How to turn the coil on and off: file (writing PI_HEATER_STATUS_DEV_FILE, default) or gpio, which drives PI_HEATER_STATUS_GPIO_PIN through /dev/gpiomem's registers with periph.io's bcm283x driver, without exporting it through sysfs. gpio works on Linux on the Raspberry Pi up to the 4, not the Pi 5
 // PI_HEATER_STATUS_DEV_FILE - Device file from which to turn coil on and off
-// PI_HEATER_START_TEMP - Temperature to heat coil to on start
-// PI_HEATER_PID_P - P parameter for PID controller
-// PI_HEATER_PID_I - I parameter for PID controller
-// PI_HEATER_PID_D - D parameter for PID controller
-// PI_HEATER_PID_MAX - Max value clamp on PID controller value
-// PI_HEATER_HTTP_PORT - Port over which to serve HTTP traffic
+// PI_HEATER_STATUS_GPIO_PIN - BCM number, not the header pin, of the relay's GPIO pin when PI_HEATER_STATUS_BACKEND is gpio, e.g. 17 for header pin 11
+// PI_HEATER_DEVICE_OPEN_RETRIES - Times to retry device files that are missing or not yet permitted at startup, e.g. a GPIO udev hasn't finished exporting (default: 0)
+// PI_HEATER_DEVICE_OPEN_INTERVAL - Wait before the first device file retry, doubling each retry up to 30s (default: 1s)
+// PI_HEATER_INSECURE_GPIO_CHECK - Allow device files that aren't character devices or sysfs files, like -insecure-gpio-check (default: false)
+// PI_HEATER_STATUS_ON - Bytes written to the status device to turn the coil on (default: 1)
+// PI_HEATER_STATUS_OFF - Bytes written to the status device to turn the coil off (default: 0)
+// PI_HEATER_INVERT_OUTPUT - Swap the on and off values, or drive the gpio pin low for on, for active-low relays (default: false)
+// PI_HEATER_START_TEMP - Temperature to heat coil to on start, unless -t is given
+// PI_HEATER_CONTROL_MODE - Control law: pid (default) or bangbang, which fires fully until the top of the hysteresis band
+// PI_HEATER_HYSTERESIS - Degrees either side of the target for bang-bang control (default: 5)
+// PI_HEATER_PID_P - P parameter for PID controller; optional in bang-bang mode
+// PI_HEATER_PID_I - I parameter for PID controller; optional in bang-bang mode
+// PI_HEATER_PID_D - D parameter for PID controller; optional in bang-bang mode
+// PI_HEATER_PID_DERIV_MODE - Take the PID derivative of the measurement (default) or the error
+// PI_HEATER_PID_MAX - Max value clamp on PID controller value; also the window length in milliseconds
+// PI_HEATER_BREAKER_FAILURES - Status device write failures that latch a fault (default: 3)
+// PI_HEATER_BREAKER_WINDOW - Window over which write failures are counted (default: 1m)
+// PI_HEATER_FEEDFORWARD_GAIN - Milliseconds of fire time added per degree of target above ambient (default: 0)
+// PI_HEATER_AMBIENT_TEMP - Ambient temperature used by the feedforward term (default: 70)
+// PI_HEATER_MAX_FIRE_SLEW_MS_PER_WINDOW - Max change in fire time between consecutive windows; disabled by default
+// PI_HEATER_MONITOR_ONLY - Read and report the temperature and fire times without ever turning the coil on, for commissioning (default: false)
+// PI_HEATER_MAX_DUTY - Percent the duty cycle averaged over PI_HEATER_MAX_DUTY_WINDOW may reach, for elements not rated for 100%; longer pulses are shortened (default: 100)
+// PI_HEATER_MAX_DUTY_WINDOW - Rolling window the duty cycle is averaged over (default: 1m)
+// PI_HEATER_NOMINAL_VOLTAGE - Supply voltage the gains were tuned at, e.g. 240; setting it scales the fire time by (nominal/supply)² so a sagging supply delivers the same power, and routes POST /voltage to report the measured supply. Disabled by default
+// PI_HEATER_SUPPLY_VOLTAGE - Measured supply voltage to start with, until POST /voltage reports another (default: PI_HEATER_NOMINAL_VOLTAGE)
+// PI_HEATER_SOFTSTART_WINDOWS - After a target increase of PI_HEATER_SOFTSTART_STEP or more, cap the fire time to a limit rising to the max over this many windows; disabled by default
+// PI_HEATER_SOFTSTART_STEP - Target increase in degrees that triggers the soft start (default: 50)
+// PI_HEATER_COALESCE_GAP - Keep the coil on across window boundaries when it would only turn off this long, e.g. 50ms; must be shorter than PI_HEATER_PID_MAX; disabled by default
+// PI_HEATER_MAX_CONTINUOUS_ON - Force an off window once the coil has been on this long without a break (e.g. 60s); disabled by default
+// PI_HEATER_RISE_TIMEOUT - Fault with "failed to heat" if a target increase doesn't raise the temperature by PI_HEATER_MIN_RISE within this long, e.g. 10m, while firing hard; disabled by default
+// PI_HEATER_MIN_RISE - Degrees the temperature must rise by within PI_HEATER_RISE_TIMEOUT (default: 10)
+// PI_HEATER_RISE_MIN_DUTY - Percent of the time the coil must have fired for a slow rise to fault; the guard starts over otherwise (default: 50)
+// PI_HEATER_SPIKE_WARMUP - Readings to take before the thermocouple spike detector arms (default: 1)
+// PI_HEATER_TIMER_WORKERS - Drive the coil from a shared 10ms timer wheel with this many workers instead of per-timer goroutines; disabled by default
+// PI_HEATER_AUTOTUNE_TIMEOUT - Give up on an autotune that hasn't measured its oscillations within this long; 0 disables (default: 2h)
+// PI_HEATER_MAX_BAD_READS - Blank or non-numeric thermocouple reads in a row to ride out on the last temperature before faulting (default: 3)
+// PI_HEATER_STALE_AFTER - How old the last good thermocouple reading may get before /health returns 503 (default: 5 control windows)
+// PI_HEATER_HISTORY_SIZE - Number of recent frames kept in memory (default: 3600)
+// PI_HEATER_DISPLAY_UNIT - Unit clients render temperatures in for people: F, C, K or raw, without a symbol; frames stay in F (default: F)
+// PI_HEATER_DISPLAY_DECIMALS - Decimals clients render temperatures to for people (default: 2)
+// PI_HEATER_INSTANCE_LABEL - Attached to every metric as the instance label, to tell heaters scraped by one Prometheus apart
+// PI_HEATER_SHUTDOWN_RAMP - On a kill signal, ramp the target down to ambient over this long before turning off, e.g. 10m; a second signal turns off at once. Disabled by default
+// PI_HEATER_HTTP_PORT - Port over which to serve HTTP traffic (default: 8080)
+// PI_HEATER_PRESETS_FILE - JSON file in which named presets are saved; kept in memory only if unset
+// PI_HEATER_HTTP_READ_TIMEOUT - Max time to read a request, including its body (default: 10s)
+// PI_HEATER_HTTP_WRITE_TIMEOUT - Max time to write a response; websockets are exempt once upgraded (default: 30s)
+// PI_HEATER_LOG_BUFFER - Keep this many of the latest log lines in memory and serve them at GET /logs; off by default
+// PI_HEATER_LOGS_TOKEN - Bearer token GET /logs requires; required with PI_HEATER_LOG_BUFFER
+// PI_HEATER_HTTP_MAX_CONNS - Most connections, websockets included, to hold open at once; the rest get a 503 and are closed. Unlimited by default
+// PI_HEATER_HTTP_KEEPALIVE - TCP keepalive interval on accepted connections, so dead peers are noticed and closed; 0 disables (default: 30s)
+// PI_HEATER_HTTP_IDLE_TIMEOUT - Max time to keep an idle keep-alive connection open (default: 2m)
+// PI_HEATER_TLS_CERT - Certificate file for serving HTTPS and WSS; requires PI_HEATER_TLS_KEY
+// PI_HEATER_TLS_KEY - Private key file for the TLS certificate
+// PI_HEATER_WS_COMPRESSION - Compress the websocket frame stream for clients that support it (default: false)
+// PI_HEATER_FRAME_RATE - Broadcast websocket frames at most this often, e.g. 500ms, sending the latest; every frame by default
+// PI_HEATER_HEARTBEAT - Send websocket clients a heartbeat frame once this long goes by without a frame, e.g. 5s; disabled by default
+// PI_HEATER_MDNS - Advertise the server as _pi-heater._tcp over mDNS, named by PI_HEATER_INSTANCE_LABEL or the host name, for the client's -discover (default: false)
+// PI_HEATER_PPROF - Serve net/http/pprof profiles under /debug/pprof/ (default: false)
+// PI_HEATER_DEBUG - Include run loop diagnostics in every frame
+// PI_HEATER_REACHED_TOLERANCE - Degrees from the target within which the coil counts as at temperature (default: 5)
+// PI_HEATER_REACHED_DWELL - How long the temperature must hold within tolerance to be at temperature (default: 1m)
+// PI_HEATER_WEBHOOK_FAULT - URL to POST a JSON event to when the coil faults
+// PI_HEATER_WEBHOOK_REACHED - URL to POST a JSON event to when the coil reaches its target
+// PI_HEATER_WEBHOOK_DONE - URL to POST a JSON event to when a bake's hold completes and the coil turns off
+// PI_HEATER_WEBHOOK_STYLE - Webhook payload: raw (the event as JSON, default), slack or discord
+// PI_HEATER_MIN_TARGET - Lowest target a relative adjustment can set (default: 0)
+// PI_HEATER_MAX_TARGET - Highest target the coil will heat to; higher targets are clamped to it. Unlimited by default
+// PI_HEATER_TEMP_FLOOR - Lowest plausible reading; anything below latches a fault rather than driving the heat up. Unbounded by default
+// PI_HEATER_TEMP_CEILING - Highest plausible reading, independent of PI_HEATER_MAX_TARGET and above it; anything higher latches a fault. Unbounded by default
+// PI_HEATER_IDLE_TIMEOUT - Drop to PI_HEATER_IDLE_TARGET after this long without a new target (e.g. 4h); disabled by default
+// PI_HEATER_IDLE_TARGET - Safe target to drop to once the idle timeout passes (default: 0)
+// PI_HEATER_DEADMAN_TIMEOUT - Once a websocket client sets the target, drop it to PI_HEATER_DEADMAN_TARGET if no websocket target follows within this long, e.g. 30s; disabled by default
+// PI_HEATER_DEADMAN_TARGET - Safe target the deadman drops to (default: 0)
 
 package main
 
 import (
-	"github.com/raphaelreyna/pi-heater/pkg/coil"
-	"github.com/raphaelreyna/pi-heater/internal/http-server"
-	"github.com/raphaelreyna/pi-heater/internal/websocket-hub"
+	"errors"
 	"flag"
 	"github.com/joho/godotenv"
+	"github.com/raphaelreyna/pi-heater/internal/http-server"
+	"github.com/raphaelreyna/pi-heater/internal/mdns"
+	"github.com/raphaelreyna/pi-heater/internal/webhook"
+	"github.com/raphaelreyna/pi-heater/internal/websocket-hub"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"sync"
+	"time"
 )
 
+// maxLogBuffer bounds PI_HEATER_LOG_BUFFER, and so the memory the lines for GET /logs can take.
+const maxLogBuffer = 10000
+
 func main() {
+	startTemp := flag.Float64("t", -1, "starting temperature, 0 to start off; overrides PI_HEATER_START_TEMP when not negative")
+	flag.BoolVar(&coil.SkipDeviceCheck, "insecure-gpio-check", false,
+		"allow device files that aren't character devices or sysfs files, e.g. FIFOs or plain files for testing")
+	flag.Parse()
+
 	godotenv.Load()
+	configFile := os.Getenv("PI_HEATER_CONFIG_FILE")
+	if configFile != "" {
+		if err := godotenv.Load(configFile); err != nil {
+			log.Fatalf("error while loading PI_HEATER_CONFIG_FILE: %s\n", err.Error())
+		}
+	}
 	name := os.Args[0]
 	errLog := log.New(os.Stderr, name+" ERROR: ", log.LstdFlags|log.Lshortfile)
 	infoLog := log.New(os.Stdout, name+" INFO: ", log.LstdFlags)
+	logBuffer, logsToken := newLogBuffer()
+	if logBuffer != nil {
+		errLog.SetOutput(io.MultiWriter(os.Stderr, logBuffer))
+		infoLog.SetOutput(io.MultiWriter(os.Stdout, logBuffer))
+	}
+
+	port, err := httpPort()
+	if err != nil {
+		errLog.Fatalf("%s\n", err.Error())
+	}
 
 	wg := &sync.WaitGroup{}
 	c, err := coil.NewCoil(errLog, infoLog)
 	if err != nil {
-		panic(err)
+		errLog.Fatalf("could not set up coil: %s\n", err.Error())
 	}
 
-	c.WaitGroup = wg
+	logSettings(c, port, infoLog)
+
+	coilDone := c.Done()
+	// Run has already logged why if it returns an error
+	runErr := runCoil(c, wg)
 
-	go c.Run()
+	setStartingTemp(c, *startTemp, infoLog, errLog)
+	if configFile != "" {
+		go watchConfig(c, configFile, errLog, infoLog)
+	}
 
-	setStartingTemp(c, infoLog, errLog)
+	urls := map[string]string{
+		coil.EventFault:   os.Getenv("PI_HEATER_WEBHOOK_FAULT"),
+		coil.EventReached: os.Getenv("PI_HEATER_WEBHOOK_REACHED"),
+		coil.EventDone:    os.Getenv("PI_HEATER_WEBHOOK_DONE"),
+	}
+	if urls[coil.EventFault] != "" || urls[coil.EventReached] != "" || urls[coil.EventDone] != "" {
+		notifier := webhook.NewNotifier(urls, errLog, infoLog)
+		notifier.Style, err = webhook.ParseStyle(os.Getenv("PI_HEATER_WEBHOOK_STYLE"))
+		if err != nil {
+			errLog.Fatalf("error while parsing PI_HEATER_WEBHOOK_STYLE: %s\n", err.Error())
+		}
+		events, _ := c.Subscribe()
+		go notifier.Run(events)
+	}
 
 	wsHub := hub.NewHub(c, infoLog, errLog)
-	wsHub.WaitGroup = wg
-	go wsHub.Run()
+	if s := os.Getenv("PI_HEATER_WS_COMPRESSION"); s != "" {
+		wsHub.Compression, err = strconv.ParseBool(s)
+		if err != nil {
+			errLog.Fatalf("error while parsing PI_HEATER_WS_COMPRESSION: %s\n", err.Error())
+		}
+	}
+	if s := os.Getenv("PI_HEATER_FRAME_RATE"); s != "" {
+		wsHub.FrameInterval, err = time.ParseDuration(s)
+		if err != nil {
+			errLog.Fatalf("error while parsing PI_HEATER_FRAME_RATE: %s\n", err.Error())
+		}
+	}
+	if s := os.Getenv("PI_HEATER_HEARTBEAT"); s != "" {
+		wsHub.Heartbeat, err = time.ParseDuration(s)
+		if err != nil {
+			errLog.Fatalf("error while parsing PI_HEATER_HEARTBEAT: %s\n", err.Error())
+		}
+	}
+	runHub(wsHub, wg)
 
 	s := server.NewServer(c, wsHub, errLog, infoLog)
-	port := os.Getenv("PI_HEATER_HTTP_PORT")
-	infoLog.Printf("starting HTTP server; listening on port %s\n", port)
+	s.InstanceLabel = os.Getenv("PI_HEATER_INSTANCE_LABEL")
+	if v := os.Getenv("PI_HEATER_PPROF"); v != "" {
+		enable, err := strconv.ParseBool(v)
+		if err != nil {
+			errLog.Fatalf("error while parsing PI_HEATER_PPROF: %s\n", err.Error())
+		}
+		if enable {
+			s.EnablePprof()
+		}
+	}
+	if logBuffer != nil {
+		s.EnableLogs(logBuffer, logsToken)
+	}
+	if file := os.Getenv("PI_HEATER_PRESETS_FILE"); file != "" {
+		if err = s.LoadPresets(file); err != nil {
+			errLog.Fatalf("%s\n", err.Error())
+		}
+	} else {
+		infoLog.Printf("PI_HEATER_PRESETS_FILE is not set; saved presets will be lost on exit\n")
+	}
+	httpServer, err := newHTTPServer(s)
+	if err != nil {
+		errLog.Fatalf("%s\n", err.Error())
+	}
+	certFile := os.Getenv("PI_HEATER_TLS_CERT")
+	keyFile := os.Getenv("PI_HEATER_TLS_KEY")
+	if (certFile == "") != (keyFile == "") {
+		errLog.Fatalf("PI_HEATER_TLS_CERT and PI_HEATER_TLS_KEY must be set together\n")
+	}
+	maxConns := 0
+	if s := os.Getenv("PI_HEATER_HTTP_MAX_CONNS"); s != "" {
+		if maxConns, err = strconv.Atoi(s); err != nil {
+			errLog.Fatalf("error while parsing PI_HEATER_HTTP_MAX_CONNS: %s\n", err.Error())
+		}
+	}
+	keepAlive := 30 * time.Second
+	if s := os.Getenv("PI_HEATER_HTTP_KEEPALIVE"); s != "" {
+		if keepAlive, err = time.ParseDuration(s); err != nil {
+			errLog.Fatalf("error while parsing PI_HEATER_HTTP_KEEPALIVE: %s\n", err.Error())
+		}
+	}
+	infoLog.Printf("starting HTTP server on port %s\n", port)
+	ln, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		errLog.Printf("error while listening on port %s: %s\n", port, err.Error())
+		c.Send(c.Stop, struct{}{})
+		wsHub.Stop <- struct{}{}
+		wg.Wait()
+		os.Exit(1)
+	}
+	ln = newLimitListener(ln, maxConns, keepAlive, certFile != "")
+	var responder *mdns.Responder
+	if v := os.Getenv("PI_HEATER_MDNS"); v != "" {
+		advertise, err := strconv.ParseBool(v)
+		if err != nil {
+			errLog.Fatalf("error while parsing PI_HEATER_MDNS: %s\n", err.Error())
+		}
+		if advertise {
+			responder, err = newResponder(port, certFile != "", errLog, infoLog)
+			if err != nil {
+				// Heating doesn't depend on being discoverable
+				errLog.Printf("%s; not advertising over mDNS\n", err.Error())
+			} else {
+				go responder.Run()
+			}
+		}
+	}
 	go func() {
-		err := http.ListenAndServe(":"+port, s)
+		var err error
+		if certFile != "" {
+			infoLog.Printf("serving HTTPS on %s\n", ln.Addr())
+			err = httpServer.ServeTLS(ln, certFile, keyFile)
+		} else {
+			infoLog.Printf("serving HTTP on %s\n", ln.Addr())
+			err = httpServer.Serve(ln)
+		}
 		if err != nil {
 			errLog.Printf("error from http server: %s\n", err.Error())
-			c.Stop <- struct{}{}
+			c.Send(c.Stop, struct{}{})
 			wsHub.Stop <- struct{}{}
 			wg.Wait()
 			os.Exit(0)
@@ -67,26 +284,147 @@ func main() {
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, os.Kill)
 
-	<-sig
-	infoLog.Printf("received kill signal\n")
-	c.Stop <- struct{}{}
+	select {
+	case <-sig:
+		infoLog.Printf("received kill signal\n")
+	case <-coilDone:
+		errLog.Printf("coil run loop exited on its own; shutting down\n")
+	}
+	select {
+	case c.Shutdown <- struct{}{}:
+	case <-coilDone:
+	}
+	select {
+	case <-coilDone:
+	case <-sig:
+		infoLog.Printf("received second kill signal; turning the coil off now\n")
+		select {
+		case c.Stop <- struct{}{}:
+		case <-coilDone:
+		}
+		<-coilDone
+	}
+	if responder != nil {
+		responder.Close()
+	}
 	wsHub.Stop <- struct{}{}
 	wg.Wait()
+	if <-runErr != nil {
+		os.Exit(1)
+	}
 	os.Exit(0)
 }
 
-func setStartingTemp(c *coil.Coil, infoLog, errLog *log.Logger) {
-	var st float64
-	var err error
-	flag.Float64Var(&st, "t", 0, "temperature")
-	flag.Parse()
-	if st == 0 {
-		startTempS := os.Getenv("PI_HEATER_START_TEMP")
-		st, err = strconv.ParseFloat(startTempS, 64)
-		if err != nil {
-			errLog.Fatalf("could not determine starting temperature from PI_HEATER_START_TEMP environment variable")
+// runCoil starts c's run loop as part of wg, adding to wg before the goroutine starts so a wg.Wait can't return
+// before the loop has. What Run returns is sent on the returned channel.
+func runCoil(c *coil.Coil, wg *sync.WaitGroup) <-chan error {
+	c.WaitGroup = wg
+	runErr := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		runErr <- c.Run()
+	}()
+	return runErr
+}
+
+// runHub starts h's run loop as part of wg, the same way.
+func runHub(h *hub.Hub, wg *sync.WaitGroup) {
+	h.WaitGroup = wg
+	wg.Add(1)
+	go h.Run()
+}
+
+// newLogBuffer returns the buffer for GET /logs and the token it's served with, or nil if PI_HEATER_LOG_BUFFER isn't set.
+// The token and webhook URLs are redacted from the buffer.
+func newLogBuffer() (*server.LogBuffer, string) {
+	s := os.Getenv("PI_HEATER_LOG_BUFFER")
+	if s == "" {
+		return nil, ""
+	}
+	size, err := strconv.Atoi(s)
+	if err != nil || size < 1 || size > maxLogBuffer {
+		log.Fatalf("PI_HEATER_LOG_BUFFER must be between 1 and %d lines\n", maxLogBuffer)
+	}
+	token := os.Getenv("PI_HEATER_LOGS_TOKEN")
+	if token == "" {
+		log.Fatalf("PI_HEATER_LOGS_TOKEN is required with PI_HEATER_LOG_BUFFER\n")
+	}
+	return server.NewLogBuffer(size, token, os.Getenv("PI_HEATER_WEBHOOK_FAULT"),
+		os.Getenv("PI_HEATER_WEBHOOK_REACHED"), os.Getenv("PI_HEATER_WEBHOOK_DONE")), token
+}
+
+// newResponder advertises the server on port over mDNS, named by PI_HEATER_INSTANCE_LABEL if it is set.
+// The TXT record tells clients whether to connect with TLS.
+func newResponder(port string, useTLS bool, errLog, infoLog *log.Logger) (*mdns.Responder, error) {
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, errors.New("error while parsing port for mDNS: " + err.Error())
+	}
+	txt := []string{"tls=" + strconv.FormatBool(useTLS)}
+	return mdns.NewResponder(os.Getenv("PI_HEATER_INSTANCE_LABEL"), n, txt, errLog, infoLog)
+}
+
+// httpPort returns PI_HEATER_HTTP_PORT, defaulting to 8080 when unset.
+func httpPort() (string, error) {
+	port := os.Getenv("PI_HEATER_HTTP_PORT")
+	if port == "" {
+		return "8080", nil
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return "", errors.New("invalid PI_HEATER_HTTP_PORT: " + port + " is not a port number between 1 and 65535")
+	}
+	return port, nil
+}
+
+// newHTTPServer wraps handler in an http.Server with timeouts, so slow clients can't tie up connections indefinitely.
+func newHTTPServer(handler http.Handler) (*http.Server, error) {
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	timeouts := []struct {
+		name    string
+		def     time.Duration
+		timeout *time.Duration
+	}{
+		{"PI_HEATER_HTTP_READ_TIMEOUT", 10 * time.Second, &server.ReadTimeout},
+		{"PI_HEATER_HTTP_WRITE_TIMEOUT", 30 * time.Second, &server.WriteTimeout},
+		{"PI_HEATER_HTTP_IDLE_TIMEOUT", 2 * time.Minute, &server.IdleTimeout},
+	}
+	for _, t := range timeouts {
+		*t.timeout = t.def
+		if s := os.Getenv(t.name); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, errors.New("error while parsing " + t.name + ": " + err.Error())
+			}
+			*t.timeout = d
 		}
 	}
+	if server.ReadTimeout > 0 && server.ReadTimeout < server.ReadHeaderTimeout {
+		server.ReadHeaderTimeout = server.ReadTimeout
+	}
+	return server, nil
+}
+
+func setStartingTemp(c *coil.Coil, st float64, infoLog, errLog *log.Logger) {
+	st, err := startingTemp(st, os.Getenv("PI_HEATER_START_TEMP"))
+	if err != nil {
+		errLog.Fatalf("%s\n", err.Error())
+	}
 	infoLog.Printf("setting initial temperature to %.2ff\n", st)
-	c.SetTarget <- st
+	if err = c.Send(c.SetTarget, coil.TargetCommand{Target: st, Source: "startup"}); err != nil {
+		errLog.Printf("error while setting initial temperature: %s\n", err.Error())
+	}
+}
+
+// startingTemp picks the starting target: the -t flag if it was given, which may be 0 to start off,
+// and PI_HEATER_START_TEMP otherwise. A negative flag means it was not given.
+func startingTemp(flagTemp float64, env string) (float64, error) {
+	if flagTemp >= 0 {
+		return flagTemp, nil
+	}
+	st, err := strconv.ParseFloat(env, 64)
+	if err != nil {
+		return 0, errors.New("could not determine starting temperature from -t or the PI_HEATER_START_TEMP environment variable")
+	}
+	return st, nil
 }
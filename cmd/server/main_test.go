@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/raphaelreyna/pi-heater/internal/websocket-hub"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+)
+
+func TestStartingTemp(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		flag    float64
+		env     string
+		want    float64
+		wantErr bool
+	}{
+		{"flag and env", 250, "300", 250, false},
+		{"flag of zero and env", 0, "300", 0, false},
+		{"flag and bad env", 250, "hot", 250, false},
+		{"env only", -1, "300", 300, false},
+		{"env of zero only", -1, "0", 0, false},
+		{"bad env only", -1, "hot", 0, true},
+		{"neither", -1, "", 0, true},
+	} {
+		got, err := startingTemp(tc.flag, tc.env)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: startingTemp(%v, %q) error = %v, want error %v", tc.name, tc.flag, tc.env, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: startingTemp(%v, %q) = %v, want %v", tc.name, tc.flag, tc.env, got, tc.want)
+		}
+	}
+}
+
+func TestRunLoopsJoinTheWaitGroupBeforeStarting(t *testing.T) {
+	setEnv(t, map[string]string{
+		"PI_HEATER_SIMULATE": "1",
+		"PI_HEATER_PID_P":    "5",
+		"PI_HEATER_PID_I":    "0.1",
+		"PI_HEATER_PID_D":    "1",
+		"PI_HEATER_PID_MAX":  "1000",
+	})
+	// Each loop runs alone in its WaitGroup, so the other's Add can't hold Wait up for it
+	for _, loop := range []struct {
+		name string
+		// run starts the loop on wg and returns how to stop it and whether it has stopped
+		run func(c *coil.Coil, wg *sync.WaitGroup) (stop chan struct{}, stopped func() bool)
+	}{
+		{"coil", func(c *coil.Coil, wg *sync.WaitGroup) (chan struct{}, func() bool) {
+			runCoil(c, wg)
+			return c.Stop, func() bool {
+				select {
+				case <-c.Done():
+					return true
+				default:
+					return false
+				}
+			}
+		}},
+		{"hub", func(c *coil.Coil, wg *sync.WaitGroup) (chan struct{}, func() bool) {
+			h := hub.NewHub(c, nil, nil)
+			runHub(h, wg)
+			// A running hub answers with an empty list, never nil
+			return h.Stop, func() bool { return h.Clients() == nil }
+		}},
+	} {
+		t.Run(loop.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				c, err := coil.NewCoil(nil, nil)
+				if err != nil {
+					t.Fatalf("NewCoil: %s", err)
+				}
+				wg := &sync.WaitGroup{}
+				stop, stopped := loop.run(c, wg)
+				go func() {
+					stop <- struct{}{}
+				}()
+
+				// Under -race, an Add from inside the goroutine also races this Wait
+				wg.Wait()
+				if !stopped() {
+					t.Fatalf("wg.Wait returned before the %s's run loop did", loop.name)
+				}
+			}
+		})
+	}
+}
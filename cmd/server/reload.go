@@ -0,0 +1,130 @@
+package main
+
+import (
+	"github.com/joho/godotenv"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// configPollInterval is how often PI_HEATER_CONFIG_FILE is checked for changes.
+const configPollInterval = time.Second
+
+// reloadable are the settings a change to PI_HEATER_CONFIG_FILE applies while the server runs.
+// Everything else, device paths above all, only takes effect on a restart.
+var reloadable = map[string]bool{
+	"PI_HEATER_PID_P":             true,
+	"PI_HEATER_PID_I":             true,
+	"PI_HEATER_PID_D":             true,
+	"PI_HEATER_PID_MAX":           true,
+	"PI_HEATER_REACHED_TOLERANCE": true,
+}
+
+// watchConfig polls file, in the same format as .env, and applies changes to the reloadable settings in one step
+// through the coil's ApplyPreset channel. A change the coil rejects is logged and nothing from it is applied.
+func watchConfig(c *coil.Coil, file string, errLog, infoLog *log.Logger) {
+	last, err := godotenv.Read(file)
+	if err != nil {
+		errLog.Printf("error while reading PI_HEATER_CONFIG_FILE, not watching it: %s\n", err.Error())
+		return
+	}
+	info, _ := os.Stat(file)
+	infoLog.Printf("watching %s for changes to PID gains, window and tolerance\n", file)
+	for range time.Tick(configPollInterval) {
+		latest, err := os.Stat(file)
+		if err != nil || (info != nil && latest.ModTime().Equal(info.ModTime()) && latest.Size() == info.Size()) {
+			continue
+		}
+		info = latest
+		current, err := godotenv.Read(file)
+		if err != nil {
+			errLog.Printf("error while reading PI_HEATER_CONFIG_FILE: %s\n", err.Error())
+			continue
+		}
+		changed := changedKeys(last, current)
+		last = current
+		if len(changed) > 0 {
+			reloadConfig(c, changed, current, errLog, infoLog)
+		}
+	}
+}
+
+// changedKeys lists, in order, the keys whose values differ between before and after.
+func changedKeys(before, after map[string]string) []string {
+	var changed []string
+	for key, value := range after {
+		if old, ok := before[key]; !ok || old != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func reloadConfig(c *coil.Coil, changed []string, values map[string]string, errLog, infoLog *log.Logger) {
+	var preset coil.Preset
+	var applied []string
+	for _, key := range changed {
+		if !reloadable[key] {
+			errLog.Printf("%s changed in PI_HEATER_CONFIG_FILE but only takes effect on a restart\n", key)
+			continue
+		}
+		value, ok := values[key]
+		if !ok {
+			errLog.Printf("%s was removed from PI_HEATER_CONFIG_FILE; keeping its current value\n", key)
+			continue
+		}
+		if key == "PI_HEATER_PID_MAX" {
+			max, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				errLog.Printf("rejected config change: error while parsing %s: %s\n", key, err.Error())
+				return
+			}
+			preset.Max = &max
+		} else {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				errLog.Printf("rejected config change: error while parsing %s: %s\n", key, err.Error())
+				return
+			}
+			switch key {
+			case "PI_HEATER_PID_P":
+				preset.P = &v
+			case "PI_HEATER_PID_I":
+				preset.I = &v
+			case "PI_HEATER_PID_D":
+				preset.D = &v
+			case "PI_HEATER_REACHED_TOLERANCE":
+				preset.Tolerance = &v
+			}
+		}
+		applied = append(applied, key+"="+value)
+	}
+	if len(applied) == 0 {
+		return
+	}
+	if (preset.P != nil || preset.I != nil || preset.D != nil) && c.Config().ControlMode == coil.ControlBangBang {
+		errLog.Printf("rejected config change: PID gains do nothing in bangbang control mode\n")
+		return
+	}
+	reply := make(chan coil.PresetResult, 1)
+	if err := c.Send(c.ApplyPreset, coil.PresetRequest{Preset: preset, Reply: reply}); err != nil {
+		errLog.Printf("rejected config change: %s\n", err.Error())
+		return
+	}
+	if result := <-reply; result.Err != nil {
+		errLog.Printf("rejected config change: %s\n", result.Err.Error())
+		return
+	}
+	for _, change := range applied {
+		infoLog.Printf("applied %s from PI_HEATER_CONFIG_FILE\n", change)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+)
+
+func TestReloadWindowIsValidatedLikeALiveChange(t *testing.T) {
+	setEnv(t, map[string]string{
+		"PI_HEATER_SIMULATE":     "1",
+		"PI_HEATER_PID_P":        "5",
+		"PI_HEATER_PID_I":        "0.1",
+		"PI_HEATER_PID_D":        "1",
+		"PI_HEATER_PID_MAX":      "1000",
+		"PI_HEATER_COALESCE_GAP": "500ms",
+	})
+	errLog, infoLog, logs := testLoggers()
+	c, err := coil.NewCoil(errLog, infoLog)
+	if err != nil {
+		t.Fatalf("NewCoil: %s", err)
+	}
+	go c.Run()
+	defer func() {
+		c.Stop <- struct{}{}
+		<-c.Done()
+	}()
+
+	// No longer than PI_HEATER_COALESCE_GAP, so the coil would never turn off between windows
+	reloadConfig(c, []string{"PI_HEATER_PID_MAX"}, map[string]string{"PI_HEATER_PID_MAX": "500"}, errLog, infoLog)
+	if !strings.Contains(logs.String(), "rejected config change") {
+		t.Errorf("reloading PI_HEATER_PID_MAX=500 wasn't rejected; logs:\n%s", logs)
+	}
+	if window := c.Config().Window; window != 1000 {
+		t.Fatalf("a rejected reload changed the window to %dms", window)
+	}
+
+	reloadConfig(c, []string{"PI_HEATER_PID_MAX"}, map[string]string{"PI_HEATER_PID_MAX": "600"}, errLog, infoLog)
+	if cfg := c.Config(); cfg.Window != 600 || cfg.MaxFire != 600-15 {
+		t.Errorf("window %dms with max fire %dms after reloading PI_HEATER_PID_MAX=600, want 600ms and 585ms", cfg.Window, cfg.MaxFire)
+	}
+	if !strings.Contains(logs.String(), "changed the control window from 1s to 600ms") {
+		t.Errorf("reloading PI_HEATER_PID_MAX didn't go through changeWindow; logs:\n%s", logs)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"net/http"
+	"strconv"
+)
+
+// handleAutotune starts an autotune; its progress and suggested gains are reported in frames.
+func (s *Server) handleAutotune() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, err := strconv.ParseFloat(r.URL.Query().Get("target"), 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "error while parsing target: "+err.Error())
+			return
+		}
+		unit, err := coil.ParseUnit(r.URL.Query().Get("unit"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		reply := make(chan error, 1)
+		if !s.send(w, s.coil.Autotune, coil.AutotuneRequest{Target: unit.ToInternal(target), Reply: reply}) {
+			return
+		}
+		if err := <-reply; err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		s.infoLogFor(r).Printf("started autotune at request of %s\n", r.RemoteAddr)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (s *Server) handleStopAutotune() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.send(w, s.coil.StopAutotune, struct{}{}) {
+			return
+		}
+		s.infoLogFor(r).Printf("stopped autotune at request of %s\n", r.RemoteAddr)
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bakeResponse echoes the accepted bake, with the target in the coil's internal unit.
+type bakeResponse struct {
+	Target float64   `json:"target"`
+	Unit   coil.Unit `json:"unit"`
+	Hold   float64   `json:"hold"` // seconds
+}
+
+func (s *Server) handleBake() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		target, err := strconv.ParseFloat(query.Get("target"), 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "error while parsing target: "+err.Error())
+			return
+		}
+		hold, err := parseHold(query.Get("hold"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		unit, err := coil.ParseUnit(query.Get("unit"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		reply := make(chan float64, 1)
+		if !s.send(w, s.coil.Bake, coil.BakeRequest{Target: unit.ToInternal(target), Hold: hold, Reply: reply}) {
+			return
+		}
+		resp := bakeResponse{Target: <-reply, Unit: coil.InternalUnit, Hold: hold.Seconds()}
+		s.infoLogFor(r).Printf("started bake at %.2f%s for %s at request of %s\n", resp.Target, resp.Unit, hold, r.RemoteAddr)
+
+		payload, err := json.Marshal(&resp)
+		if err != nil {
+			s.errLogFor(r).Printf("error while marshaling JSON for bake: %s", err.Error())
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(payload)
+	}
+}
+
+func (s *Server) handleCancelBake() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.send(w, s.coil.CancelBake, struct{}{}) {
+			return
+		}
+		s.infoLogFor(r).Printf("cancelled bake at request of %s\n", r.RemoteAddr)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// parseHold parses a positive hold time, either as a duration such as 90m or as a number of minutes;
+// a min suffix is read as minutes too, e.g. 30min.
+func parseHold(s string) (time.Duration, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "min")
+	var hold time.Duration
+	if minutes, err := strconv.ParseFloat(s, 64); err == nil {
+		hold = time.Duration(minutes * float64(time.Minute))
+	} else if hold, err = time.ParseDuration(s); err != nil {
+		return 0, errors.New("error while parsing hold: " + err.Error())
+	}
+	if hold <= 0 {
+		return 0, errors.New("hold must be positive")
+	}
+	return hold, nil
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// eventsKeepAlive is how often an idle event stream is pinged so proxies don't drop it.
+const eventsKeepAlive = 15 * time.Second
+
+var eventsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// handleEvents streams the coil's events over a websocket, or as server-sent events otherwise.
+// Either way the stream opens with a state event describing the coil as it is now.
+// SSE clients that reconnect with a Last-Event-ID header first get the recent events they missed.
+func (s *Server) handleEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			s.serveEventsWS(w, r)
+			return
+		}
+		s.serveEventsSSE(w, r)
+	}
+}
+
+// stateEvent describes the coil's current state to a new subscriber.
+func (s *Server) stateEvent() coil.CoilEvent {
+	frame := s.coil.CurrentFrame()
+	return coil.CoilEvent{
+		Type:   coil.EventState,
+		Time:   time.Now(),
+		Temp:   frame.Temp,
+		Target: s.coil.Target(),
+		Unit:   coil.InternalUnit,
+		Phase:  frame.Phase,
+		Reason: frame.Fault,
+	}
+}
+
+func (s *Server) serveEventsWS(w http.ResponseWriter, r *http.Request) {
+	u := eventsUpgrader
+	u.Error = func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		writeError(w, status, reason.Error())
+	}
+	conn, err := u.Upgrade(w, r, nil)
+	if err != nil {
+		s.errLogFor(r).Printf("error while upgrading events connection: %s", err.Error())
+		return
+	}
+	defer conn.Close()
+	_, events, cancel := s.coil.SubscribeSince(^uint64(0))
+	defer cancel()
+
+	// Nothing is read from clients, but reading notices when they hang up
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(event coil.CoilEvent) bool {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(&event) == nil
+	}
+	if !write(s.stateEvent()) {
+		return
+	}
+	ping := time.NewTicker(eventsKeepAlive)
+	defer ping.Stop()
+	for {
+		select {
+		case event := <-events:
+			// Nothing follows the coil stopping
+			if !write(event) || event.Type == coil.EventStopped {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+func (s *Server) serveEventsSSE(w http.ResponseWriter, r *http.Request) {
+	lastID, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	if err != nil {
+		lastID = ^uint64(0)
+	}
+
+	// Streams outlive the server's write timeout, so take over the connection and clear its deadlines.
+	// HTTP/2 connections can't be hijacked; those streams end at the write timeout and the client reconnects.
+	var out io.Writer = w
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	closed := r.Context().Done()
+	if hj, ok := w.(http.Hijacker); ok {
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			s.errLogFor(r).Printf("error while hijacking events connection: %s", err.Error())
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Time{})
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nCache-Control: no-cache\r\nConnection: close\r\n\r\n")
+		out = rw
+		flush = func() { rw.Flush() }
+		hungUp := make(chan struct{})
+		go func(r *bufio.Reader) {
+			defer close(hungUp)
+			io.Copy(ioutil.Discard, r)
+		}(rw.Reader)
+		closed = hungUp
+	} else {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	backlog, events, cancel := s.coil.SubscribeSince(lastID)
+	defer cancel()
+	write := func(event coil.CoilEvent) {
+		payload, err := json.Marshal(&event)
+		if err != nil {
+			s.errLogFor(r).Printf("error while marshaling JSON for event: %s", err.Error())
+			return
+		}
+		if event.ID != 0 {
+			fmt.Fprintf(out, "id: %d\n", event.ID)
+		}
+		fmt.Fprintf(out, "event: %s\ndata: %s\n\n", event.Type, payload)
+	}
+	write(s.stateEvent())
+	for _, event := range backlog {
+		write(event)
+	}
+	flush()
+
+	ping := time.NewTicker(eventsKeepAlive)
+	defer ping.Stop()
+	for {
+		select {
+		case event := <-events:
+			write(event)
+			if event.Type == coil.EventStopped {
+				flush()
+				return
+			}
+		case <-ping.C:
+			io.WriteString(out, ": ping\n\n")
+		case <-closed:
+			return
+		}
+		flush()
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// gzipThreshold is the smallest response worth compressing; single frames stay well below it.
+const gzipThreshold = 1400
+
+// compressible reports whether r accepts gzip and isn't a streaming request that needs unbuffered writes.
+func compressible(r *http.Request) bool {
+	// Streams are never buffered, whether or not the client says it wants an event stream
+	if r.Header.Get("Upgrade") != "" || strings.Contains(r.Header.Get("Accept"), "text/event-stream") || r.URL.Path == "/events" {
+		return false
+	}
+	// Profiles are already compressed
+	if strings.HasPrefix(r.URL.Path, "/debug/pprof/") {
+		return false
+	}
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.Split(encoding, ";")[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the response until it reaches gzipThreshold, then switches to gzip.
+// Responses that never reach the threshold are written uncompressed by Close.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	buf    []byte
+	gz     *gzip.Writer
+	status int
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < gzipThreshold {
+		return len(p), nil
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	if _, err := w.gz.Write(w.buf); err != nil {
+		return 0, err
+	}
+	w.buf = nil
+	return len(p), nil
+}
+
+func (w *gzipResponseWriter) Close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf)
+	return err
+}
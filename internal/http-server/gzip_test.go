@@ -0,0 +1,87 @@
+package server
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGzipNegotiation(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	rig.waitReady(t)
+	rig.waitFrames(t, 30)
+
+	plain := rig.do("GET", "/history", nil)
+	if len(plain.Body.Bytes()) < gzipThreshold {
+		t.Fatalf("history is only %d bytes, too small to test compression", plain.Body.Len())
+	}
+	if got := plain.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("history without Accept-Encoding has Content-Encoding %q", got)
+	}
+
+	w := rig.do("GET", "/history", nil, "Accept-Encoding", "deflate, gzip;q=0.8")
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("large history has Content-Encoding %q, want gzip", got)
+	}
+	if w.Code != http.StatusOK || w.Body.Len() >= plain.Body.Len() {
+		t.Errorf("gzipped history: %d, %d bytes against %d plain", w.Code, w.Body.Len(), plain.Body.Len())
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("error while opening gzipped history: %s", err)
+	}
+	body, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("error while decompressing history: %s", err)
+	}
+	if got, want := decodeAll(t, body), decodeAll(t, plain.Body.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Errorf("gzipped history decompresses to %d frames, not the %d sent plain", len(got), len(want))
+	}
+
+	for _, req := range []struct {
+		method, target string
+		body           string
+		status         int
+	}{
+		{"GET", "/", "", http.StatusOK},
+		{"GET", "/config", "", http.StatusOK},
+		{"GET", "/nonesuch", "", http.StatusNotFound},
+		{"POST", "/", strings.Repeat(" ", maxBodyBytes+1), http.StatusRequestEntityTooLarge},
+	} {
+		header := []string{"Accept-Encoding", "gzip"}
+		if req.body != "" {
+			header = append(header, "Content-Type", "application/json")
+		}
+		w := rig.do(req.method, req.target, strings.NewReader(req.body), header...)
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("small %s %s response has Content-Encoding %q", req.method, req.target, got)
+		}
+		if w.Code != req.status {
+			t.Errorf("%s %s with Accept-Encoding gzip = %d: %s", req.method, req.target, w.Code, w.Body)
+		}
+	}
+}
+
+func TestStreamsAreNeverCompressed(t *testing.T) {
+	for _, header := range [][]string{
+		{"Upgrade", "websocket"},
+		{"Accept", "text/event-stream"},
+	} {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		r.Header.Set(header[0], header[1])
+		if compressible(r) {
+			t.Errorf("request with %s: %s is compressible", header[0], header[1])
+		}
+	}
+	r := httptest.NewRequest("GET", "/events", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	if compressible(r) {
+		t.Error("/events is compressible")
+	}
+}
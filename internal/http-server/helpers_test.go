@@ -0,0 +1,196 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raphaelreyna/pi-heater/internal/websocket-hub"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+)
+
+// testEnv is the least configuration coil.NewCoil needs; it simulates the kiln so no device files are opened.
+var testEnv = map[string]string{
+	"PI_HEATER_SIMULATE": "1",
+	"PI_HEATER_PID_P":    "5",
+	"PI_HEATER_PID_I":    "0.1",
+	"PI_HEATER_PID_D":    "1",
+	"PI_HEATER_PID_MAX":  "1000",
+}
+
+// setEnv replaces every PI_HEATER_ variable with testEnv overlaid with env, restoring them when t ends.
+func setEnv(t testing.TB, env map[string]string) {
+	var saved []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "PI_HEATER_") {
+			saved = append(saved, kv)
+			os.Unsetenv(kv[:strings.Index(kv, "=")])
+		}
+	}
+	t.Cleanup(func() {
+		for _, kv := range os.Environ() {
+			if strings.HasPrefix(kv, "PI_HEATER_") {
+				os.Unsetenv(kv[:strings.Index(kv, "=")])
+			}
+		}
+		for _, kv := range saved {
+			i := strings.Index(kv, "=")
+			os.Setenv(kv[:i], kv[i+1:])
+		}
+	})
+	for k, v := range testEnv {
+		os.Setenv(k, v)
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+}
+
+// testRig is a server in front of a simulated coil driven by a MockClock.
+type testRig struct {
+	server *Server
+	coil   *coil.Coil
+	hub    *hub.Hub
+	clock  *coil.MockClock
+}
+
+// newTestRig configures the coil from testEnv and env; nothing runs until start.
+func newTestRig(t testing.TB, env map[string]string) *testRig {
+	t.Helper()
+	setEnv(t, env)
+	c, err := coil.NewCoil(nil, nil)
+	if err != nil {
+		t.Fatalf("NewCoil: %s", err)
+	}
+	clock := coil.NewMockClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	c.Clock = clock
+	c.Simulator().Clock = clock
+	h := hub.NewHub(c, nil, nil)
+	return &testRig{server: NewServer(c, h, nil, nil), coil: c, hub: h, clock: clock}
+}
+
+// start runs the coil and hub until t ends.
+func (rig *testRig) start(t testing.TB) {
+	go rig.coil.Run()
+	go rig.hub.Run()
+	t.Cleanup(func() {
+		rig.coil.Send(rig.coil.Stop, struct{}{})
+		<-rig.coil.Done()
+		rig.hub.Stop <- struct{}{}
+	})
+}
+
+// window is the coil's control window.
+func (rig *testRig) window() time.Duration {
+	return time.Duration(rig.coil.Config().Window) * time.Millisecond
+}
+
+// waitFrames advances the clock a window at a time until the coil has published n more frames.
+func (rig *testRig) waitFrames(t testing.TB, n uint64) {
+	t.Helper()
+	want := rig.coil.Metrics().LastSeq + n
+	deadline := time.Now().Add(5 * time.Second)
+	for rig.coil.CurrentFrame().Seq < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for frame %d", want)
+		}
+		// A tick is dropped if the loop hasn't started yet or is still busy with the last one, so keep ticking
+		rig.clock.Advance(rig.window())
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitReady runs the coil until it is ready, which is just after its first good frame is published.
+func (rig *testRig) waitReady(t testing.TB) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !rig.coil.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the coil to be ready")
+		}
+		rig.clock.Advance(rig.window())
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// stopCoil ends the coil's run loop, leaving the server and hub up.
+func (rig *testRig) stopCoil(t testing.TB) {
+	t.Helper()
+	rig.coil.Stop <- struct{}{}
+	select {
+	case <-rig.coil.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the coil to stop")
+	}
+}
+
+// do serves a request straight from the server's handler.
+func (rig *testRig) do(method, target string, body io.Reader, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, body)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	rig.server.ServeHTTP(w, r)
+	return w
+}
+
+// serve starts a test HTTP server for the rig, closed when t ends.
+func (rig *testRig) serve(t testing.TB) *httptest.Server {
+	ts := httptest.NewServer(rig.server)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// wsURL converts a test server's URL to a websocket one for path.
+func wsURL(ts *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(ts.URL, "http") + path
+}
+
+// within fails t unless f returns in a few seconds; a handler blocked on a stopped coil never would.
+func within(t testing.TB, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s blocked", what)
+	}
+}
+
+// checkError fails t unless w is an errorResponse with status.
+func checkError(t *testing.T, what string, w interface {
+	Result() *http.Response
+}, status int) {
+	t.Helper()
+	resp := w.Result()
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Errorf("%s: status = %d, want %d", what, resp.StatusCode, status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s: Content-Type = %q, want application/json", what, ct)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("%s: error while decoding body: %s", what, err)
+	}
+	if len(body) != 2 {
+		t.Errorf("%s: body has fields %v, want only error and code", what, body)
+	}
+	if msg, ok := body["error"].(string); !ok || msg == "" {
+		t.Errorf("%s: error = %v, want a message", what, body["error"])
+	}
+	if code, ok := body["code"].(float64); !ok || int(code) != status {
+		t.Errorf("%s: code = %v, want %d", what, body["code"], status)
+	}
+}
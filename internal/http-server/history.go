@@ -0,0 +1,43 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// handleHistory streams the recorded frames as newline delimited JSON, oldest first.
+// The since query parameter limits them to frames after an RFC 3339 time, or within a duration of now, e.g. 10m.
+// Clients page through long histories by passing the last FrameStart they received.
+func (s *Server) handleHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		frames := s.coil.History.Last(-1)
+		if since := r.URL.Query().Get("since"); since != "" {
+			t, err := parseSince(since, s.coil.Clock.Now())
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid since: "+err.Error())
+				return
+			}
+			frames = s.coil.History.Since(t)
+		}
+		w.Header().Add("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		if pretty(r) {
+			// Frames span several lines each then, so this is no longer strictly NDJSON
+			enc.SetIndent("", "  ")
+		}
+		for i := range frames {
+			if err := enc.Encode(&frames[i]); err != nil {
+				s.errLogFor(r).Printf("error while writing history: %s", err.Error())
+				return
+			}
+		}
+	}
+}
+
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(since); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339Nano, since)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+)
+
+// decodeAll decodes every frame in a stream of JSON values, however they are laid out.
+func decodeAll(t *testing.T, body []byte) []coil.CoilFrame {
+	t.Helper()
+	var frames []coil.CoilFrame
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var frame coil.CoilFrame
+		if err := dec.Decode(&frame); err == io.EOF {
+			return frames
+		} else if err != nil {
+			t.Fatalf("error while decoding frames: %s", err)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func TestPrettyOutput(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	rig.waitReady(t)
+	rig.waitFrames(t, 3)
+
+	for _, path := range []string{"/", "/history"} {
+		compact := rig.do("GET", path, nil)
+		indented := rig.do("GET", path+"?pretty=true", nil)
+		if compact.Code != http.StatusOK || indented.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, pretty %d", path, compact.Code, indented.Code)
+		}
+		if strings.Contains(compact.Body.String(), "\n ") {
+			t.Errorf("GET %s is indented without pretty=true", path)
+		}
+		if !strings.Contains(indented.Body.String(), "\n  \"temp\"") || !strings.HasSuffix(indented.Body.String(), "}\n") {
+			t.Errorf("GET %s?pretty=true isn't indented and newline terminated: %q", path, indented.Body.String())
+		}
+		want, got := decodeAll(t, compact.Body.Bytes()), decodeAll(t, indented.Body.Bytes())
+		if len(want) == 0 || !reflect.DeepEqual(got, want) {
+			t.Errorf("GET %s: pretty frames %+v differ from compact %+v", path, got, want)
+		}
+	}
+	// Every compact history frame is a line of its own
+	lines := strings.Split(strings.TrimSuffix(rig.do("GET", "/history", nil).Body.String(), "\n"), "\n")
+	if len(lines) < 3 {
+		t.Errorf("history has %d lines, want a frame a line", len(lines))
+	}
+	for _, line := range lines {
+		var frame coil.CoilFrame
+		if err := json.Unmarshal([]byte(line), &frame); err != nil {
+			t.Errorf("history line %q: %s", line, err)
+		}
+	}
+}
+
+func TestStreamsStayCompact(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	rig.waitReady(t)
+	ts := rig.serve(t)
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL(ts, "/ws?pretty=true"), nil)
+	if err != nil {
+		t.Fatalf("error while dialing: %s", err)
+	}
+	defer ws.Close()
+	rig.waitFrames(t, 1)
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("error while reading frame: %s", err)
+	}
+	if bytes.Contains(data, []byte("\n ")) {
+		t.Errorf("websocket frame is indented with pretty=true: %q", data)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxLogLine is how much of a log line LogBuffer keeps; longer lines are cut short.
+const maxLogLine = 2048
+
+// urlPattern finds URLs in log lines, which are cut down to their host since webhook URLs often embed tokens.
+var urlPattern = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^/\s?#]+)[^\s]*`)
+
+// LogBuffer keeps the last lines written to it, for GET /logs. Use it as, or alongside, a log.Logger's output;
+// each line is redacted as it's written, so secrets never sit in memory to be served.
+type LogBuffer struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	full    bool
+	secrets []string
+}
+
+// NewLogBuffer returns a LogBuffer keeping the last size lines, with each of secrets redacted wherever it appears.
+func NewLogBuffer(size int, secrets ...string) *LogBuffer {
+	if size < 1 {
+		size = 1
+	}
+	b := &LogBuffer{lines: make([]string, size)}
+	for _, secret := range secrets {
+		if secret != "" {
+			b.secrets = append(b.secrets, secret)
+		}
+	}
+	return b
+}
+
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	text := strings.TrimSuffix(string(p), "\n")
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, line := range strings.Split(text, "\n") {
+		b.add(b.redact(line))
+	}
+	return len(p), nil
+}
+
+func (b *LogBuffer) add(line string) {
+	if len(line) > maxLogLine {
+		line = line[:maxLogLine] + "..."
+	}
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+func (b *LogBuffer) redact(line string) string {
+	for _, secret := range b.secrets {
+		line = strings.Replace(line, secret, "(redacted)", -1)
+	}
+	return urlPattern.ReplaceAllStringFunc(line, func(u string) string {
+		host := urlPattern.FindStringSubmatch(u)[1]
+		if host == u {
+			return u
+		}
+		return host + "/(redacted)"
+	})
+}
+
+// Last returns up to the last n lines, oldest first; n < 0 returns them all.
+func (b *LogBuffer) Last(n int) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lines := make([]string, 0, len(b.lines))
+	if b.full {
+		lines = append(lines, b.lines[b.next:]...)
+	}
+	lines = append(lines, b.lines[:b.next]...)
+	if n >= 0 && n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+type logsResponse struct {
+	Lines []string `json:"lines"`
+}
+
+// EnableLogs serves the lines in buf at GET /logs to requests bearing token, as an Authorization: Bearer header.
+// Like the pprof handlers it stays out of the route table and the OpenAPI document.
+func (s *Server) EnableLogs(buf *LogBuffer, token string) {
+	s.router.HandleFunc("/logs", s.handleLogs(buf, token)).Methods("GET")
+	s.infoLog.Printf("serving the last %d log lines at /logs\n", len(buf.lines))
+}
+
+// handleLogs returns the last lines query parameter's worth of lines, or all of them, as text or with format=json.
+func (s *Server) handleLogs(buf *LogBuffer, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			s.errLogFor(r).Printf("refused logs to %s: bad or missing token\n", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "a valid bearer token is required")
+			return
+		}
+		n := -1
+		if lines := r.URL.Query().Get("lines"); lines != "" {
+			var err error
+			if n, err = strconv.Atoi(lines); err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, "lines must be a non-negative integer")
+				return
+			}
+		}
+		lines := buf.Last(n)
+		if r.URL.Query().Get("format") == "json" {
+			payload, err := json.Marshal(logsResponse{Lines: lines})
+			if err != nil {
+				s.errLogFor(r).Printf("error while marshaling JSON for logs: %s", err.Error())
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			w.Header().Add("Content-Type", "application/json")
+			w.Write(payload)
+			return
+		}
+		w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+		for _, line := range lines {
+			w.Write([]byte(line + "\n"))
+		}
+	}
+}
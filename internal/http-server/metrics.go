@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+)
+
+func (s *Server) handleMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text/plain; version=0.0.4")
+		m := s.coil.Metrics()
+		var labels []string
+		if s.InstanceLabel != "" {
+			labels = append(labels, "instance", s.InstanceLabel)
+		}
+		if s.coil.Ready() {
+			frame := s.coil.CurrentFrame()
+			unitLabels := append(labels[:len(labels):len(labels)], "unit", string(coil.InternalUnit))
+			writeMetric(w, "pi_heater_temperature_degrees", "gauge",
+				"Temperature read in the most recent window.",
+				unitLabels, frame.Temp,
+			)
+			writeMetric(w, "pi_heater_target_temperature_degrees", "gauge",
+				"Target temperature in the most recent window.",
+				unitLabels, frame.Target,
+			)
+			writeMetric(w, "pi_heater_fire_time_milliseconds", "gauge",
+				"Time the coil fired for in the most recent window.",
+				labels, float64(frame.FireTime),
+			)
+		}
+		if m.SinceLastRead >= 0 {
+			writeMetric(w, "pi_heater_seconds_since_last_read", "gauge",
+				"Time since the last good thermocouple reading.",
+				labels, m.SinceLastRead.Seconds(),
+			)
+		}
+		if !math.IsNaN(m.MaxOvershoot) {
+			writeMetric(w, "pi_heater_max_overshoot_degrees", "gauge",
+				"How far the temperature peaked above the latest target once it settled.",
+				append(labels[:len(labels):len(labels)], "unit", string(coil.InternalUnit)), m.MaxOvershoot,
+			)
+		}
+		writeMetric(w, "pi_heater_windows_total", "counter",
+			"Control windows run.",
+			labels, float64(m.Windows),
+		)
+		writeMetric(w, "pi_heater_fire_seconds_total", "counter",
+			"Time spent firing the coil.",
+			labels, m.TotalFireTime.Seconds(),
+		)
+		writeMetric(w, "pi_heater_faults_total", "counter",
+			"Faults latched.",
+			labels, float64(m.Faults),
+		)
+		writeMetric(w, "pi_heater_duty_cycle_percent", "gauge",
+			"Percent of the most recent window spent firing.",
+			labels, m.DutyCycle,
+		)
+		writeMetric(w, "pi_heater_frame_sequence", "gauge",
+			"Sequence number of the most recent frame.",
+			labels, float64(m.LastSeq),
+		)
+		writeMetric(w, "pi_heater_frames_dropped_total", "counter",
+			"Frames replaced before the websocket hub could read them.",
+			labels, float64(m.DroppedFrames),
+		)
+		writeMetric(w, "pi_heater_frame_marshal_errors_total", "counter",
+			"Frames skipped by the websocket hub because they could not be marshaled.",
+			labels, float64(s.hub.MarshalErrors()),
+		)
+		writeMetric(w, "pi_heater_status_write_failures_total", "counter",
+			"Failed writes to the status device.",
+			labels, float64(m.WriteFailures),
+		)
+		writeMetric(w, "pi_heater_websocket_clients", "gauge",
+			"Connected websocket clients.",
+			labels, float64(len(s.hub.Clients())),
+		)
+		writeHistogram(w, "pi_heater_loop_jitter_seconds",
+			"How far each run loop window strayed from the configured window.",
+			labels, s.coil.LoopJitter(),
+		)
+	}
+}
+
+// writeMetric writes a single sample in the Prometheus text exposition format.
+// labels alternates label names and values.
+func writeMetric(w io.Writer, name, kind, help string, labels []string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s%s %g\n", name, help, name, kind, name, formatLabels(labels), value)
+}
+
+// writeHistogram writes a histogram in the Prometheus text exposition format.
+func writeHistogram(w io.Writer, name, help string, labels []string, h *coil.Histogram) {
+	bounds, cumulative, sum, total := h.Snapshot()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	bucket := labels[:len(labels):len(labels)]
+	for i, bound := range bounds {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(append(bucket, "le", fmt.Sprintf("%g", bound))), cumulative[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(append(bucket, "le", "+Inf")), total)
+	fmt.Fprintf(w, "%s_sum%s %g\n%s_count%s %d\n", name, formatLabels(labels), sum, name, formatLabels(labels), total)
+}
+
+// labelEscaper escapes label values as the exposition format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats alternating label names and values as a Prometheus label set, or nothing if there are none.
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
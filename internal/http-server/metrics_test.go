@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bufio"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+)
+
+var (
+	samplePattern = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(?:\{(.*)\})? (\S+)$`)
+	labelPattern  = regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_]*)="((?:[^"\\]|\\.)*)"(?:,|$)`)
+	labelUnescape = strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\n`, "\n")
+)
+
+// sample is one parsed line of the Prometheus text exposition format.
+type sample struct {
+	name, family string
+	labels       map[string]string
+	value        float64
+}
+
+// parseMetrics parses an exposition, failing t on anything a Prometheus scrape would reject.
+// Every sample must follow the # TYPE of its metric family.
+func parseMetrics(t *testing.T, exposition string) []sample {
+	t.Helper()
+	types := map[string]string{}
+	var samples []sample
+	scanner := bufio.NewScanner(strings.NewReader(exposition))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			if len(fields) != 4 {
+				t.Fatalf("malformed TYPE line %q", line)
+			}
+			types[fields[2]] = fields[3]
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := samplePattern.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("malformed sample %q", line)
+		}
+		s := sample{name: m[1], family: m[1], labels: map[string]string{}}
+		if types[s.name] == "" {
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				if family := strings.TrimSuffix(s.name, suffix); types[family] == "histogram" {
+					s.family = family
+				}
+			}
+		}
+		if types[s.family] == "" {
+			t.Fatalf("sample %q has no TYPE before it", line)
+		}
+		for rest := m[2]; rest != ""; {
+			label := labelPattern.FindStringSubmatch(rest)
+			if label == nil {
+				t.Fatalf("malformed labels in %q", line)
+			}
+			if _, ok := s.labels[label[1]]; ok {
+				t.Fatalf("label %s repeated in %q", label[1], line)
+			}
+			s.labels[label[1]] = labelUnescape.Replace(label[2])
+			rest = rest[len(label[0]):]
+		}
+		var err error
+		if s.value, err = strconv.ParseFloat(m[3], 64); err != nil {
+			t.Fatalf("malformed value in %q: %s", line, err)
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+func TestMetricsParseWithLabels(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	rig.waitReady(t)
+
+	for _, instance := range []string{"", "kiln-north", `shed "2" \ back`} {
+		rig.server.InstanceLabel = instance
+		samples := parseMetrics(t, rig.do("GET", "/metrics", nil).Body.String())
+		seen := map[string]bool{}
+		for _, s := range samples {
+			seen[s.family] = true
+			if !strings.HasPrefix(s.name, "pi_heater_") {
+				t.Errorf("%s isn't prefixed pi_heater_", s.name)
+			}
+			if got, ok := s.labels["instance"]; instance == "" && ok {
+				t.Errorf("%s has instance %q without PI_HEATER_INSTANCE_LABEL", s.name, got)
+			} else if instance != "" && got != instance {
+				t.Errorf("%s has instance %q, want %q", s.name, got, instance)
+			}
+			if strings.HasSuffix(s.family, "_degrees") {
+				if unit := s.labels["unit"]; unit != string(coil.InternalUnit) {
+					t.Errorf("%s has unit %q, want %q", s.name, unit, coil.InternalUnit)
+				}
+			} else if unit, ok := s.labels["unit"]; ok {
+				t.Errorf("%s isn't a temperature but has unit %q", s.name, unit)
+			}
+		}
+		for _, family := range []string{
+			"pi_heater_temperature_degrees",
+			"pi_heater_target_temperature_degrees",
+			"pi_heater_fire_time_milliseconds",
+			"pi_heater_windows_total",
+			"pi_heater_fire_seconds_total",
+			"pi_heater_frame_sequence",
+			"pi_heater_loop_jitter_seconds",
+		} {
+			if !seen[family] {
+				t.Errorf("instance %q: no %s metric", instance, family)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+func (s *Server) handleOpenAPI() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.openAPI == nil {
+			writeError(w, http.StatusInternalServerError, "OpenAPI document unavailable")
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(s.openAPI)
+	}
+}
+
+// openAPIDocument describes every route in the route table as an OpenAPI 3 document.
+func (s *Server) openAPIDocument() map[string]interface{} {
+	errorType := reflect.TypeOf(errorResponse{})
+	schemas := map[string]interface{}{errorType.Name(): schemaOf(errorType)}
+	errorResponse := map[string]interface{}{
+		"description": "Error",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]string{"$ref": "#/components/schemas/" + errorType.Name()},
+			},
+		},
+	}
+	paths := map[string]interface{}{}
+	for _, rt := range s.table {
+		response := map[string]interface{}{"description": "OK"}
+		if rt.schema != nil {
+			t := reflect.TypeOf(rt.schema)
+			schemas[t.Name()] = schemaOf(t)
+			response["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": map[string]string{"$ref": "#/components/schemas/" + t.Name()},
+				},
+			}
+		}
+		operation := map[string]interface{}{
+			"summary":   rt.summary,
+			"responses": map[string]interface{}{"200": response, "default": errorResponse},
+		}
+		if params := pathParams(rt.path); len(params) > 0 {
+			operation["parameters"] = params
+		}
+		item, ok := paths[rt.path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[rt.path] = item
+		}
+		item[strings.ToLower(rt.method)] = operation
+	}
+	return map[string]interface{}{
+		"openapi":    "3.0.3",
+		"info":       map[string]string{"title": "pi-heater", "version": "1"},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+// pathParams describes the {name} segments of a mux path template.
+func pathParams(path string) []interface{} {
+	var params []interface{}
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, map[string]interface{}{
+				"name":     strings.Trim(segment, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]string{"type": "string"},
+			})
+		}
+	}
+	return params
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf builds a JSON schema for t following encoding/json's rules for field names.
+func schemaOf(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		schema := schemaOf(t.Elem())
+		schema["nullable"] = true
+		return schema
+	case t.Kind() == reflect.Struct:
+		properties := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := f.Name
+			if tag := f.Tag.Get("json"); tag != "" {
+				if tag == "-" {
+					continue
+				}
+				if n := strings.Split(tag, ",")[0]; n != "" {
+					name = n
+				}
+			}
+			properties[name] = schemaOf(f.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPISpec is the part of the OpenAPI document the tests check.
+type openAPISpec struct {
+	OpenAPI    string                                       `json:"openapi"`
+	Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+	Components struct {
+		Schemas map[string]interface{} `json:"schemas"`
+	} `json:"components"`
+}
+
+func TestEveryRouteInOpenAPI(t *testing.T) {
+	// Both optional routes are registered: /sim/temp by the simulator, /voltage by the nominal voltage
+	rig := newTestRig(t, map[string]string{"PI_HEATER_NOMINAL_VOLTAGE": "240"})
+	w := rig.do("GET", "/openapi.json", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json = %d: %s", w.Code, w.Body)
+	}
+	var spec openAPISpec
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("error while decoding the OpenAPI document: %s", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi = %q, want a 3.x document", spec.OpenAPI)
+	}
+
+	routed := map[string]bool{}
+	err := rig.server.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			routed[method+" "+path] = true
+			if spec.Paths[path][strings.ToLower(method)] == nil {
+				t.Errorf("%s %s is routed but missing from the OpenAPI document", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error while walking the routes: %s", err)
+	}
+	for _, route := range []string{"POST /voltage", "POST /sim/temp", "GET /ws", "GET /"} {
+		if !routed[route] {
+			t.Errorf("%s isn't routed", route)
+		}
+	}
+
+	for path, item := range spec.Paths {
+		for method, operation := range item {
+			if !routed[strings.ToUpper(method)+" "+path] {
+				t.Errorf("%s %s is in the OpenAPI document but isn't routed", strings.ToUpper(method), path)
+			}
+			if summary, _ := operation["summary"].(string); summary == "" {
+				t.Errorf("%s %s has no summary", strings.ToUpper(method), path)
+			}
+		}
+	}
+	for _, schema := range []string{"CoilFrame", "errorResponse", "stateDocument"} {
+		if spec.Components.Schemas[schema] == nil {
+			t.Errorf("no %s schema", schema)
+		}
+	}
+}
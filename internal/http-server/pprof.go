@@ -0,0 +1,19 @@
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// EnablePprof serves the net/http/pprof handlers under /debug/pprof/.
+// They stay out of the route table, so they're never listed in the OpenAPI document.
+// CPU profiles and traces are cut short by the HTTP write timeout, so ask for fewer seconds than it allows.
+func (s *Server) EnablePprof() {
+	s.router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline).Methods("GET")
+	s.router.HandleFunc("/debug/pprof/profile", pprof.Profile).Methods("GET")
+	s.router.HandleFunc("/debug/pprof/symbol", pprof.Symbol).Methods("GET", "POST")
+	s.router.HandleFunc("/debug/pprof/trace", pprof.Trace).Methods("GET")
+	// Index also serves the named profiles, e.g. /debug/pprof/heap
+	s.router.PathPrefix("/debug/pprof/").Handler(http.HandlerFunc(pprof.Index)).Methods("GET")
+	s.infoLog.Printf("serving pprof handlers under /debug/pprof/\n")
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// presetStore keeps named presets, saving them as a JSON object keyed by name when it has a file.
+type presetStore struct {
+	mu      sync.Mutex
+	file    string
+	presets map[string]coil.Preset
+}
+
+// presetsResponse is the body of GET /presets.
+type presetsResponse map[string]coil.Preset
+
+func newPresetStore() *presetStore {
+	return &presetStore{presets: map[string]coil.Preset{}}
+}
+
+// LoadPresets loads the named presets in file, which is created on the first save if it doesn't exist.
+// Without a file, presets are only kept until the server exits.
+func (s *Server) LoadPresets(file string) error {
+	s.presets.mu.Lock()
+	defer s.presets.mu.Unlock()
+	s.presets.file = file
+	s.presets.presets = map[string]coil.Preset{}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.New("error while reading presets: " + err.Error())
+	}
+	if err = json.Unmarshal(data, &s.presets.presets); err != nil {
+		return errors.New("error while parsing presets in " + file + ": " + err.Error())
+	}
+	s.infoLog.Printf("loaded %d presets from %s\n", len(s.presets.presets), file)
+	return nil
+}
+
+// save writes the presets to a temporary file and renames it over the old one so a crash can't leave it half written.
+// Callers must hold mu.
+func (p *presetStore) save() error {
+	if p.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(p.presets, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p.file), ".presets-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p.file)
+}
+
+func (s *Server) handleListPresets() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.presets.mu.Lock()
+		payload, err := json.Marshal(presetsResponse(s.presets.presets))
+		s.presets.mu.Unlock()
+		if err != nil {
+			s.errLogFor(r).Printf("error while marshaling JSON for presets: %s", err.Error())
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(payload)
+	}
+}
+
+func (s *Server) handleSavePreset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		var preset coil.Preset
+		if !s.decodeBody(w, r, &preset) {
+			return
+		}
+		if err := s.coil.ValidatePreset(preset); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.presets.mu.Lock()
+		defer s.presets.mu.Unlock()
+		old, existed := s.presets.presets[name]
+		s.presets.presets[name] = preset
+		if err := s.presets.save(); err != nil {
+			if existed {
+				s.presets.presets[name] = old
+			} else {
+				delete(s.presets.presets, name)
+			}
+			s.errLogFor(r).Printf("error while saving presets: %s", err.Error())
+			writeError(w, http.StatusInternalServerError, "error while saving presets: "+err.Error())
+			return
+		}
+		s.infoLogFor(r).Printf("saved preset %q at request of %s\n", name, r.RemoteAddr)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Server) handleApplyPreset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		s.presets.mu.Lock()
+		preset, ok := s.presets.presets[name]
+		s.presets.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown preset: "+name)
+			return
+		}
+		s.applyPreset(w, r, preset)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// maxRequestIDLength bounds the X-Request-ID taken from a client; longer or unprintable ones are replaced.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// withRequestID tags r with the client's X-Request-ID, or a new one, and echoes it in the response.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get("X-Request-ID")
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set("X-Request-ID", id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID is the ID withRequestID gave r.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestSource names r's client and request ID, for TargetCommand.Source and the like, so the coil's log lines
+// about a request carry its ID too.
+func requestSource(r *http.Request) string {
+	return "http " + r.RemoteAddr + " (request " + requestID(r) + ")"
+}
+
+// requestLog writes to a logger with the ID of the request being handled at the start of each line.
+type requestLog struct {
+	log *log.Logger
+	id  string
+}
+
+func (l requestLog) Printf(format string, v ...interface{}) {
+	l.log.Output(2, "request "+l.id+": "+fmt.Sprintf(format, v...))
+}
+
+func (s *Server) errLogFor(r *http.Request) requestLog {
+	return requestLog{log: s.errLog, id: requestID(r)}
+}
+
+func (s *Server) infoLogFor(r *http.Request) requestLog {
+	return requestLog{log: s.infoLog, id: requestID(r)}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDEchoed(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	rig.waitReady(t)
+
+	for _, req := range []struct{ method, target string }{
+		{"GET", "/"},
+		{"GET", "/config"},
+		{"POST", "/?target=hot"},
+		{"GET", "/nonesuch"},
+	} {
+		w := rig.do(req.method, req.target, nil, "X-Request-ID", "kiln-check-42")
+		if got := w.Header().Get("X-Request-ID"); got != "kiln-check-42" {
+			t.Errorf("%s %s: X-Request-ID = %q, want the client's echoed", req.method, req.target, got)
+		}
+	}
+
+	generated := regexp.MustCompile(`^[0-9a-f]{16}$`)
+	seen := make(map[string]bool)
+	for _, id := range []string{"", "has space", "tab\there", strings.Repeat("x", maxRequestIDLength+1), "café"} {
+		var header []string
+		if id != "" {
+			header = []string{"X-Request-ID", id}
+		}
+		got := rig.do("GET", "/config", nil, header...).Header().Get("X-Request-ID")
+		if !generated.MatchString(got) {
+			t.Errorf("X-Request-ID %q was replaced with %q, want a generated one", id, got)
+		}
+		if seen[got] {
+			t.Errorf("generated X-Request-ID %q twice", got)
+		}
+		seen[got] = true
+	}
+	if w := rig.do("GET", "/config", nil, "X-Request-ID", strings.Repeat("x", maxRequestIDLength)); w.Header().Get("X-Request-ID") != strings.Repeat("x", maxRequestIDLength) {
+		t.Error("an X-Request-ID of the longest allowed length was replaced")
+	}
+}
+
+func TestRequestIDInLogs(t *testing.T) {
+	rig := newTestRig(t, nil)
+	var errBuf, infoBuf bytes.Buffer
+	s := NewServer(rig.coil, rig.hub, log.New(&errBuf, "", 0), log.New(&infoBuf, "", 0))
+	rig.start(t)
+	rig.waitReady(t)
+
+	for _, target := range []string{"/?target=hot", "/window?ms=2000"} {
+		r := httptest.NewRequest("POST", target, nil)
+		r.Header.Set("X-Request-ID", "trace-"+target)
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if !strings.Contains(errBuf.String(), "request trace-/?target=hot: error while parsing target") {
+		t.Errorf("error log doesn't carry the request ID:\n%s", errBuf.String())
+	}
+	if !strings.Contains(infoBuf.String(), "request trace-/window?ms=2000: changed the control window") {
+		t.Errorf("info log doesn't carry the request ID:\n%s", infoBuf.String())
+	}
+	if src := requestSource(httptest.NewRequest("GET", "/", nil)); !strings.HasPrefix(src, "http 192.0.2.1:1234 (request ") {
+		t.Errorf("requestSource = %q", src)
+	}
+}
@@ -1,48 +1,173 @@
 package server
 
 import (
-	"github.com/raphaelreyna/pi-heater/pkg/coil"
-	"github.com/raphaelreyna/pi-heater/internal/websocket-hub"
 	"encoding/json"
 	"github.com/gorilla/mux"
+	"github.com/raphaelreyna/pi-heater/internal/websocket-hub"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 type Server struct {
+	// InstanceLabel, if set, is attached to every metric as the instance label.
+	InstanceLabel string
+
 	router  *mux.Router
+	table   []route
+	openAPI []byte
+	presets *presetStore
 	coil    *coil.Coil
 	hub     *hub.Hub
 	errLog  *log.Logger
 	infoLog *log.Logger
 }
 
+// NewServer returns the HTTP API for coil and hub; nil loggers discard their output.
 func NewServer(coil *coil.Coil, hub *hub.Hub, errLog, infoLog *log.Logger) *Server {
+	if errLog == nil {
+		errLog = log.New(ioutil.Discard, "", 0)
+	}
+	if infoLog == nil {
+		infoLog = log.New(ioutil.Discard, "", 0)
+	}
 	s := &Server{
 		coil:    coil,
 		hub:     hub,
 		errLog:  errLog,
 		infoLog: infoLog,
+		presets: newPresetStore(),
+	}
+	hub.UpgradeError = func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		writeError(w, status, reason.Error())
 	}
 	s.routes()
+	var err error
+	s.openAPI, err = json.Marshal(s.openAPIDocument())
+	if err != nil {
+		errLog.Printf("error while marshaling OpenAPI document: %s", err.Error())
+	}
 	return s
 }
 
+// route is an HTTP endpoint; the same table feeds the router and the OpenAPI document.
+type route struct {
+	method  string
+	path    string
+	summary string
+	schema  interface{} // example of the JSON response body, if any
+	handler http.HandlerFunc
+}
+
 func (s *Server) routes() {
+	s.table = []route{
+		{"GET", "/", "Current coil frame, indented with pretty=true", coil.CoilFrame{}, s.whenReady(s.handleGet())},
+		{"POST", "/", "Set the target temperature, or nudge it with relative, from query parameters or a JSON body", targetResponse{}, s.handlePost()},
+		{"GET", "/history", "Recorded frames as newline delimited JSON, optionally since an RFC 3339 time or a duration ago, or indented with pretty=true", coil.CoilFrame{}, s.handleHistory()},
+		{"GET", "/ws", "Websocket stream of coil frames", coil.CoilFrame{}, s.whenReady(s.hub.ServeHTTP)},
+		{"GET", "/events", "Websocket or server-sent event stream of state changes, starting with the current state", coil.CoilEvent{}, s.handleEvents()},
+		{"GET", "/health", "Whether the coil has produced its first valid frame and is still reading; 503 until it has, or once readings go stale", healthResponse{}, s.handleHealth()},
+		{"GET", "/metrics", "Prometheus metrics", nil, s.handleMetrics()},
+		{"GET", "/clients", "Connected websocket clients", clientsResponse{}, s.handleClients()},
+		{"GET", "/config", "The coil's configuration, including its control mode", coil.Config{}, s.handleConfig()},
+		{"POST", "/bake", "Heat to target, hold for hold (a duration, or minutes) once within tolerance, then turn off", bakeResponse{}, s.handleBake()},
+		{"DELETE", "/bake", "Cancel the bake in progress and turn off", nil, s.handleCancelBake()},
+		{"POST", "/autotune", "Start a relay autotune around target; frames report its progress and suggested gains", nil, s.handleAutotune()},
+		{"DELETE", "/autotune", "Abort the autotune in progress", nil, s.handleStopAutotune()},
+		{"POST", "/preset", "Apply a target and PID gains together from a JSON body, returning the resulting config", coil.Config{}, s.handlePreset()},
+		{"GET", "/presets", "Saved presets by name", presetsResponse{}, s.handleListPresets()},
+		{"POST", "/presets/{name}", "Save a preset under name from a JSON body", nil, s.handleSavePreset()},
+		{"POST", "/apply/{name}", "Apply the preset saved under name, returning the resulting config", coil.Config{}, s.handleApplyPreset()},
+		{"GET", "/state", "The coil's runtime settings and the saved presets, without live measurements", stateDocument{}, s.handleExportState()},
+		{"POST", "/state", "Validate then restore a document from GET /state, returning the restored state", stateDocument{}, s.handleRestoreState()},
+		{"GET", "/window", "The control window and the longest pulse it allows, in milliseconds", windowResponse{}, s.handleGetWindow()},
+		{"POST", "/window", "Change the control window to ms milliseconds while running", windowResponse{}, s.handleSetWindow()},
+		{"POST", "/stop", "Force the element off, keeping the target, until /start or a new target; the same as target=off", nil, s.handleStop()},
+		{"POST", "/start", "Turn the element back on after /stop, heating to the target it kept", nil, s.handleStart()},
+		{"POST", "/reset-pid", "Reset the PID controller's integral without changing the target", nil, s.handleResetPID()},
+		{"POST", "/clear", "Clear a latched fault so the coil can fire again", nil, s.handleClear()},
+		{"POST", "/estop", "Turn the coil off now and latch an emergency stop fault until cleared", nil, s.handleEStop()},
+		{"GET", "/openapi.json", "This document", nil, s.handleOpenAPI()},
+	}
+	if s.coil.Config().NominalVoltage > 0 {
+		s.table = append(s.table, route{"POST", "/voltage", "Report the measured supply voltage, in volts, with a value query parameter", nil, s.handleVoltage()})
+	}
+	if s.coil.Simulator() != nil {
+		s.table = append(s.table, route{"POST", "/sim/temp", "Override the simulated temperature with a value query parameter", nil, s.handleSimTemp()})
+	}
 	s.router = mux.NewRouter()
-	s.router.HandleFunc("/", s.handleGet()).Methods("GET")
-	s.router.HandleFunc("/", s.handlePost()).Methods("POST")
-	s.router.HandleFunc("/ws", s.hub.ServeHTTP)
+	for _, rt := range s.table {
+		s.router.HandleFunc(rt.path, rt.handler).Methods(rt.method)
+	}
+	s.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "no such endpoint: "+r.URL.Path)
+	})
+	s.router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+	})
+}
+
+// whenReady responds 503 instead of calling handler until the coil is ready.
+func (s *Server) whenReady(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.coil.Ready() {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, "coil is not ready yet")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+type healthResponse struct {
+	Ready bool   `json:"ready"`
+	Fault string `json:"fault,omitempty"`
+	// SinceLastRead is omitted until there has been a good reading; Stale is set once it passes the coil's StaleAfter.
+	SinceLastRead *float64 `json:"seconds_since_last_read,omitempty"`
+	Stale         bool     `json:"stale,omitempty"`
+}
+
+func (s *Server) handleHealth() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		health := healthResponse{Ready: s.coil.Ready(), Fault: s.coil.CurrentFrame().Fault}
+		if since, ok := s.coil.SinceLastRead(); ok {
+			seconds := since.Seconds()
+			health.SinceLastRead = &seconds
+			health.Stale = s.coil.Stale()
+		}
+		payload, err := json.Marshal(&health)
+		if err != nil {
+			s.errLogFor(r).Printf("error while marshaling JSON for health: %s", err.Error())
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		if !health.Ready || health.Stale {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(payload)
+	}
 }
 
 func (s *Server) handleGet() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		frame := s.coil.CurrentFrame
-		payload, err := json.Marshal(&frame)
+		frame := s.coil.CurrentFrame()
+		// Each window produces exactly one frame, so its start time identifies it. The tag is weak since the same frame
+		// goes out pretty or compact, gzipped or not, and none of those are byte for byte the same.
+		etag := `W/"` + strconv.FormatInt(frame.FrameStart.UnixNano(), 36) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Vary", "Accept-Encoding")
+		if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		payload, err := marshalFor(r, &frame)
 		if err != nil {
-			s.errLog.Printf("error while marshaling JSON for frame: %s", err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			s.errLogFor(r).Printf("error while marshaling JSON for frame: %s", err.Error())
+			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 		w.Header().Add("Content-Type", "application/json")
@@ -50,20 +175,439 @@ func (s *Server) handleGet() http.HandlerFunc {
 	}
 }
 
+// maxBodyBytes caps request bodies; every body the server accepts is a small JSON object.
+const maxBodyBytes = 4096
+
+// targetRequest is the JSON body accepted by handlePost.
+// Relative moves the current target by that many degrees instead of replacing it.
+// Tolerance optionally resets the band around the target that counts as at temperature.
+type targetRequest struct {
+	Target    *targetValue `json:"target"`
+	Relative  *float64     `json:"relative"`
+	Tolerance *float64     `json:"tolerance"`
+	Unit      string       `json:"unit"`
+}
+
+// targetValue is a target temperature, or "off" to disable the element, which is not the same as a target of 0.
+type targetValue struct {
+	value float64
+	off   bool
+}
+
+func (t *targetValue) UnmarshalJSON(data []byte) error {
+	if string(data) == `"off"` {
+		t.off = true
+		return nil
+	}
+	return json.Unmarshal(data, &t.value)
+}
+
+// targetResponse echoes the accepted target in the coil's internal unit.
+type targetResponse struct {
+	Target   float64   `json:"target"`
+	Unit     coil.Unit `json:"unit"`
+	Disabled bool      `json:"disabled,omitempty"`
+}
+
 func (s *Server) handlePost() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		targetString := r.URL.Query().Get("target")
-		target, err := strconv.ParseFloat(targetString, 64)
+		var req targetRequest
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			if !s.decodeBody(w, r, &req) {
+				return
+			}
+		} else if relativeString := r.URL.Query().Get("relative"); relativeString != "" {
+			// An unescaped + in the query decodes to a space
+			relative, err := strconv.ParseFloat(strings.TrimSpace(relativeString), 64)
+			if err != nil {
+				s.errLogFor(r).Printf("error while parsing relative target from URL: %s", err.Error())
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			req.Relative = &relative
+			req.Unit = r.URL.Query().Get("unit")
+		} else {
+			targetString := r.URL.Query().Get("target")
+			var target targetValue
+			if targetString == "off" {
+				target.off = true
+			} else {
+				value, err := strconv.ParseFloat(targetString, 64)
+				if err != nil {
+					s.errLogFor(r).Printf("error while parsing target from URL: %s", err.Error())
+					writeError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				target.value = value
+			}
+			req.Target = &target
+			req.Unit = r.URL.Query().Get("unit")
+		}
+		if (req.Target == nil) == (req.Relative == nil) {
+			writeError(w, http.StatusBadRequest, "exactly one of target and relative is required")
+			return
+		}
+		if toleranceString := r.URL.Query().Get("tolerance"); toleranceString != "" && req.Tolerance == nil {
+			tolerance, err := strconv.ParseFloat(toleranceString, 64)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			req.Tolerance = &tolerance
+		}
+		unit, err := coil.ParseUnit(req.Unit)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Tolerance != nil {
+			// A preset applies an absolute target and its tolerance in the same step; anything else sets the
+			// tolerance first, and only goes on to the target if the tolerance was accepted
+			preset := coil.Preset{Tolerance: req.Tolerance, Unit: unit}
+			absolute := req.Target != nil && !req.Target.off
+			if absolute {
+				preset.Target = &req.Target.value
+			}
+			if !s.sendPreset(w, preset) {
+				return
+			}
+			if absolute {
+				s.infoLogFor(r).Printf("%s set the target to %.2f%s with a tolerance of %.2f\n", requestSource(r), req.Target.value, unit, *req.Tolerance)
+				s.writeTarget(w, r, s.coil.Target(), false)
+				return
+			}
+		}
+		cmd := coil.TargetCommand{Source: requestSource(r), Reply: make(chan float64, 1)}
+		if req.Relative != nil {
+			// A difference converts by scale alone, without the offset
+			cmd.Target = unit.ToInternal(*req.Relative) - unit.ToInternal(0)
+			cmd.Relative = true
+		} else if req.Target.off {
+			cmd.Off = true
+		} else {
+			cmd.Target = unit.ToInternal(req.Target.value)
+		}
+		if !s.send(w, s.coil.SetTarget, cmd) {
+			return
+		}
+		// The target the coil clamped this to
+		s.writeTarget(w, r, <-cmd.Reply, cmd.Off)
+	}
+}
+
+// sendPreset validates and applies preset, responding with the error and returning false if either fails.
+func (s *Server) sendPreset(w http.ResponseWriter, preset coil.Preset) bool {
+	if err := s.coil.ValidatePreset(preset); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	reply := make(chan coil.PresetResult, 1)
+	if !s.send(w, s.coil.ApplyPreset, coil.PresetRequest{Preset: preset, Reply: reply}) {
+		return false
+	}
+	if result := <-reply; result.Err != nil {
+		writeError(w, http.StatusBadRequest, result.Err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Server) writeTarget(w http.ResponseWriter, r *http.Request, target float64, disabled bool) {
+	payload, err := json.Marshal(&targetResponse{Target: target, Unit: coil.InternalUnit, Disabled: disabled})
+	if err != nil {
+		s.errLogFor(r).Printf("error while marshaling JSON for target: %s", err.Error())
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+// decodeBody decodes the JSON request body into v, capped at maxBodyBytes.
+// It writes the error response and returns false if the body can't be read or decoded.
+func (s *Server) decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		if len(body) == maxBodyBytes {
+			writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return false
+		}
+		s.errLogFor(r).Printf("error while reading request body: %s", err.Error())
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		s.errLogFor(r).Printf("error while decoding request body: %s", err.Error())
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Server) handlePreset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var preset coil.Preset
+		if !s.decodeBody(w, r, &preset) {
+			return
+		}
+		s.applyPreset(w, r, preset)
+	}
+}
+
+// applyPreset has the run loop apply preset and responds with the resulting config.
+func (s *Server) applyPreset(w http.ResponseWriter, r *http.Request, preset coil.Preset) {
+	if err := s.coil.ValidatePreset(preset); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	reply := make(chan coil.PresetResult, 1)
+	if !s.send(w, s.coil.ApplyPreset, coil.PresetRequest{Preset: preset, Reply: reply}) {
+		return
+	}
+	result := <-reply
+	if result.Err != nil {
+		writeError(w, http.StatusBadRequest, result.Err.Error())
+		return
+	}
+	s.infoLogFor(r).Printf("applied preset at request of %s\n", r.RemoteAddr)
+	payload, err := json.Marshal(&result.Config)
+	if err != nil {
+		s.errLogFor(r).Printf("error while marshaling JSON for config: %s", err.Error())
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+func (s *Server) handleResetPID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.send(w, s.coil.ResetPID, struct{}{}) {
+			return
+		}
+		s.infoLogFor(r).Printf("reset P.I.D. controller at request of %s\n", r.RemoteAddr)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Server) handleStop() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.send(w, s.coil.SetTarget, coil.TargetCommand{Off: true, Source: requestSource(r)}) {
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Server) handleStart() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.send(w, s.coil.Enable, requestSource(r)) {
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Server) handleClear() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.send(w, s.coil.Clear, struct{}{}) {
+			return
+		}
+		s.infoLogFor(r).Printf("cleared fault at request of %s\n", r.RemoteAddr)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Server) handleEStop() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.send(w, s.coil.EStop, struct{}{}) {
+			return
+		}
+		s.errLogFor(r).Printf("EMERGENCY STOP at request of %s\n", r.RemoteAddr)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+type windowResponse struct {
+	Window  int64 `json:"window"`   // milliseconds
+	MaxFire int64 `json:"max_fire"` // milliseconds
+}
+
+func (s *Server) handleGetWindow() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := s.coil.Config()
+		writeWindow(w, config, s.errLogFor(r))
+	}
+}
+
+func (s *Server) handleSetWindow() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ms, err := strconv.ParseInt(r.URL.Query().Get("ms"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "error while parsing ms: "+err.Error())
+			return
+		}
+		if err = s.coil.ValidateWindow(ms); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		reply := make(chan coil.WindowResult, 1)
+		if !s.send(w, s.coil.SetWindow, coil.WindowRequest{Window: ms, Reply: reply}) {
+			return
+		}
+		result := <-reply
+		if result.Err != nil {
+			writeError(w, http.StatusBadRequest, result.Err.Error())
+			return
+		}
+		s.infoLogFor(r).Printf("changed the control window to %dms at request of %s\n", ms, r.RemoteAddr)
+		writeWindow(w, result.Config, s.errLogFor(r))
+	}
+}
+
+func writeWindow(w http.ResponseWriter, config coil.Config, errLog requestLog) {
+	payload, err := json.Marshal(windowResponse{Window: config.Window, MaxFire: config.MaxFire})
+	if err != nil {
+		errLog.Printf("error while marshaling JSON for window: %s", err.Error())
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+// handleVoltage is only routed when PI_HEATER_NOMINAL_VOLTAGE is set.
+func (s *Server) handleVoltage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value, err := strconv.ParseFloat(r.URL.Query().Get("value"), 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "error while parsing value: "+err.Error())
+			return
+		}
+		if err = s.coil.ValidateVoltage(value); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !s.send(w, s.coil.SetVoltage, value) {
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleSimTemp is only routed in simulate mode.
+func (s *Server) handleSimTemp() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value, err := strconv.ParseFloat(r.URL.Query().Get("value"), 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		unit, err := coil.ParseUnit(r.URL.Query().Get("unit"))
 		if err != nil {
-			s.errLog.Printf("error while parsing target from URL: %s", err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		s.coil.SetTarget <- target
+		s.coil.Simulator().SetTemp(unit.ToInternal(value))
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
+type clientsResponse struct {
+	Count   int              `json:"count"`
+	Clients []hub.ClientInfo `json:"clients"`
+}
+
+func (s *Server) handleClients() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clients := s.hub.Clients()
+		if clients == nil {
+			clients = []hub.ClientInfo{}
+		}
+		payload, err := json.Marshal(&clientsResponse{Count: len(clients), Clients: clients})
+		if err != nil {
+			s.errLogFor(r).Printf("error while marshaling JSON for clients: %s", err.Error())
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(payload)
+	}
+}
+
+func (s *Server) handleConfig() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := s.coil.Config()
+		payload, err := json.Marshal(&config)
+		if err != nil {
+			s.errLogFor(r).Printf("error while marshaling JSON for config: %s", err.Error())
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(payload)
+	}
+}
+
+// errorResponse is the body of every error response.
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+// pretty reports whether r asked for indented JSON with pretty=true, for reading by hand; streams stay compact.
+func pretty(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("pretty"))
+	return v
+}
+
+// marshalFor marshals v compactly, or indented and newline terminated if r asked for it.
+func marshalFor(r *http.Request, v interface{}) ([]byte, error) {
+	if !pretty(r) {
+		return json.Marshal(v)
+	}
+	payload, err := json.MarshalIndent(v, "", "  ")
+	// End with a newline so a shell prompt doesn't follow on from the closing brace
+	return append(payload, '\n'), err
+}
+
+// send hands v to the coil on ch, as coil.Send does, responding 503 and returning false if the coil has stopped.
+func (s *Server) send(w http.ResponseWriter, ch, v interface{}) bool {
+	if err := s.coil.Send(ch, v); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return false
+	}
+	return true
+}
+
+// writeError responds with status and msg in an errorResponse.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	payload, _ := json.Marshal(&errorResponse{Error: msg, Code: status})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(payload)
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag, comparing weakly as If-None-Match does.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.router.ServeHTTP(w, r)
+	if compressible(r) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		w = gw
+	}
+	s.router.ServeHTTP(w, withRequestID(w, r))
 }
@@ -0,0 +1,481 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+)
+
+func TestCommandsAfterCoilStops(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	rig.waitReady(t)
+	state := rig.do("GET", "/state", nil).Body.String()
+	rig.stopCoil(t)
+
+	for _, req := range []struct {
+		method, target, body string
+	}{
+		{"POST", "/?target=300", ""},
+		{"POST", "/?target=300&tolerance=3", ""},
+		{"POST", "/?relative=10", ""},
+		{"POST", "/stop", ""},
+		{"POST", "/start", ""},
+		{"POST", "/clear", ""},
+		{"POST", "/estop", ""},
+		{"POST", "/reset-pid", ""},
+		{"POST", "/window?ms=2000", ""},
+		{"POST", "/bake?target=300&hold=10", ""},
+		{"DELETE", "/bake", ""},
+		{"POST", "/autotune?target=300", ""},
+		{"DELETE", "/autotune", ""},
+		{"POST", "/preset", `{"p": 2}`},
+		{"GET", "/state", ""},
+		{"POST", "/state", state},
+	} {
+		what := req.method + " " + req.target
+		within(t, what, func() {
+			var header []string
+			if req.body != "" {
+				header = []string{"Content-Type", "application/json"}
+			}
+			w := rig.do(req.method, req.target, strings.NewReader(req.body), header...)
+			checkError(t, what, w, http.StatusServiceUnavailable)
+		})
+	}
+}
+
+func TestWebsocketAfterCoilStops(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	rig.waitReady(t)
+	ts := rig.serve(t)
+
+	follower, _, err := websocket.DefaultDialer.Dial(wsURL(ts, "/ws"), nil)
+	if err != nil {
+		t.Fatalf("error while dialing: %s", err)
+	}
+	defer follower.Close()
+	rig.stopCoil(t)
+	readTerminated(t, follower)
+
+	// Followers that turn up after the coil has stopped are told straight away
+	late, _, err := websocket.DefaultDialer.Dial(wsURL(ts, "/ws"), nil)
+	if err != nil {
+		t.Fatalf("error while dialing: %s", err)
+	}
+	defer late.Close()
+	readTerminated(t, late)
+}
+
+// readTerminated reads frames from ws until the terminated one, then expects the connection to close.
+func readTerminated(t *testing.T, ws *websocket.Conn) {
+	t.Helper()
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("connection ended before a terminated frame: %s", err)
+		}
+		var frame coil.CoilFrame
+		if err := json.Unmarshal([]byte(strings.SplitN(string(data), "\n", 2)[0]), &frame); err != nil {
+			t.Fatalf("error while decoding frame: %s", err)
+		}
+		if frame.Terminated {
+			break
+		}
+	}
+	if _, _, err := ws.ReadMessage(); err == nil {
+		t.Error("connection stayed open after the terminated frame")
+	}
+}
+
+// broadcast keeps the rig's coil publishing frames to a websocket follower until t ends.
+func (rig *testRig) broadcast(t testing.TB) {
+	t.Helper()
+	ts := rig.serve(t)
+	follower, _, err := websocket.DefaultDialer.Dial(wsURL(ts, "/ws"), nil)
+	if err != nil {
+		t.Fatalf("error while dialing: %s", err)
+	}
+	go func() {
+		for {
+			if _, _, err := follower.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			rig.clock.Advance(rig.window())
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+		follower.Close()
+	})
+}
+
+func TestGetDuringBroadcasts(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	rig.waitReady(t)
+	rig.broadcast(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last uint64
+			for j := 0; j < 100; j++ {
+				w := rig.do("GET", "/", nil)
+				if w.Code != http.StatusOK {
+					t.Errorf("GET / = %d", w.Code)
+					return
+				}
+				var frame coil.CoilFrame
+				if err := json.Unmarshal(w.Body.Bytes(), &frame); err != nil {
+					t.Errorf("error while decoding frame: %s", err)
+					return
+				}
+				if frame.Seq < last {
+					t.Errorf("GET / went from seq %d back to %d", last, frame.Seq)
+				}
+				last = frame.Seq
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkHandleGet(b *testing.B) {
+	rig := newTestRig(b, nil)
+	rig.start(b)
+	rig.waitReady(b)
+	rig.broadcast(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if w := rig.do("GET", "/", nil); w.Code != http.StatusOK {
+				b.Fatalf("GET / = %d", w.Code)
+			}
+		}
+	})
+}
+
+func TestNotReadyBeforeFirstFrame(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	ts := rig.serve(t)
+
+	// The clock hasn't moved, so the loop hasn't ticked and nothing has been published
+	w := rig.do("GET", "/", nil)
+	checkError(t, "GET / before the first frame", w, http.StatusServiceUnavailable)
+	if retry := w.Header().Get("Retry-After"); retry == "" {
+		t.Error("503 before the first frame has no Retry-After")
+	}
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL(ts, "/ws"), nil)
+	if err == nil {
+		t.Fatal("websocket connected before the first frame")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("websocket before the first frame: %v, want a 503", err)
+	}
+	resp.Body.Close()
+	if w := rig.do("GET", "/health", nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /health before the first frame = %d, want 503", w.Code)
+	}
+
+	rig.waitReady(t)
+	if w := rig.do("GET", "/", nil); w.Code != http.StatusOK {
+		t.Errorf("GET / after the first frame = %d, want 200", w.Code)
+	}
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL(ts, "/ws"), nil)
+	if err != nil {
+		t.Fatalf("websocket after the first frame: %s", err)
+	}
+	ws.Close()
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	rig.waitReady(t)
+
+	for _, req := range []struct {
+		method, target string
+		status         int
+	}{
+		{"POST", "/?target=hot", http.StatusBadRequest},
+		{"POST", "/", http.StatusBadRequest},
+		{"POST", "/?target=300&unit=kelvin", http.StatusBadRequest},
+		{"POST", "/window?ms=1", http.StatusBadRequest},
+		{"POST", "/apply/nonesuch", http.StatusNotFound},
+		{"GET", "/presets/nonesuch", http.StatusMethodNotAllowed},
+		{"GET", "/nonesuch", http.StatusNotFound},
+		{"PUT", "/", http.StatusMethodNotAllowed},
+	} {
+		what := req.method + " " + req.target
+		checkError(t, what, rig.do(req.method, req.target, nil), req.status)
+	}
+}
+
+func TestGetETagIsWeakAcrossRepresentations(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	rig.waitReady(t)
+
+	var etag string
+	for _, req := range []struct {
+		target string
+		header []string
+	}{
+		{"/", nil},
+		{"/?pretty=true", nil},
+		{"/", []string{"Accept-Encoding", "gzip"}},
+		{"/?pretty=true", []string{"Accept-Encoding", "gzip"}},
+	} {
+		what := "GET " + req.target + " " + strings.Join(req.header, ": ")
+		w := rig.do("GET", req.target, nil, req.header...)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s = %d", what, w.Code)
+		}
+		tag := w.Header().Get("ETag")
+		if !strings.HasPrefix(tag, `W/"`) {
+			t.Errorf("%s: ETag %q isn't weak", what, tag)
+		}
+		if etag == "" {
+			etag = tag
+		} else if tag != etag {
+			t.Errorf("%s: ETag %q differs from %q for the same frame", what, tag, etag)
+		}
+		if vary := w.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Accept-Encoding" {
+			t.Errorf("%s: Vary = %q, want Accept-Encoding once", what, vary)
+		}
+		for _, match := range []string{tag, strings.TrimPrefix(tag, "W/")} {
+			if w := rig.do("GET", req.target, nil, append([]string{"If-None-Match", match}, req.header...)...); w.Code != http.StatusNotModified {
+				t.Errorf("%s with If-None-Match %s = %d, want 304", what, match, w.Code)
+			}
+		}
+	}
+}
+
+func TestTargetWithTolerance(t *testing.T) {
+	rig := newTestRig(t, map[string]string{"PI_HEATER_MAX_TARGET": "500"})
+	rig.start(t)
+	rig.waitReady(t)
+
+	w := rig.do("POST", "/?target=300&tolerance=3", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /?target=300&tolerance=3 = %d: %s", w.Code, w.Body)
+	}
+	var resp targetResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Target != 300 {
+		t.Errorf("target = %v, want 300", resp.Target)
+	}
+	if config := rig.coil.Config(); config.Target != 300 || config.Tolerance != 3 {
+		t.Errorf("config target %v tolerance %v, want 300 and 3", config.Target, config.Tolerance)
+	}
+
+	// Nothing is applied from a rejected request, target or tolerance
+	for _, target := range []string{
+		"/?target=350&tolerance=-1",
+		"/?target=600&tolerance=5",
+		"/?relative=10&tolerance=-1",
+	} {
+		checkError(t, "POST "+target, rig.do("POST", target, nil), http.StatusBadRequest)
+		if config := rig.coil.Config(); config.Target != 300 || config.Tolerance != 3 {
+			t.Errorf("POST %s: config target %v tolerance %v, want 300 and 3 unchanged", target, config.Target, config.Tolerance)
+		}
+	}
+
+	if w := rig.do("POST", "/?relative=10&tolerance=4", nil); w.Code != http.StatusOK {
+		t.Fatalf("POST /?relative=10&tolerance=4 = %d: %s", w.Code, w.Body)
+	}
+	if config := rig.coil.Config(); config.Target != 310 || config.Tolerance != 4 {
+		t.Errorf("config target %v tolerance %v, want 310 and 4", config.Target, config.Tolerance)
+	}
+}
+
+func TestTargetOffVersusZero(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	rig.waitReady(t)
+
+	for _, req := range []struct {
+		target, body string
+		disabled     bool
+	}{
+		{"/?target=300", "", false},
+		{"/?target=off", "", true},
+		{"/?target=0", "", false},
+		{"/", `{"target": "off"}`, true},
+		{"/", `{"target": 0}`, false},
+	} {
+		var header []string
+		if req.body != "" {
+			header = []string{"Content-Type", "application/json"}
+		}
+		w := rig.do("POST", req.target, strings.NewReader(req.body), header...)
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST %s %s = %d: %s", req.target, req.body, w.Code, w.Body)
+		}
+		var resp targetResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Disabled != req.disabled {
+			t.Errorf("POST %s %s: disabled = %v, want %v", req.target, req.body, resp.Disabled, req.disabled)
+		}
+		rig.waitFrames(t, 1)
+		if frame := rig.coil.CurrentFrame(); frame.Disabled != req.disabled {
+			t.Errorf("POST %s %s: frame disabled = %v, want %v", req.target, req.body, frame.Disabled, req.disabled)
+		}
+	}
+	checkError(t, "POST /?target=of", rig.do("POST", "/?target=of", nil), http.StatusBadRequest)
+}
+
+func TestHealthDegradesWhenReadsStall(t *testing.T) {
+	rig := newTestRig(t, map[string]string{"PI_HEATER_STALE_AFTER": "10s"})
+	rig.start(t)
+	rig.waitReady(t)
+
+	health := func() (int, healthResponse) {
+		w := rig.do("GET", "/health", nil)
+		var resp healthResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("error while decoding health: %s", err)
+		}
+		return w.Code, resp
+	}
+	sinceMetric := func() float64 {
+		for _, line := range strings.Split(rig.do("GET", "/metrics", nil).Body.String(), "\n") {
+			if strings.HasPrefix(line, "pi_heater_seconds_since_last_read") {
+				value, err := strconv.ParseFloat(line[strings.LastIndex(line, " ")+1:], 64)
+				if err != nil {
+					t.Fatalf("error while parsing %q: %s", line, err)
+				}
+				return value
+			}
+		}
+		t.Fatal("no pi_heater_seconds_since_last_read metric")
+		return 0
+	}
+	if code, resp := health(); code != http.StatusOK || resp.Stale || resp.SinceLastRead == nil {
+		t.Fatalf("healthy coil: %d %+v", code, resp)
+	}
+
+	// With the loop gone nothing reads the thermocouple while the clock keeps going
+	rig.stopCoil(t)
+	rig.clock.Advance(5 * time.Second)
+	if code, resp := health(); code != http.StatusOK || resp.Stale || resp.SinceLastRead == nil || *resp.SinceLastRead < 5 {
+		t.Errorf("5s after the last read: %d %+v, want healthy", code, resp)
+	}
+	early := sinceMetric()
+	rig.clock.Advance(10 * time.Second)
+	code, resp := health()
+	if code != http.StatusServiceUnavailable || !resp.Stale || resp.SinceLastRead == nil || *resp.SinceLastRead < 15 {
+		t.Errorf("15s after the last read: %d %+v, want 503 and stale", code, resp)
+	}
+	if late := sinceMetric(); late <= early {
+		t.Errorf("pi_heater_seconds_since_last_read went from %v to %v, want it to grow", early, late)
+	}
+}
+
+func TestNilLoggers(t *testing.T) {
+	rig := newTestRig(t, nil)
+	if rig.server.errLog == nil || rig.server.infoLog == nil {
+		t.Fatal("NewServer(c, h, nil, nil) left a logger nil")
+	}
+	rig.start(t)
+	rig.waitReady(t)
+	// A request logged as an error, and ones logged as info
+	checkError(t, "POST /?target=hot", rig.do("POST", "/?target=hot", nil), http.StatusBadRequest)
+	for _, target := range []string{"/?target=300", "/window?ms=2000", "/reset-pid"} {
+		if w := rig.do("POST", target, nil); w.Code != http.StatusOK {
+			t.Errorf("POST %s = %d: %s", target, w.Code, w.Body)
+		}
+	}
+}
+
+func TestOversizedBodyRejected(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	rig.waitReady(t)
+
+	// pad makes a JSON body exactly size bytes long, whitespace after the object
+	pad := func(body string, size int) string {
+		return body + strings.Repeat(" ", size-len(body))
+	}
+	for _, req := range []struct{ target, body string }{
+		{"/", `{"target": 300}`},
+		{"/preset", `{"target": 300}`},
+		{"/presets/big", `{"target": 300}`},
+		{"/state", `{"version": 1, "coil": {"target": 300, "max": 1000}, "presets": {}}`},
+	} {
+		w := rig.do("POST", req.target, strings.NewReader(pad(req.body, maxBodyBytes+1)), "Content-Type", "application/json")
+		checkError(t, "POST "+req.target+" with an oversized body", w, http.StatusRequestEntityTooLarge)
+		if w := rig.do("POST", req.target, strings.NewReader(pad(req.body, maxBodyBytes)), "Content-Type", "application/json"); w.Code != http.StatusOK {
+			t.Errorf("POST %s with a body of exactly maxBodyBytes = %d: %s", req.target, w.Code, w.Body)
+		}
+	}
+}
+
+func TestRelativeTargetClamps(t *testing.T) {
+	rig := newTestRig(t, map[string]string{"PI_HEATER_MIN_TARGET": "100", "PI_HEATER_MAX_TARGET": "500"})
+	rig.start(t)
+	rig.waitReady(t)
+
+	for _, tc := range []struct {
+		target string
+		want   float64
+	}{
+		{"/?target=450", 450},
+		{"/?relative=+10", 460},
+		{"/?relative=100", 500},
+		{"/?relative=1", 500},
+		{"/?relative=-50", 450},
+		{"/?relative=-1000", 100},
+		{"/?relative=-5", 100},
+		// A relative move in Celsius is a difference, so it converts without the offset
+		{"/?relative=10&unit=C", 118},
+		{"/?relative=-100&unit=C", 100},
+	} {
+		w := rig.do("POST", tc.target, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST %s = %d: %s", tc.target, w.Code, w.Body)
+		}
+		var resp targetResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(resp.Target-tc.want) > 1e-9 {
+			t.Errorf("POST %s responded with target %v, want %v", tc.target, resp.Target, tc.want)
+		}
+		if got := rig.coil.Config().Target; math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("POST %s: config target %v, want %v", tc.target, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"net/http"
+	"strconv"
+)
+
+// stateVersion is bumped whenever stateDocument changes in a way older servers couldn't restore.
+const stateVersion = 1
+
+// stateDocument is the body of GET and POST /state: the coil's runtime state and the saved presets.
+type stateDocument struct {
+	Version int                    `json:"version"`
+	Coil    *coil.State            `json:"coil"`
+	Presets map[string]coil.Preset `json:"presets"`
+}
+
+func (s *Server) handleExportState() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reply := make(chan coil.State, 1)
+		if !s.send(w, s.coil.ExportState, reply) {
+			return
+		}
+		state := <-reply
+		s.presets.mu.Lock()
+		doc := stateDocument{Version: stateVersion, Coil: &state, Presets: s.presets.presets}
+		payload, err := json.Marshal(&doc)
+		s.presets.mu.Unlock()
+		if err != nil {
+			s.errLogFor(r).Printf("error while marshaling JSON for state: %s", err.Error())
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(payload)
+	}
+}
+
+// handleRestoreState validates the whole document before restoring any of it.
+// The presets are saved first, and put back if the run loop then rejects the coil's state.
+func (s *Server) handleRestoreState() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var doc stateDocument
+		if !s.decodeBody(w, r, &doc) {
+			return
+		}
+		if err := s.validateState(doc); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		s.presets.mu.Lock()
+		defer s.presets.mu.Unlock()
+		old := s.presets.presets
+		s.presets.presets = doc.Presets
+		if err := s.presets.save(); err != nil {
+			s.presets.presets = old
+			s.errLogFor(r).Printf("error while saving presets: %s", err.Error())
+			writeError(w, http.StatusInternalServerError, "error while saving presets: "+err.Error())
+			return
+		}
+		reply := make(chan coil.StateResult, 1)
+		var result coil.StateResult
+		if err := s.coil.Send(s.coil.RestoreState, coil.StateRequest{State: *doc.Coil, Reply: reply}); err != nil {
+			result.Err = err
+		} else {
+			result = <-reply
+		}
+		if result.Err != nil {
+			s.presets.presets = old
+			if err := s.presets.save(); err != nil {
+				s.errLogFor(r).Printf("error while putting back presets: %s", err.Error())
+			}
+			status := http.StatusBadRequest
+			if result.Err == coil.ErrStopped {
+				status = http.StatusServiceUnavailable
+			}
+			writeError(w, status, result.Err.Error())
+			return
+		}
+		s.infoLogFor(r).Printf("restored state with %d presets at request of %s\n", len(doc.Presets), r.RemoteAddr)
+
+		doc.Coil = &result.State
+		payload, err := json.Marshal(&doc)
+		if err != nil {
+			s.errLogFor(r).Printf("error while marshaling JSON for state: %s", err.Error())
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(payload)
+	}
+}
+
+func (s *Server) validateState(doc stateDocument) error {
+	if doc.Version != stateVersion {
+		return errors.New("unsupported state version " + strconv.Itoa(doc.Version) + "; expected " + strconv.Itoa(stateVersion))
+	}
+	if doc.Coil == nil {
+		return errors.New("coil is required")
+	}
+	if doc.Presets == nil {
+		return errors.New("presets is required; send {} for none")
+	}
+	if err := s.coil.ValidateState(*doc.Coil); err != nil {
+		return errors.New("invalid coil state: " + err.Error())
+	}
+	for name, preset := range doc.Presets {
+		if err := s.coil.ValidatePreset(preset); err != nil {
+			return errors.New("invalid preset " + strconv.Quote(name) + ": " + err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// exportState returns the rig's GET /state document, raw and decoded.
+func (rig *testRig) exportState(t *testing.T) (string, stateDocument) {
+	t.Helper()
+	w := rig.do("GET", "/state", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /state = %d: %s", w.Code, w.Body)
+	}
+	var doc stateDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("error while decoding state: %s", err)
+	}
+	return w.Body.String(), doc
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	src := newTestRig(t, nil)
+	src.start(t)
+	for _, req := range []struct{ target, body string }{
+		{"/preset", `{"target": 300, "p": 7, "i": 0.2, "d": 2, "max": 2000, "tolerance": 4}`},
+		{"/presets/low", `{"target": 150, "tolerance": 2}`},
+		{"/presets/hot", `{"target": 90, "unit": "C", "p": 9}`},
+		{"/bake?target=300&hold=30m", ""},
+		{"/estop", ""},
+	} {
+		var header []string
+		if req.body != "" {
+			header = []string{"Content-Type", "application/json"}
+		}
+		if w := src.do("POST", req.target, strings.NewReader(req.body), header...); w.Code >= 300 {
+			t.Fatalf("POST %s = %d: %s", req.target, w.Code, w.Body)
+		}
+	}
+	exported, doc := src.exportState(t)
+	if doc.Coil.Fault == "" || doc.Coil.BakeHold == 0 || len(doc.Presets) != 2 {
+		t.Fatalf("export left out some of the state: %s", exported)
+	}
+
+	dst := newTestRig(t, nil)
+	dst.start(t)
+	w := dst.do("POST", "/state", strings.NewReader(exported), "Content-Type", "application/json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /state = %d: %s", w.Code, w.Body)
+	}
+	if _, restored := dst.exportState(t); !reflect.DeepEqual(restored, doc) {
+		t.Errorf("restored state differs from the export:\n got %+v %+v\nwant %+v %+v", *restored.Coil, restored.Presets, *doc.Coil, doc.Presets)
+	}
+	got, want := dst.coil.Config(), src.coil.Config()
+	if got.Target != want.Target || got.Window != want.Window || got.Tolerance != want.Tolerance {
+		t.Errorf("restored config target %v window %d tolerance %v, want %v %d %v",
+			got.Target, got.Window, got.Tolerance, want.Target, want.Window, want.Tolerance)
+	}
+}
+
+func TestStateRestoreIsAllOrNothing(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	if w := rig.do("POST", "/presets/low", strings.NewReader(`{"target": 150}`), "Content-Type", "application/json"); w.Code >= 300 {
+		t.Fatalf("POST /presets/low = %d: %s", w.Code, w.Body)
+	}
+	before, _ := rig.exportState(t)
+
+	for _, doc := range []string{
+		`{"version": 2, "coil": {"target": 300, "max": 1000}, "presets": {}}`,
+		`{"version": 1, "presets": {}}`,
+		`{"version": 1, "coil": {"target": 300, "max": 1000}}`,
+		`{"version": 1, "coil": {"target": 300}, "presets": {}}`,
+		`{"version": 1, "coil": {"max": 1000, "bake_hold": 60000}, "presets": {}}`,
+		`{"version": 1, "coil": {"target": 300, "max": 1000}, "presets": {"bad": {"max": -5}}}`,
+	} {
+		checkError(t, "POST /state "+doc, rig.do("POST", "/state", strings.NewReader(doc), "Content-Type", "application/json"), http.StatusBadRequest)
+		if after, _ := rig.exportState(t); after != before {
+			t.Errorf("rejected %s changed the state:\n got %s\nwant %s", doc, after, before)
+		}
+	}
+}
+
+func TestStateRestoreValidatesWindowLikeALiveChange(t *testing.T) {
+	rig := newTestRig(t, map[string]string{"PI_HEATER_COALESCE_GAP": "500ms"})
+	rig.start(t)
+	before, _ := rig.exportState(t)
+
+	// A window no longer than PI_HEATER_COALESCE_GAP would keep the coil on from one window to the next
+	for _, doc := range []string{
+		`{"version": 1, "coil": {"target": 300, "max": 500}, "presets": {}}`,
+		`{"version": 1, "coil": {"target": 300, "max": 1000}, "presets": {"short": {"max": 400}}}`,
+	} {
+		checkError(t, "POST /state "+doc, rig.do("POST", "/state", strings.NewReader(doc), "Content-Type", "application/json"), http.StatusBadRequest)
+		if after, _ := rig.exportState(t); after != before {
+			t.Errorf("rejected %s changed the state:\n got %s\nwant %s", doc, after, before)
+		}
+	}
+
+	doc := `{"version": 1, "coil": {"target": 300, "max": 600}, "presets": {}}`
+	if w := rig.do("POST", "/state", strings.NewReader(doc), "Content-Type", "application/json"); w.Code != http.StatusOK {
+		t.Fatalf("POST /state %s = %d: %s", doc, w.Code, w.Body)
+	}
+	if cfg := rig.coil.Config(); cfg.Window != 600 || cfg.MaxFire != 600-15 {
+		t.Errorf("window %dms with max fire %dms after restoring a 600ms window, want 600ms and 585ms", cfg.Window, cfg.MaxFire)
+	}
+}
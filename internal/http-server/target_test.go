@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHTTPAndWebsocketTargetsRace(t *testing.T) {
+	rig := newTestRig(t, nil)
+	rig.start(t)
+	rig.waitReady(t)
+	ts := rig.serve(t)
+	if w := rig.do("POST", "/?target=100", nil); w.Code != http.StatusOK {
+		t.Fatalf("POST /?target=100 = %d: %s", w.Code, w.Body)
+	}
+
+	const nudges = 50
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(ts, "/ws"), nil)
+	if err != nil {
+		t.Fatalf("error while dialing: %s", err)
+	}
+	defer conn.Close()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < nudges; i++ {
+			if w := rig.do("POST", "/?relative=1", nil); w.Code != http.StatusOK {
+				t.Errorf("POST /?relative=1 = %d: %s", w.Code, w.Body)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < nudges; i++ {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"relative": 1}`)); err != nil {
+				t.Errorf("error while sending a websocket target: %s", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	// Every nudge from either source is applied exactly once, so none can be lost to the other
+	want := 100.0 + 2*nudges
+	deadline := time.Now().Add(5 * time.Second)
+	for rig.coil.Config().Target != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("target = %v, want %v after %d nudges from each source", rig.coil.Config().Target, want, nudges)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,276 @@
+// Package mdns advertises and discovers pi-heater servers on the local network with multicast DNS service discovery.
+// It implements only as much of RFC 6762 and RFC 6763 as that takes.
+package mdns
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Service is the DNS-SD service type pi-heater servers advertise.
+const Service = "_pi-heater._tcp.local."
+
+// ttl is how long, in seconds, browsers may cache the advertised records.
+// Replies to legacy unicast queries use legacyTTL, as RFC 6762 asks.
+const (
+	ttl       = 120
+	legacyTTL = 10
+)
+
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Responder answers mDNS queries for one pi-heater server.
+type Responder struct {
+	Instance string // the server's name among the instances of Service
+	Port     int
+	TXT      []string // key=value pairs describing the server
+	host     string
+	conn     *net.UDPConn
+	closed   chan struct{}
+	errLog   *log.Logger
+	infoLog  *log.Logger
+}
+
+// NewResponder joins the mDNS group to advertise the server on port as instance, or as the host name if instance is
+// empty; nil loggers discard their output.
+func NewResponder(instance string, port int, txt []string, errLog, infoLog *log.Logger) (*Responder, error) {
+	if errLog == nil {
+		errLog = log.New(ioutil.Discard, "", 0)
+	}
+	if infoLog == nil {
+		infoLog = log.New(ioutil.Discard, "", 0)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, errors.New("error while getting host name: " + err.Error())
+	}
+	hostname = strings.Split(hostname, ".")[0]
+	if instance == "" {
+		instance = hostname
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return nil, errors.New("error while joining the mDNS group: " + err.Error())
+	}
+	return &Responder{
+		// Dots would split the instance into several labels
+		Instance: strings.Replace(instance, ".", "-", -1),
+		Port:     port,
+		TXT:      txt,
+		host:     hostname + ".local.",
+		conn:     conn,
+		closed:   make(chan struct{}),
+		errLog:   errLog,
+		infoLog:  infoLog,
+	}, nil
+}
+
+func (r *Responder) name() string {
+	return r.Instance + "." + Service
+}
+
+// Run announces the server, then answers queries for it until Close is called.
+func (r *Responder) Run() {
+	r.infoLog.Printf("advertising %s on port %d over mDNS\n", r.name(), r.Port)
+	r.send(r.response(ttl), group)
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-r.closed:
+			default:
+				r.errLog.Printf("error while reading mDNS query, no longer advertising: %s\n", err.Error())
+			}
+			return
+		}
+		query, err := unpack(buf[:n])
+		if err != nil || query.response || !r.asked(query) {
+			continue
+		}
+		if from.Port != group.Port {
+			// A legacy unicast query, e.g. from the client's -discover: answer just the sender, echoing the query
+			resp := r.response(legacyTTL)
+			resp.id = query.id
+			resp.questions = query.questions
+			r.send(resp, from)
+			continue
+		}
+		to := group
+		for _, q := range query.questions {
+			if q.class&classTopBit != 0 {
+				to = from
+			}
+		}
+		r.send(r.response(ttl), to)
+	}
+}
+
+// Close says goodbye, so browsers forget the server, and stops Run.
+func (r *Responder) Close() error {
+	r.send(r.response(0), group)
+	close(r.closed)
+	return r.conn.Close()
+}
+
+func (r *Responder) send(m *message, to *net.UDPAddr) {
+	if _, err := r.conn.WriteToUDP(m.pack(), to); err != nil {
+		r.errLog.Printf("error while sending mDNS response to %s: %s\n", to, err.Error())
+	}
+}
+
+// asked reports whether query asks about the service, the server, or its host.
+func (r *Responder) asked(query *message) bool {
+	for _, q := range query.questions {
+		name := strings.ToLower(q.name)
+		switch {
+		case name == Service && (q.qtype == typePTR || q.qtype == typeANY):
+			return true
+		case name == strings.ToLower(r.name()) && (q.qtype == typeSRV || q.qtype == typeTXT || q.qtype == typeANY):
+			return true
+		case name == strings.ToLower(r.host) && (q.qtype == typeA || q.qtype == typeANY):
+			return true
+		}
+	}
+	return false
+}
+
+// response describes the server: a PTR answer pointing at it, with its SRV, TXT and addresses as additional records.
+func (r *Responder) response(ttl uint32) *message {
+	// Unique records carry the cache-flush bit, except in legacy unicast replies
+	unique := uint16(classIN | classTopBit)
+	if ttl == legacyTTL {
+		unique = classIN
+	}
+	m := &message{
+		response: true,
+		answers:  []record{{name: Service, rtype: typePTR, class: classIN, ttl: ttl, target: r.name()}},
+		extras: []record{
+			{name: r.name(), rtype: typeSRV, class: unique, ttl: ttl, target: r.host, port: uint16(r.Port)},
+			{name: r.name(), rtype: typeTXT, class: unique, ttl: ttl, txt: r.TXT},
+		},
+	}
+	// Looked up every time, since DHCP can hand out a new address while the server runs
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		r.errLog.Printf("error while listing interface addresses: %s\n", err.Error())
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.To4() == nil {
+			continue
+		}
+		m.extras = append(m.extras, record{name: r.host, rtype: typeA, class: unique, ttl: ttl, ip: ipnet.IP.To4()})
+	}
+	return m
+}
+
+// Instance is a pi-heater server found by Browse.
+type Instance struct {
+	Name  string // instance name, without the service
+	Host  string
+	Addrs []net.IP
+	Port  int
+	TXT   []string
+}
+
+// Address returns host:port for the instance's first address, or its .local host name if it sent none.
+func (i Instance) Address() string {
+	host := strings.TrimSuffix(i.Host, ".")
+	if len(i.Addrs) > 0 {
+		host = i.Addrs[0].String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(i.Port))
+}
+
+// Value returns the value of key in the instance's TXT record, or "" if it has none.
+func (i Instance) Value(key string) string {
+	for _, kv := range i.TXT {
+		if strings.HasPrefix(kv, key+"=") {
+			return strings.TrimPrefix(kv, key+"=")
+		}
+	}
+	return ""
+}
+
+// Browse asks the local network for pi-heater servers, collecting answers for timeout.
+// Instances are returned in the order they answered.
+func Browse(timeout time.Duration) ([]Instance, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, errors.New("error while opening socket for mDNS: " + err.Error())
+	}
+	defer conn.Close()
+	query := &message{questions: []question{{name: Service, qtype: typePTR, class: classIN}}}
+	if _, err = conn.WriteToUDP(query.pack(), group); err != nil {
+		return nil, errors.New("error while sending mDNS query: " + err.Error())
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	var order []string
+	found := map[string]*Instance{}
+	instance := func(name string) *Instance {
+		key := strings.ToLower(name)
+		if found[key] == nil {
+			found[key] = &Instance{Name: strings.TrimSuffix(name, "."+Service)}
+			order = append(order, key)
+		}
+		return found[key]
+	}
+	hosts := map[string][]net.IP{}
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return nil, errors.New("error while reading mDNS response: " + err.Error())
+		}
+		resp, err := unpack(buf[:n])
+		if err != nil || !resp.response {
+			continue
+		}
+		for _, rec := range append(resp.answers, resp.extras...) {
+			name := strings.ToLower(rec.name)
+			switch {
+			case rec.rtype == typePTR && name == Service:
+				instance(rec.target)
+			case rec.rtype == typeSRV && strings.HasSuffix(name, "."+Service):
+				inst := instance(rec.name)
+				inst.Host, inst.Port = rec.target, int(rec.port)
+			case rec.rtype == typeTXT && strings.HasSuffix(name, "."+Service):
+				instance(rec.name).TXT = rec.txt
+			case rec.rtype == typeA && rec.ip != nil && !containsIP(hosts[name], rec.ip):
+				hosts[name] = append(hosts[name], rec.ip)
+			}
+		}
+	}
+
+	var instances []Instance
+	for _, key := range order {
+		inst := found[key]
+		if inst.Port == 0 {
+			// Never saw its SRV record, so there is nothing to connect to
+			continue
+		}
+		inst.Addrs = hosts[strings.ToLower(inst.Host)]
+		instances = append(instances, *inst)
+	}
+	return instances, nil
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, other := range ips {
+		if other.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// DNS record types used for service discovery.
+const (
+	typeA   = 1
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+	typeANY = 255
+
+	classIN = 1
+	// classTopBit is the cache-flush bit in a record's class, or the unicast-response bit in a question's.
+	classTopBit = 0x8000
+)
+
+var errMalformed = errors.New("malformed DNS message")
+
+type question struct {
+	name  string
+	qtype uint16
+	class uint16
+}
+
+// record is a resource record; which of the rdata fields are set depends on rtype.
+type record struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+
+	target string // PTR and SRV
+	port   uint16 // SRV
+	ip     net.IP // A
+	txt    []string
+}
+
+type message struct {
+	id        uint16
+	response  bool
+	questions []question
+	answers   []record
+	extras    []record // additional records; authority records are skipped when unpacking
+}
+
+// pack encodes m without name compression.
+func (m *message) pack() []byte {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.id)
+	if m.response {
+		// QR and AA
+		binary.BigEndian.PutUint16(b[2:], 0x8400)
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.extras)))
+	for _, q := range m.questions {
+		b = appendName(b, q.name)
+		b = appendUint16(b, q.qtype)
+		b = appendUint16(b, q.class)
+	}
+	for _, r := range m.answers {
+		b = appendRecord(b, r)
+	}
+	for _, r := range m.extras {
+		b = appendRecord(b, r)
+	}
+	return b
+}
+
+func appendRecord(b []byte, r record) []byte {
+	var rdata []byte
+	switch r.rtype {
+	case typeA:
+		rdata = r.ip.To4()
+	case typePTR:
+		rdata = appendName(nil, r.target)
+	case typeSRV:
+		// Priority and weight are always 0
+		rdata = make([]byte, 4, 6+len(r.target)+2)
+		rdata = appendUint16(rdata, r.port)
+		rdata = appendName(rdata, r.target)
+	case typeTXT:
+		for _, s := range r.txt {
+			rdata = append(rdata, byte(len(s)))
+			rdata = append(rdata, s...)
+		}
+		if len(rdata) == 0 {
+			// An empty TXT record still holds one empty string
+			rdata = []byte{0}
+		}
+	}
+	b = appendName(b, r.name)
+	b = appendUint16(b, r.rtype)
+	b = appendUint16(b, r.class)
+	b = appendUint16(b, uint16(r.ttl>>16))
+	b = appendUint16(b, uint16(r.ttl))
+	b = appendUint16(b, uint16(len(rdata)))
+	return append(b, rdata...)
+}
+
+// appendName encodes a dot separated name; labels are expected to be at most 63 bytes.
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// unpack decodes a message, skipping records of types it doesn't know.
+func unpack(b []byte) (*message, error) {
+	if len(b) < 12 {
+		return nil, errMalformed
+	}
+	m := &message{
+		id:       binary.BigEndian.Uint16(b[0:]),
+		response: b[2]&0x80 != 0,
+	}
+	qd := int(binary.BigEndian.Uint16(b[4:]))
+	an := int(binary.BigEndian.Uint16(b[6:]))
+	ns := int(binary.BigEndian.Uint16(b[8:]))
+	ar := int(binary.BigEndian.Uint16(b[10:]))
+	off := 12
+	for i := 0; i < qd; i++ {
+		name, next, err := readName(b, off)
+		if err != nil || next+4 > len(b) {
+			return nil, errMalformed
+		}
+		m.questions = append(m.questions, question{
+			name:  name,
+			qtype: binary.BigEndian.Uint16(b[next:]),
+			class: binary.BigEndian.Uint16(b[next+2:]),
+		})
+		off = next + 4
+	}
+	for i := 0; i < an+ns+ar; i++ {
+		r, next, err := readRecord(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next
+		switch {
+		case i < an:
+			m.answers = append(m.answers, r)
+		case i >= an+ns:
+			m.extras = append(m.extras, r)
+		}
+	}
+	return m, nil
+}
+
+func readRecord(b []byte, off int) (record, int, error) {
+	var r record
+	name, off, err := readName(b, off)
+	if err != nil || off+10 > len(b) {
+		return r, 0, errMalformed
+	}
+	r.name = name
+	r.rtype = binary.BigEndian.Uint16(b[off:])
+	r.class = binary.BigEndian.Uint16(b[off+2:])
+	r.ttl = binary.BigEndian.Uint32(b[off+4:])
+	length := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	end := off + length
+	if end > len(b) {
+		return r, 0, errMalformed
+	}
+	switch r.rtype {
+	case typeA:
+		if length == 4 {
+			r.ip = net.IP(append([]byte(nil), b[off:end]...))
+		}
+	case typePTR:
+		if r.target, _, err = readName(b, off); err != nil {
+			return r, 0, err
+		}
+	case typeSRV:
+		if length < 7 {
+			return r, 0, errMalformed
+		}
+		r.port = binary.BigEndian.Uint16(b[off+4:])
+		if r.target, _, err = readName(b, off+6); err != nil {
+			return r, 0, err
+		}
+	case typeTXT:
+		for i := off; i < end; {
+			n := int(b[i])
+			if i+1+n > end {
+				return r, 0, errMalformed
+			}
+			if n > 0 {
+				r.txt = append(r.txt, string(b[i+1:i+1+n]))
+			}
+			i += 1 + n
+		}
+	}
+	return r, end, nil
+}
+
+// readName decodes the name at off, following compression pointers, and returns the offset just past it.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errMalformed
+		}
+		n := int(b[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(b) {
+				return "", 0, errMalformed
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errMalformed
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3FFF)
+		default:
+			if off+1+n > len(b) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(b[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
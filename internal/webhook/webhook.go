@@ -0,0 +1,133 @@
+// Package webhook POSTs coil events to configured URLs.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Payload styles; raw sends the coil.CoilEvent itself, the others a chat message describing it.
+const (
+	StyleRaw     = "raw"
+	StyleSlack   = "slack"
+	StyleDiscord = "discord"
+)
+
+// ParseStyle validates a payload style, defaulting to raw.
+func ParseStyle(s string) (string, error) {
+	switch s {
+	case "":
+		return StyleRaw, nil
+	case StyleRaw, StyleSlack, StyleDiscord:
+		return s, nil
+	}
+	return "", errors.New("unknown webhook style: " + s)
+}
+
+// Notifier POSTs each coil event as JSON to the URL configured for its type.
+type Notifier struct {
+	URLs    map[string]string // by event type
+	Style   string
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration // doubled after every failed attempt
+	errLog  *log.Logger
+	infoLog *log.Logger
+}
+
+// NewNotifier returns a raw style notifier for the URLs keyed by event type; nil loggers discard their output.
+func NewNotifier(urls map[string]string, errLog, infoLog *log.Logger) *Notifier {
+	if errLog == nil {
+		errLog = log.New(ioutil.Discard, "", 0)
+	}
+	if infoLog == nil {
+		infoLog = log.New(ioutil.Discard, "", 0)
+	}
+	return &Notifier{
+		URLs:    urls,
+		Style:   StyleRaw,
+		Client:  &http.Client{Timeout: 5 * time.Second},
+		Retries: 3,
+		Backoff: time.Second,
+		errLog:  errLog,
+		infoLog: infoLog,
+	}
+}
+
+// Run delivers events until the channel is closed; each delivery runs on its own goroutine so a slow
+// endpoint never holds up later events.
+func (n *Notifier) Run(events <-chan coil.CoilEvent) {
+	for event := range events {
+		url := n.URLs[event.Type]
+		if url == "" {
+			continue
+		}
+		go n.deliver(url, event)
+	}
+}
+
+func (n *Notifier) deliver(url string, event coil.CoilEvent) {
+	payload, err := n.payload(event)
+	if err != nil {
+		n.errLog.Printf("error while marshaling JSON for %s webhook: %s\n", event.Type, err.Error())
+		return
+	}
+	backoff := n.Backoff
+	for attempt := 1; ; attempt++ {
+		err = n.post(url, payload)
+		if err == nil {
+			n.infoLog.Printf("sent %s webhook\n", event.Type)
+			return
+		}
+		if attempt > n.Retries {
+			n.errLog.Printf("giving up on %s webhook after %d attempts: %s\n", event.Type, attempt, err.Error())
+			return
+		}
+		n.errLog.Printf("error while sending %s webhook, retrying in %s: %s\n", event.Type, backoff, err.Error())
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// payload encodes event in the notifier's style.
+func (n *Notifier) payload(event coil.CoilEvent) ([]byte, error) {
+	switch n.Style {
+	case StyleSlack:
+		return json.Marshal(map[string]string{"text": message(event)})
+	case StyleDiscord:
+		return json.Marshal(map[string]string{"content": message(event)})
+	}
+	return json.Marshal(&event)
+}
+
+// message describes event in a sentence for chat apps.
+func message(event coil.CoilEvent) string {
+	switch event.Type {
+	case coil.EventReached:
+		return fmt.Sprintf("Reached target of %.0f°%s", event.Target, event.Unit)
+	case coil.EventDone:
+		return fmt.Sprintf("Bake done; turned the coil off at %.0f°%s", event.Temp, event.Unit)
+	case coil.EventFault:
+		return fmt.Sprintf("Coil faulted at %.0f°%s: %s", event.Temp, event.Unit, event.Reason)
+	}
+	return fmt.Sprintf("Coil %s event at %.0f°%s", event.Type, event.Temp, event.Unit)
+}
+
+func (n *Notifier) post(url string, payload []byte) error {
+	resp, err := n.Client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("received non-2xx status code: " + resp.Status)
+	}
+	return nil
+}
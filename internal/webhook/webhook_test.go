@@ -0,0 +1,114 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+)
+
+var testEvent = coil.CoilEvent{
+	ID:     7,
+	Type:   coil.EventFault,
+	Time:   time.Date(2020, 1, 1, 10, 3, 0, 0, time.UTC),
+	Temp:   1203.6,
+	Target: 1200,
+	Unit:   coil.InternalUnit,
+	Phase:  coil.PhaseHolding,
+	Reason: "thermocouple reported fault: open circuit",
+}
+
+// receive delivers event with n and returns the request's content type and JSON body, decoded.
+func receive(t *testing.T, n *Notifier, event coil.CoilEvent) (string, map[string]interface{}) {
+	t.Helper()
+	type request struct {
+		contentType string
+		body        []byte
+	}
+	requests := make(chan request, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{r.Header.Get("Content-Type"), body}
+	}))
+	defer ts.Close()
+	n.URLs = map[string]string{event.Type: ts.URL}
+
+	events := make(chan coil.CoilEvent, 1)
+	events <- event
+	close(events)
+	n.Run(events)
+	select {
+	case req := <-requests:
+		var body map[string]interface{}
+		if err := json.Unmarshal(req.body, &body); err != nil {
+			t.Fatalf("webhook body %q isn't a JSON object: %s", req.body, err)
+		}
+		return req.contentType, body
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook delivered")
+	}
+	return "", nil
+}
+
+func TestPayloadStyles(t *testing.T) {
+	for _, tc := range []struct {
+		style string
+		want  map[string]interface{}
+	}{
+		{StyleSlack, map[string]interface{}{"text": "Coil faulted at 1204°F: thermocouple reported fault: open circuit"}},
+		{StyleDiscord, map[string]interface{}{"content": "Coil faulted at 1204°F: thermocouple reported fault: open circuit"}},
+		{StyleRaw, map[string]interface{}{
+			"id":     float64(7),
+			"type":   "fault",
+			"time":   "2020-01-01T10:03:00Z",
+			"temp":   1203.6,
+			"target": float64(1200),
+			"unit":   "F",
+			"phase":  "holding",
+			"reason": "thermocouple reported fault: open circuit",
+		}},
+	} {
+		n := NewNotifier(nil, nil, nil)
+		n.Style = tc.style
+		contentType, body := receive(t, n, testEvent)
+		if contentType != "application/json" {
+			t.Errorf("%s: Content-Type %q, want application/json", tc.style, contentType)
+		}
+		if !reflect.DeepEqual(body, tc.want) {
+			t.Errorf("%s: body %v, want %v", tc.style, body, tc.want)
+		}
+	}
+}
+
+func TestChatMessages(t *testing.T) {
+	for _, tc := range []struct {
+		event coil.CoilEvent
+		want  string
+	}{
+		{coil.CoilEvent{Type: coil.EventReached, Target: 1200, Unit: coil.InternalUnit}, "Reached target of 1200°F"},
+		{coil.CoilEvent{Type: coil.EventDone, Temp: 1199.5, Unit: coil.InternalUnit}, "Bake done; turned the coil off at 1200°F"},
+		{coil.CoilEvent{Type: coil.EventCleared, Temp: 80, Unit: coil.InternalUnit}, "Coil cleared event at 80°F"},
+	} {
+		n := NewNotifier(nil, nil, nil)
+		n.Style = StyleSlack
+		if _, body := receive(t, n, tc.event); body["text"] != tc.want {
+			t.Errorf("%s: text %q, want %q", tc.event.Type, body["text"], tc.want)
+		}
+	}
+}
+
+func TestParseStyle(t *testing.T) {
+	for in, want := range map[string]string{"": StyleRaw, "raw": StyleRaw, "slack": StyleSlack, "discord": StyleDiscord} {
+		if got, err := ParseStyle(in); err != nil || got != want {
+			t.Errorf("ParseStyle(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseStyle("teams"); err == nil {
+		t.Error("ParseStyle accepted an unknown style")
+	}
+}
@@ -1,9 +1,11 @@
 package hub
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
 )
 
 const (
@@ -27,6 +29,100 @@ type Client struct {
 	hub  *Hub
 	conn *websocket.Conn
 	send chan []byte
+	info ClientInfo
+
+	// Decimation state, only touched from the hub's run loop.
+	every    int
+	interval time.Duration
+	seen     int
+	lastSent time.Time
+}
+
+// subscription is a control message a client sends to receive frames less often than every window.
+// A zero value restores full rate.
+type subscription struct {
+	Every    int   `json:"every"`    // only deliver every Nth frame
+	Interval int64 `json:"interval"` // minimum milliseconds between deliveries
+}
+
+// control is a message from a client: a subscription, or if it has a target or relative, a target to set as with
+// POST / instead.
+type control struct {
+	subscription
+	Target   *float64 `json:"target"`
+	Relative *float64 `json:"relative"`
+	Unit     string   `json:"unit"` // of target or relative; defaults to the coil's unit
+}
+
+type subscriptionRequest struct {
+	client *Client
+	sub    subscription
+}
+
+// due reports whether the next frame should be delivered to c, advancing its decimation state.
+func (c *Client) due(now time.Time) bool {
+	c.seen++
+	if c.every > 1 && c.seen%c.every != 0 {
+		return false
+	}
+	if c.interval > 0 && now.Sub(c.lastSent) < c.interval {
+		return false
+	}
+	c.lastSent = now
+	return true
+}
+
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unregister <- c
+		c.conn.Close()
+	}()
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg control
+		if err := json.Unmarshal(message, &msg); err != nil {
+			c.hub.errLog.Printf("error while decoding websocket control message: %s\n", err.Error())
+			continue
+		}
+		if msg.Target != nil || msg.Relative != nil {
+			c.setTarget(msg)
+			continue
+		}
+		c.hub.subscribe <- subscriptionRequest{client: c, sub: msg.subscription}
+	}
+}
+
+// setTarget hands a target from the client to the coil, which orders it with targets from every other source.
+func (c *Client) setTarget(msg control) {
+	if msg.Target != nil && msg.Relative != nil {
+		c.hub.errLog.Printf("ignoring websocket target from %s with both target and relative\n", c.info.RemoteAddr)
+		return
+	}
+	unit, err := coil.ParseUnit(msg.Unit)
+	if err != nil {
+		c.hub.errLog.Printf("ignoring websocket target from %s: %s\n", c.info.RemoteAddr, err.Error())
+		return
+	}
+	cmd := coil.TargetCommand{Source: "websocket " + c.info.RemoteAddr, Deadman: true}
+	if msg.Relative != nil {
+		// A difference converts by scale alone, without the offset
+		cmd.Target = unit.ToInternal(*msg.Relative) - unit.ToInternal(0)
+		cmd.Relative = true
+	} else {
+		cmd.Target = unit.ToInternal(*msg.Target)
+	}
+	if err := c.hub.coil.Send(c.hub.coil.SetTarget, cmd); err != nil {
+		c.hub.errLog.Printf("ignoring websocket target from %s: %s\n", c.info.RemoteAddr, err.Error())
+	}
 }
 
 func (c *Client) writePump() {
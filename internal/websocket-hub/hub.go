@@ -1,47 +1,93 @@
 package hub
 
 import (
-	"github.com/raphaelreyna/pi-heater/pkg/coil"
 	"encoding/json"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ClientInfo describes a connected websocket client.
+type ClientInfo struct {
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
 type Hub struct {
+	marshalErrors uint64 // accessed atomically
+
 	coil       *coil.Coil
-	clients    map[*Client]bool
+	clients    map[*Client]ClientInfo
 	register   chan *Client
 	unregister chan *Client
+	subscribe  chan subscriptionRequest
+	queries    chan chan []ClientInfo
+	done       chan struct{}
 	errLog     *log.Logger
 	infoLog    *log.Logger
 	running    bool
 	Stop       chan struct{}
 	WaitGroup  *sync.WaitGroup
+	// Compression negotiates permessage-deflate with clients that support it.
+	Compression bool
+	// FrameInterval is the least time between broadcast frames; frames arriving sooner are replaced by the latest one.
+	// Zero broadcasts every frame.
+	FrameInterval time.Duration
+	// Heartbeat sends every client a heartbeat frame once this long has gone by without a broadcast; zero disables it.
+	Heartbeat time.Duration
+	// UpgradeError writes the response when a websocket handshake fails; defaults to a plain text http.Error.
+	UpgradeError func(w http.ResponseWriter, r *http.Request, status int, reason error)
 }
 
+// NewHub returns a hub fanning out coil's frames; nil loggers discard their output.
 func NewHub(coil *coil.Coil, infoLog, errLog *log.Logger) *Hub {
+	if infoLog == nil {
+		infoLog = log.New(ioutil.Discard, "", 0)
+	}
+	if errLog == nil {
+		errLog = log.New(ioutil.Discard, "", 0)
+	}
 	return &Hub{
 		coil:       coil,
 		infoLog:    infoLog,
 		errLog:     errLog,
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
+		subscribe:  make(chan subscriptionRequest),
+		clients:    make(map[*Client]ClientInfo),
+		queries:    make(chan chan []ClientInfo),
+		done:       make(chan struct{}),
 		Stop:       make(chan struct{}),
 	}
 }
 
+// Run is the hub's fan-out loop; it returns once a value is received on Stop.
+// If WaitGroup is set, callers must call WaitGroup.Add(1) before starting Run; Run calls Done when it returns.
 func (h *Hub) Run() {
 	h.infoLog.Println("starting websocket hub run loop")
-	if h.WaitGroup != nil {
-		h.WaitGroup.Add(1)
-	}
 	h.running = true
+	var lastBroadcast time.Time
+	var pending *coil.CoilFrame
+	var throttle <-chan time.Time
+	var heartbeat <-chan time.Time
+	if h.Heartbeat > 0 {
+		heartbeat = time.After(h.Heartbeat)
+	}
+	coilDone := h.coil.Done()
+	// terminated is the final frame, once the coil has stopped
+	var terminated []byte
 	for h.running {
 		select {
 		case client := <-h.register:
-			h.clients[client] = true
+			if coilDone == nil {
+				h.terminate(client, terminated)
+				continue
+			}
+			h.clients[client] = client.info
 			h.infoLog.Printf("registered new websocket client")
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
@@ -49,45 +95,174 @@ func (h *Hub) Run() {
 				close(client.send)
 			}
 			h.infoLog.Printf("unregistered new websocket client")
-		case frame := <-h.coil.CurrentFrameChan:
-			payload, err := json.Marshal(&frame)
-			if err != nil {
-				panic(err)
+		case reply := <-h.queries:
+			clients := make([]ClientInfo, 0, len(h.clients))
+			for _, info := range h.clients {
+				clients = append(clients, info)
 			}
-			for client := range h.clients {
-				select {
-				case client.send <- payload:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+			reply <- clients
+		case req := <-h.subscribe:
+			req.client.every = req.sub.Every
+			req.client.interval = time.Duration(req.sub.Interval) * time.Millisecond
+			req.client.seen = 0
+			h.infoLog.Printf("websocket client subscribed to every %d frames, at most every %s", req.sub.Every, req.client.interval)
+		case frame := <-h.coil.CurrentFrameChan.C():
+			now := time.Now()
+			if h.FrameInterval > 0 && now.Sub(lastBroadcast) < h.FrameInterval {
+				// Hold on to the latest frame until the interval is up
+				if pending == nil {
+					throttle = time.After(h.FrameInterval - now.Sub(lastBroadcast))
 				}
+				pending = &frame
+				continue
+			}
+			h.broadcast(frame, now)
+			lastBroadcast = now
+			if h.Heartbeat > 0 {
+				heartbeat = time.After(h.Heartbeat)
+			}
+		case now := <-throttle:
+			throttle = nil
+			if pending != nil {
+				h.broadcast(*pending, now)
+				pending = nil
+				lastBroadcast = now
+				if h.Heartbeat > 0 {
+					heartbeat = time.After(h.Heartbeat)
+				}
+			}
+		case now := <-heartbeat:
+			h.sendHeartbeat(now)
+			heartbeat = time.After(h.Heartbeat)
+		case <-coilDone:
+			// The coil won't send another frame; say so rather than leave clients waiting on a quiet socket
+			h.infoLog.Printf("coil run loop exited; closing websocket clients\n")
+			coilDone = nil
+			terminated = h.terminatedFrame()
+			for client := range h.clients {
+				h.terminate(client, terminated)
+				delete(h.clients, client)
 			}
-			h.infoLog.Printf("sent out frame:\n%s", string(payload))
 		case <-h.Stop:
+			if terminated == nil {
+				terminated = h.terminatedFrame()
+			}
 			for client := range h.clients {
-				select {
-				case client.send <- []byte("server process killed"):
-				default:
-				}
-				close(client.send)
+				h.terminate(client, terminated)
 			}
 			h.running = false
 			h.infoLog.Println("stopped websocket hub run loop")
 		}
 	}
+	close(h.done)
 	if h.WaitGroup != nil {
 		h.WaitGroup.Done()
 	}
 }
 
+// terminatedFrame marshals the coil's last frame marked Terminated, or returns nil if it can't.
+func (h *Hub) terminatedFrame() []byte {
+	frame := h.coil.CurrentFrame()
+	frame.Terminated = true
+	payload, err := json.Marshal(&frame)
+	if err != nil {
+		atomic.AddUint64(&h.marshalErrors, 1)
+		h.errLog.Printf("error while marshaling JSON for shutdown frame: %s\n", err.Error())
+		return nil
+	}
+	return payload
+}
+
+// terminate sends client the final frame, if there is one and it has room, and closes its connection.
+func (h *Hub) terminate(client *Client, frame []byte) {
+	if frame != nil {
+		select {
+		case client.send <- frame:
+		default:
+		}
+	}
+	close(client.send)
+}
+
+// broadcast sends frame to every client that is due one, dropping clients that have fallen behind.
+func (h *Hub) broadcast(frame coil.CoilFrame, now time.Time) {
+	payload, err := json.Marshal(&frame)
+	if err != nil {
+		atomic.AddUint64(&h.marshalErrors, 1)
+		h.errLog.Printf("error while marshaling JSON for frame, skipping: %s\n", err.Error())
+		return
+	}
+	for client := range h.clients {
+		if !client.due(now) {
+			continue
+		}
+		select {
+		case client.send <- payload:
+		default:
+			close(client.send)
+			delete(h.clients, client)
+		}
+	}
+	h.infoLog.Printf("sent out frame:\n%s", string(payload))
+}
+
+// sendHeartbeat sends every client a heartbeat frame, whatever it subscribed to, dropping clients that have fallen behind.
+func (h *Hub) sendHeartbeat(now time.Time) {
+	// Only the fields a heartbeat carries, rather than a coil.CoilFrame full of zero values
+	frame := struct {
+		Seq       uint64    `json:"seq"`
+		Timestamp time.Time `json:"timestamp"`
+		Heartbeat bool      `json:"heartbeat"`
+	}{h.coil.Metrics().LastSeq, now, true}
+	payload, err := json.Marshal(&frame)
+	if err != nil {
+		atomic.AddUint64(&h.marshalErrors, 1)
+		h.errLog.Printf("error while marshaling JSON for heartbeat frame: %s\n", err.Error())
+		return
+	}
+	for client := range h.clients {
+		select {
+		case client.send <- payload:
+		default:
+			close(client.send)
+			delete(h.clients, client)
+		}
+	}
+}
+
+// Clients returns a snapshot of the connected clients, or nil once the hub has stopped.
+func (h *Hub) Clients() []ClientInfo {
+	reply := make(chan []ClientInfo, 1)
+	select {
+	case h.queries <- reply:
+		return <-reply
+	case <-h.done:
+		return nil
+	}
+}
+
+// MarshalErrors returns how many frames have been skipped because they could not be marshaled.
+func (h *Hub) MarshalErrors() uint64 {
+	return atomic.LoadUint64(&h.marshalErrors)
+}
+
 func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	u := upgrader
+	u.EnableCompression = h.Compression
+	u.Error = h.UpgradeError
+	conn, err := u.Upgrade(w, r, nil)
 	if err != nil {
 		h.errLog.Println(err)
 		return
 	}
-	client := &Client{hub: h, conn: conn, send: make(chan []byte, 256)}
+	client := &Client{
+		hub:  h,
+		conn: conn,
+		send: make(chan []byte, 256),
+		info: ClientInfo{RemoteAddr: r.RemoteAddr, ConnectedAt: time.Now()},
+	}
 	client.hub.register <- client
 
 	go client.writePump()
+	go client.readPump()
 }
@@ -0,0 +1,180 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/raphaelreyna/pi-heater/pkg/coil"
+)
+
+// testEnv is the least configuration coil.NewCoil needs; it simulates the kiln so no device files are opened.
+var testEnv = map[string]string{
+	"PI_HEATER_SIMULATE": "1",
+	"PI_HEATER_PID_P":    "5",
+	"PI_HEATER_PID_I":    "0.1",
+	"PI_HEATER_PID_D":    "1",
+	"PI_HEATER_PID_MAX":  "1000",
+}
+
+// newTestHub returns a running hub in front of a running simulated coil, both stopped when t ends.
+func newTestHub(t *testing.T) (*Hub, *coil.Coil) {
+	t.Helper()
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "PI_HEATER_") {
+			key := kv[:strings.Index(kv, "=")]
+			os.Unsetenv(key)
+			defer os.Setenv(key, kv[len(key)+1:])
+		}
+	}
+	for k, v := range testEnv {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	c, err := coil.NewCoil(nil, nil)
+	if err != nil {
+		t.Fatalf("NewCoil: %s", err)
+	}
+	h := NewHub(c, nil, nil)
+	go c.Run()
+	go h.Run()
+	t.Cleanup(func() {
+		c.Send(c.Stop, struct{}{})
+		<-c.Done()
+		select {
+		case h.Stop <- struct{}{}:
+		case <-h.done:
+		}
+	})
+	return h, c
+}
+
+// within fails t unless f returns in a few seconds.
+func within(t *testing.T, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s blocked", what)
+	}
+}
+
+func TestClientsAfterCoilStops(t *testing.T) {
+	h, c := newTestHub(t)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("error while dialing: %s", err)
+	}
+	defer conn.Close()
+	c.Stop <- struct{}{}
+	<-c.Done()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("connection ended before a terminated frame: %s", err)
+		}
+		var frame coil.CoilFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			t.Fatalf("error while decoding frame: %s", err)
+		}
+		if frame.Terminated {
+			break
+		}
+	}
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("connection stayed open after the terminated frame")
+	}
+
+	within(t, "a websocket target", func() {
+		target := 300.0
+		client := &Client{hub: h, info: ClientInfo{RemoteAddr: "test"}}
+		client.setTarget(control{Target: &target})
+	})
+	within(t, "Clients", func() {
+		if clients := h.Clients(); len(clients) != 0 {
+			t.Errorf("hub still has %d clients after the coil stopped", len(clients))
+		}
+	})
+}
+
+func TestNilLoggers(t *testing.T) {
+	h, _ := newTestHub(t)
+	if h.errLog == nil || h.infoLog == nil {
+		t.Fatal("NewHub(c, nil, nil) left a logger nil")
+	}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("error while dialing: %s", err)
+	}
+	// Each of these is logged, on one logger or the other
+	for _, msg := range []string{`not json`, `{"target": 300, "relative": 5}`, `{"target": 300, "unit": "X"}`, `{"target": 300}`} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatalf("error while sending %s: %s", msg, err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("no frame after the control messages: %s", err)
+	}
+	conn.Close()
+	within(t, "the hub dropping the client", func() {
+		for len(h.Clients()) != 0 {
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+func TestStopSendsTerminatedFrame(t *testing.T) {
+	h, c := newTestHub(t)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("error while dialing: %s", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("no frame before stopping: %s", err)
+	}
+
+	// The hub stops while the coil is still running, as it does when the server shuts down
+	h.Stop <- struct{}{}
+	var last coil.CoilFrame
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("connection ended before a terminated frame: %s", err)
+		}
+		var frame coil.CoilFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			t.Fatalf("final message %q isn't a frame: %s", data, err)
+		}
+		if frame.Terminated {
+			last = frame
+			break
+		}
+	}
+	if last.Seq == 0 || last.Seq > c.CurrentFrame().Seq {
+		t.Errorf("terminated frame has seq %d, want the last frame's, at most %d", last.Seq, c.CurrentFrame().Seq)
+	}
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("connection stayed open after the terminated frame")
+	}
+}
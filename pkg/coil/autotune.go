@@ -0,0 +1,161 @@
+package coil
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// AutotuneCycles is how many full oscillations an autotune measures, after the first rise to the target.
+const AutotuneCycles = 4
+
+// Autotune states reported in AutotuneStatus.
+const (
+	AutotuneRunning  = "running"
+	AutotuneDone     = "done"
+	AutotuneAborted  = "aborted"
+	AutotuneTimedOut = "timed_out"
+)
+
+// AutotuneRequest starts a relay autotune around Target. Why it couldn't start, if it couldn't, is sent on Reply,
+// which must be buffered.
+type AutotuneRequest struct {
+	Target float64
+	Reply  chan error
+}
+
+// AutotuneStatus is reported in frames while an autotune runs, and after it ends until the next target is set.
+type AutotuneStatus struct {
+	State  string `json:"state"`
+	Cycle  int    `json:"cycle"`  // oscillations measured so far
+	Cycles int    `json:"cycles"` // oscillations needed
+	// Reason is why the autotune was aborted.
+	Reason string `json:"reason,omitempty"`
+	// Gains are suggested once the autotune is done; they are not applied.
+	Gains *AutotuneGains `json:"gains,omitempty"`
+}
+
+// AutotuneGains are PID gains suggested by an autotune, in the same units as PI_HEATER_PID_P, _I and _D.
+type AutotuneGains struct {
+	P float64 `json:"p"`
+	I float64 `json:"i"`
+	D float64 `json:"d"`
+	// The oscillation's ultimate gain, in milliseconds of fire time per degree, and period the gains derive from
+	Ku float64 `json:"ku"`
+	Tu float64 `json:"tu"` // seconds
+}
+
+// autotune is the relay autotune in progress. The coil fires fully below the target and not at all above it,
+// within the hysteresis band, and the resulting oscillation's period and amplitude give the gains.
+type autotune struct {
+	target  float64
+	started time.Time
+	heating bool
+	// lastCycle is when the last oscillation ended by the coil switching back on; zero until the first one
+	lastCycle  time.Time
+	high, low  float64
+	periods    []time.Duration
+	amplitudes []float64
+}
+
+func (c *Coil) startAutotune(req AutotuneRequest) {
+	switch {
+	case c.fault != "":
+		req.Reply <- errors.New("can't autotune while faulted: " + c.fault)
+		return
+	case !c.rampStart.IsZero():
+		req.Reply <- errors.New("can't autotune while shutting down")
+		return
+	case c.disabled:
+		req.Reply <- errors.New("can't autotune while the element is disabled")
+		return
+	case req.Target <= c.ambient:
+		req.Reply <- errors.New("autotune target must be above PI_HEATER_AMBIENT_TEMP")
+		return
+	}
+	c.setTarget(req.Target)
+	target := c.Target()
+	c.tune = &autotune{target: target, started: c.Clock.Now(), heating: c.Temp < target}
+	c.tuneStatus = &AutotuneStatus{State: AutotuneRunning, Cycles: AutotuneCycles}
+	c.infoLog.Printf("started autotune around %.2ff\n", target)
+	req.Reply <- nil
+}
+
+// autotuneFire is the fire time for this window while an autotune runs; tuning is false if none is.
+func (c *Coil) autotuneFire(now time.Time) (fire time.Duration, tuning bool) {
+	t := c.tune
+	if t == nil {
+		return 0, false
+	}
+	if c.autotuneTimeout > 0 && now.Sub(t.started) > c.autotuneTimeout {
+		c.endAutotune(AutotuneTimedOut, "no steady oscillation within PI_HEATER_AUTOTUNE_TIMEOUT")
+		return 0, false
+	}
+	switch {
+	case t.heating && c.Temp >= t.target+c.hysteresis:
+		t.heating = false
+	case !t.heating && c.Temp <= t.target-c.hysteresis:
+		t.heating = true
+		if !t.lastCycle.IsZero() {
+			t.periods = append(t.periods, now.Sub(t.lastCycle))
+			t.amplitudes = append(t.amplitudes, (t.high-t.low)/2)
+			c.tuneStatus.Cycle = len(t.periods)
+			c.infoLog.Printf("autotune measured oscillation %d of %d\n", len(t.periods), AutotuneCycles)
+		}
+		t.lastCycle = now
+		t.high, t.low = c.Temp, c.Temp
+		if len(t.periods) == AutotuneCycles {
+			c.finishAutotune()
+			return 0, false
+		}
+	}
+	t.high = math.Max(t.high, c.Temp)
+	t.low = math.Min(t.low, c.Temp)
+	if t.heating {
+		return time.Duration(c.maxFire) * time.Millisecond, true
+	}
+	return 0, true
+}
+
+// finishAutotune suggests Ziegler-Nichols gains from the measured oscillations.
+func (c *Coil) finishAutotune() {
+	t := c.tune
+	var period time.Duration
+	var amplitude float64
+	for i := range t.periods {
+		period += t.periods[i]
+		amplitude += t.amplitudes[i]
+	}
+	tu := (period / time.Duration(len(t.periods))).Seconds()
+	amplitude /= float64(len(t.amplitudes))
+	// The hysteresis band widens the oscillation; take it back out where it can be
+	if amplitude > c.hysteresis {
+		amplitude = math.Sqrt(amplitude*amplitude - c.hysteresis*c.hysteresis)
+	}
+	if amplitude <= 0 || tu <= 0 {
+		c.endAutotune(AutotuneAborted, "the temperature didn't oscillate")
+		return
+	}
+	// The relay swings the output by half the longest pulse either side of its middle
+	ku := 4 * (c.maxFire / 2) / (math.Pi * amplitude)
+	gains := &AutotuneGains{P: 0.6 * ku, I: 1.2 * ku / tu, D: 0.075 * ku * tu, Ku: ku, Tu: tu}
+	c.tune = nil
+	c.tuneStatus.State = AutotuneDone
+	c.tuneStatus.Gains = gains
+	c.lastPIDUpdate = time.Time{}
+	c.infoLog.Printf("autotune done; suggested gains p=%.4g i=%.4g d=%.4g\n", gains.P, gains.I, gains.D)
+	c.emit(EventAutotune, AutotuneDone)
+}
+
+// endAutotune stops the autotune in progress, if any, leaving the coil holding its target with the current gains.
+func (c *Coil) endAutotune(state, reason string) {
+	if c.tune == nil {
+		return
+	}
+	c.tune = nil
+	c.tuneStatus.State = state
+	c.tuneStatus.Reason = reason
+	c.lastPIDUpdate = time.Time{}
+	c.errLog.Printf("autotune %s: %s\n", state, reason)
+	c.emit(EventAutotune, state+": "+reason)
+}
@@ -0,0 +1,62 @@
+package coil
+
+import (
+	"math"
+	"time"
+)
+
+// BakeRequest heats to Target, holds it for Hold once the temperature is within tolerance, then disables the element
+// as TargetCommand.Off does, leaving the target where it was.
+// The target, clamped like any other, is sent on Reply, which must be buffered.
+// Setting any other target cancels the bake; a value on CancelBake cancels it and disables the element too.
+type BakeRequest struct {
+	Target float64
+	Hold   time.Duration
+	Reply  chan float64
+}
+
+// bake is the bake in progress; holdStart is zero until the temperature first comes within tolerance.
+type bake struct {
+	hold      time.Duration
+	holdStart time.Time
+}
+
+func (c *Coil) startBake(req BakeRequest) {
+	target := req.Target
+	if c.maxTarget != 0 {
+		target = math.Min(target, c.maxTarget)
+	}
+	c.enable("bake")
+	c.setTarget(target)
+	if !c.rampStart.IsZero() {
+		// setTarget ignored it
+		req.Reply <- c.Target()
+		return
+	}
+	c.bake = &bake{hold: req.Hold}
+	c.infoLog.Printf("baking at %.2ff for %s once within tolerance\n", target, req.Hold)
+	req.Reply <- target
+}
+
+// trackBake counts down the bake's hold from when the temperature first comes within tolerance of the target,
+// disabling the element and emitting a done event once it runs out. It returns what is left of the hold.
+func (c *Coil) trackBake(now time.Time) time.Duration {
+	if c.bake == nil {
+		return 0
+	}
+	if c.bake.holdStart.IsZero() {
+		if c.inBandSince.IsZero() {
+			return c.bake.hold
+		}
+		c.bake.holdStart = now
+		c.infoLog.Printf("bake reached temperature; holding for %s\n", c.bake.hold)
+	}
+	remaining := c.bake.hold - now.Sub(c.bake.holdStart)
+	if remaining > 0 {
+		return remaining
+	}
+	c.infoLog.Printf("bake held for %s; turning the coil off\n", c.bake.hold)
+	c.disable("bake")
+	c.emit(EventDone, "")
+	return 0
+}
@@ -0,0 +1,63 @@
+package coil
+
+import (
+	"testing"
+	"time"
+)
+
+// bakeAt starts a bake at target held for hold on a running coil whose readings are already at target.
+func bakeAt(t *testing.T, target float64, hold time.Duration) (*Coil, *MockClock, <-chan CoilEvent) {
+	t.Helper()
+	c, clock := newTestCoil(t, nil)
+	c.temp = newScriptedReader(target)
+	c.status = &recordingStatus{}
+	events, unsubscribe := c.Subscribe()
+	t.Cleanup(unsubscribe)
+	startCoil(t, c, clock)
+	reply := make(chan float64, 1)
+	c.Bake <- BakeRequest{Target: target, Hold: hold, Reply: reply}
+	<-reply
+	return c, clock, events
+}
+
+// checkDisabled fails t unless frame shows the element disabled with the target left at target.
+func checkDisabled(t *testing.T, what string, c *Coil, frame CoilFrame, target float64) {
+	t.Helper()
+	if !frame.Disabled || frame.FireTime != 0 {
+		t.Errorf("%s: frame disabled %v fire time %dms, want the element disabled", what, frame.Disabled, frame.FireTime)
+	}
+	if frame.Target != target || c.Target() != target {
+		t.Errorf("%s: target %v in the frame and %v on the coil, want both left at %v", what, frame.Target, c.Target(), target)
+	}
+}
+
+func TestBakeDisablesTheElementWhenDone(t *testing.T) {
+	c, clock, events := bakeAt(t, 300, 2*time.Second)
+
+	var frame CoilFrame
+	for i := 0; i < 4 && !frame.Disabled; i++ {
+		frame = step(t, c, clock)
+	}
+	checkDisabled(t, "bake done", c, frame, 300)
+	for {
+		select {
+		case event := <-events:
+			if event.Type == EventTarget && event.Target == 0 {
+				t.Errorf("bake ending set the target to 0")
+			}
+			if event.Type == EventDone {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no done event once the bake's hold ran out")
+		}
+	}
+}
+
+func TestCancelBakeDisablesTheElement(t *testing.T) {
+	c, clock, _ := bakeAt(t, 300, time.Hour)
+	step(t, c, clock)
+
+	c.CancelBake <- struct{}{}
+	checkDisabled(t, "bake cancelled", c, step(t, c, clock), 300)
+}
@@ -0,0 +1,18 @@
+package coil
+
+// CalibrationConfig says how a thermocouple's raw reading maps to a temperature.
+type CalibrationConfig struct {
+	// Resolution is degrees Celsius per raw count.
+	Resolution float64
+	// Offset is added to every converted reading, in InternalUnit, for a sensor that reads consistently off.
+	Offset float64
+}
+
+// DefaultCalibration is for amplifiers like the MAX6675, which count in quarter degrees Celsius.
+var DefaultCalibration = CalibrationConfig{Resolution: 0.25}
+
+// ConvertRaw converts a raw sensor reading to InternalUnit. Raw readings are counts of cfg.Resolution degrees Celsius,
+// so with DefaultCalibration a raw reading of 0 is 32°F, 100 is 77°F, 400 is 212°F and -160 is -40°F.
+func ConvertRaw(raw float64, cfg CalibrationConfig) float64 {
+	return Celsius.ToInternal(raw*cfg.Resolution) + cfg.Offset
+}
@@ -0,0 +1,29 @@
+package coil
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConvertRaw(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  float64
+		cfg  CalibrationConfig
+		want float64
+	}{
+		{"freezing", 0, DefaultCalibration, 32},
+		{"room", 100, DefaultCalibration, 77},
+		{"boiling", 400, DefaultCalibration, 212},
+		{"minus forty", -160, DefaultCalibration, -40},
+		{"fractional count", 1, DefaultCalibration, 32.45},
+		{"finer resolution", 1600, CalibrationConfig{Resolution: 0.0625}, 212},
+		{"offset", 400, CalibrationConfig{Resolution: 0.25, Offset: -2.5}, 209.5},
+		{"offset at zero", 0, CalibrationConfig{Resolution: 0.25, Offset: 1}, 33},
+		{"whole degrees", 1000, CalibrationConfig{Resolution: 1}, 1832},
+	} {
+		if got := ConvertRaw(tc.raw, tc.cfg); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: ConvertRaw(%v, %+v) = %v, want %v", tc.name, tc.raw, tc.cfg, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package coil
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for the coil's run loop.
+// Swapping in a MockClock makes the loop deterministic.
+type Clock interface {
+	Now() time.Time
+	Tick(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+}
+
+// Ticker delivers ticks on C() until Stop is called.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// RealClock is a Clock backed by the time package.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) Tick(d time.Duration) Ticker {
+	return &realTicker{t: time.NewTicker(d)}
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t *realTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t *realTicker) Stop() {
+	t.t.Stop()
+}
+
+// MockClock is a Clock whose time only moves forward when Advance is called.
+type MockClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*mockTimer
+}
+
+type mockTimer struct {
+	clock  *MockClock
+	when   time.Time
+	period time.Duration // zero for one-shot timers
+	c      chan time.Time
+}
+
+func NewMockClock(now time.Time) *MockClock {
+	return &MockClock{now: now}
+}
+
+func (m *MockClock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+func (m *MockClock) Tick(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for MockClock.Tick")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := &mockTimer{clock: m, when: m.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	m.timers = append(m.timers, t)
+	return t
+}
+
+func (m *MockClock) After(d time.Duration) <-chan time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := &mockTimer{clock: m, when: m.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- m.now
+		return t.c
+	}
+	m.timers = append(m.timers, t)
+	return t.c
+}
+
+// Advance moves the clock forward by d, firing every timer that comes due in chronological order.
+// Like the time package, a tick is dropped if the previous one has not been received yet.
+func (m *MockClock) Advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	target := m.now.Add(d)
+	for {
+		next := -1
+		for i, t := range m.timers {
+			if t.when.After(target) {
+				continue
+			}
+			if next < 0 || t.when.Before(m.timers[next].when) {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+		t := m.timers[next]
+		m.now = t.when
+		select {
+		case t.c <- t.when:
+		default:
+		}
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+		} else {
+			m.remove(t)
+		}
+	}
+	m.now = target
+}
+
+func (m *MockClock) remove(t *mockTimer) {
+	for i, tt := range m.timers {
+		if tt == t {
+			m.timers = append(m.timers[:i], m.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *mockTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *mockTimer) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.clock.remove(t)
+}
@@ -0,0 +1,94 @@
+package coil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMockClockFiresTimersInOrder(t *testing.T) {
+	clock := NewMockClock(testEpoch)
+	ticker := clock.Tick(100 * time.Millisecond)
+	after := clock.After(250 * time.Millisecond)
+
+	expect := func(what string, c <-chan time.Time, want time.Duration) {
+		t.Helper()
+		select {
+		case got := <-c:
+			if got.Sub(testEpoch) != want {
+				t.Errorf("%s fired at %s, want %s", what, got.Sub(testEpoch), want)
+			}
+		default:
+			t.Errorf("%s didn't fire by %s", what, clock.Now().Sub(testEpoch))
+		}
+	}
+	expectNone := func(what string, c <-chan time.Time) {
+		t.Helper()
+		select {
+		case got := <-c:
+			t.Errorf("%s fired at %s, before %s", what, got.Sub(testEpoch), clock.Now().Sub(testEpoch))
+		default:
+		}
+	}
+
+	clock.Advance(99 * time.Millisecond)
+	expectNone("ticker", ticker.C())
+	clock.Advance(time.Millisecond)
+	expect("ticker", ticker.C(), 100*time.Millisecond)
+
+	// The tick at 200ms is dropped since 300ms's can't be delivered on top of it
+	clock.Advance(200 * time.Millisecond)
+	expect("ticker", ticker.C(), 200*time.Millisecond)
+	expect("after", after, 250*time.Millisecond)
+	if now := clock.Now().Sub(testEpoch); now != 300*time.Millisecond {
+		t.Errorf("now = %s after advancing 300ms, want 300ms", now)
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Second)
+	expectNone("stopped ticker", ticker.C())
+	expect("After(0)", clock.After(0), 1300*time.Millisecond)
+}
+
+func TestPulseStartsAndEndsOnTheTick(t *testing.T) {
+	for _, tc := range []struct {
+		target float64
+		fire   time.Duration
+	}{
+		{400, 300 * time.Millisecond},
+		// The longest pulse, leaving only the blip off at the end of the window
+		{2000, (1000 - maxWiggle) * time.Millisecond},
+	} {
+		t.Run(tc.fire.String(), func(t *testing.T) {
+			// Proportional only, so with readings of 100 the coil fires for target-100 milliseconds up to the max
+			c, clock := newTestCoil(t, map[string]string{"PI_HEATER_PID_P": "1", "PI_HEATER_PID_I": "0", "PI_HEATER_PID_D": "0"})
+			c.temp = newScriptedReader(100)
+			status := &recordingStatus{}
+			c.status = status
+			startCoil(t, c, clock)
+			c.SetTarget <- TargetCommand{Target: tc.target, Source: "test"}
+
+			clock.Advance(c.window - time.Millisecond)
+			for window := 1; window <= 2; window++ {
+				if status.on() {
+					t.Fatalf("coil on 1ms before window %d", window)
+				}
+				clock.Advance(time.Millisecond)
+				if frame := nextFrame(t, c); frame.FireTime != tc.fire.Milliseconds() {
+					t.Fatalf("window %d fired for %dms", window, frame.FireTime)
+				}
+				if !status.on() {
+					t.Fatalf("coil off at the start of window %d", window)
+				}
+
+				// Pending are the ticker and the off timer
+				clock.Advance(tc.fire - time.Millisecond)
+				if !status.on() || clock.pending() != 2 {
+					t.Fatalf("coil on %v with %d timers pending 1ms before the pulse ends", status.on(), clock.pending())
+				}
+				clock.Advance(time.Millisecond)
+				waitFor(t, "the pulse to end", func() bool { return !status.on() })
+				clock.Advance(c.window - tc.fire - time.Millisecond)
+			}
+		})
+	}
+}
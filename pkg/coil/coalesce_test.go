@@ -0,0 +1,98 @@
+package coil
+
+import (
+	"testing"
+	"time"
+)
+
+// coalesceEnv makes the controller proportional only, so with readings of 100 it fires for target-100 milliseconds.
+var coalesceEnv = map[string]string{
+	"PI_HEATER_PID_P":        "1",
+	"PI_HEATER_PID_I":        "0",
+	"PI_HEATER_PID_D":        "0",
+	"PI_HEATER_COALESCE_GAP": "200ms",
+	"PI_HEATER_DEBUG":        "1",
+}
+
+func TestCoalescedWindowsCountAsFullyOn(t *testing.T) {
+	c, clock := newTestCoil(t, coalesceEnv)
+	c.temp = newScriptedReader(100)
+	c.status = &recordingStatus{}
+	startCoil(t, c, clock)
+	c.SetTarget <- TargetCommand{Target: 1000, Source: "test"}
+
+	// 900ms leaves the coil off for 100ms, within the gap, so it's held on for the whole window
+	frame := step(t, c, clock)
+	if frame.FireTime != 900 {
+		t.Fatalf("fired for %dms, want 900ms", frame.FireTime)
+	}
+	if m := c.Metrics(); m.TotalFireTime != time.Second || m.DutyCycle != 100 {
+		t.Errorf("counted %s at %v%% duty for a window held on, want 1s at 100%%", m.TotalFireTime, m.DutyCycle)
+	}
+	if frame.Debug.RollingDuty != 100 {
+		t.Errorf("rolling duty = %v%% for a window held on, want 100%%", frame.Debug.RollingDuty)
+	}
+}
+
+func TestCoalescedWindowsTripMaxContinuousOn(t *testing.T) {
+	env := map[string]string{"PI_HEATER_MAX_CONTINUOUS_ON": "3s"}
+	for k, v := range coalesceEnv {
+		env[k] = v
+	}
+	c, clock := newTestCoil(t, env)
+	c.temp = newScriptedReader(100)
+	status := &recordingStatus{}
+	c.status = status
+	startCoil(t, c, clock)
+	c.SetTarget <- TargetCommand{Target: 1000, Source: "test"}
+
+	// Never the longest pulse, but held on from each window to the next
+	for i, want := range []int64{900, 1900, 2900} {
+		if frame := step(t, c, clock); frame.FireTime != 900 || frame.ContinuousOn != want {
+			t.Fatalf("window %d fired for %dms and on for %dms, want 900ms and %dms", i+1, frame.FireTime, frame.ContinuousOn, want)
+		}
+	}
+	if frame := step(t, c, clock); frame.FireTime != 0 {
+		t.Fatalf("fired for %dms after 3.9s held on, want an off window past PI_HEATER_MAX_CONTINUOUS_ON", frame.FireTime)
+	}
+	if status.on() {
+		t.Errorf("coil still on in the forced off window")
+	}
+}
+
+func TestShortWindowCutsCoalescedPulse(t *testing.T) {
+	c, clock := newTestCoil(t, coalesceEnv)
+	c.temp = newScriptedReader(100)
+	status := &recordingStatus{}
+	c.status = status
+	startCoil(t, c, clock)
+	c.SetTarget <- TargetCommand{Target: 1000, Source: "test"}
+	step(t, c, clock)
+	clock.Advance(950 * time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if !status.on() {
+		t.Fatalf("coil off 950ms into a window held on")
+	}
+
+	c.SetTarget <- TargetCommand{Target: 400, Source: "test"}
+	clock.Advance(50 * time.Millisecond)
+	if frame := nextFrame(t, c); frame.FireTime != 300 || !frame.Debug.Coalesced {
+		t.Fatalf("fired for %dms coalesced %v, want 300ms carried on from the held window", frame.FireTime, frame.Debug.Coalesced)
+	}
+	clock.Advance(299 * time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if !status.on() {
+		t.Errorf("coil off 299ms into a 300ms pulse")
+	}
+	clock.Advance(time.Millisecond)
+	waitFor(t, "the held pulse to end at 300ms", func() bool { return !status.on() })
+}
+
+func TestNewCoilRejectsWindowWithinCoalesceGap(t *testing.T) {
+	for _, gap := range []string{"1s", "2s"} {
+		setEnv(t, map[string]string{"PI_HEATER_COALESCE_GAP": gap})
+		if _, err := NewCoil(nil, nil); err == nil {
+			t.Errorf("NewCoil accepted a 1000ms PI_HEATER_PID_MAX with PI_HEATER_COALESCE_GAP=%s", gap)
+		}
+	}
+}
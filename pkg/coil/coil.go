@@ -2,115 +2,749 @@ package coil
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"math"
 	"os"
 	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-	"unicode"
 
-	"github.com/felixge/pidctrl"
+	"github.com/raphaelreyna/pi-heater/pkg/coil/pid"
 )
 
+// maxWiggle makes the fire time clamp a bit smaller than the window, leaving a blip off at the end of every window
+// so a pulse never runs into the next one.
+const maxWiggle = 15 // milliseconds
+
+// FaultEStop is the fault latched by a value on EStop.
+const FaultEStop = "emergency stop"
+
 var (
-	ErrLostConn error   = errors.New("lost connection to thermocouple")
+	ErrLostConn error = errors.New("lost connection to thermocouple")
+	// ErrBadRead is returned by a TempReader whose device gave it nothing numeric to parse, e.g. a blank line.
+	// Once it has had a good reading, the run loop tolerates a few in a row before faulting; see PI_HEATER_MAX_BAD_READS.
+	ErrBadRead  error   = errors.New("thermocouple returned a blank or non-numeric reading")
 	MaxTempDiff float64 = 100.0
 )
 
 type CoilFrame struct {
-	Temp          float64
-	Target        float64
-	FrameStart    time.Time
-	FrameDuration int64 // milliseconds
-	FireTime      int64 // milliseconds
+	// Seq goes up by one with every window, so a gap means frames were dropped on the way to the client.
+	// The Terminated frame repeats the last frame's Seq.
+	Seq uint64 `json:"seq,omitempty"`
+	// Timestamp is the server's wall clock time when the frame was built; FrameStart follows the coil's clock,
+	// which only matches it when not replaying.
+	Timestamp     time.Time `json:"timestamp"`
+	Temp          float64   `json:"temp"`
+	Target        float64   `json:"target"`
+	FrameStart    time.Time `json:"frame_start"`
+	FrameDuration int64     `json:"frame_duration"` // milliseconds
+	FireTime      int64     `json:"fire_time"`      // milliseconds
+	// Terminated is only set on the final frame sent when the server shuts down cleanly.
+	Terminated bool `json:"terminated,omitempty"`
+	// Heartbeat frames only say the stream is alive; they carry nothing but Seq, which repeats the last frame's,
+	// and Timestamp.
+	Heartbeat bool `json:"heartbeat,omitempty"`
+	// TimeToTarget estimates how long until the target is reached from the recent heating rate.
+	TimeToTarget *float64 `json:"time_to_target,omitempty"` // seconds
+	// AtTemperature is set once the temperature has held within the tolerance band around the target for the dwell time.
+	AtTemperature bool `json:"at_temperature,omitempty"`
+	// TimeInBand is how long the temperature has held within the tolerance band; it restarts when it leaves the band.
+	TimeInBand int64 `json:"time_in_band,omitempty"` // milliseconds
+	// ContinuousOn is how long the coil will have been on without a break by the end of this window's pulse.
+	ContinuousOn int64 `json:"continuous_on,omitempty"` // milliseconds
+	// BakeRemaining is how long is left of a bake's hold; the hold only starts counting down once within tolerance.
+	BakeRemaining int64 `json:"bake_remaining,omitempty"` // milliseconds
+	// MaxOvershoot is how far the temperature peaked above the target, once it settled; see trackOvershoot.
+	// It is unset until then, and again after the next target.
+	MaxOvershoot *float64 `json:"max_overshoot,omitempty"`
+	// Autotune is set while an autotune runs, and after it ends until the next target.
+	Autotune *AutotuneStatus `json:"autotune,omitempty"`
+	// IdleRemaining is how long until the idle timeout drops the target to its safe value.
+	IdleRemaining int64 `json:"idle_remaining,omitempty"` // milliseconds
+	// Phase is one of the Phase constants, e.g. ramping or holding.
+	Phase string `json:"phase,omitempty"`
+	// Disabled is set while the element is forced off; see TargetCommand.Off.
+	Disabled bool `json:"disabled,omitempty"`
+	// MonitorOnly is set when PI_HEATER_MONITOR_ONLY is: FireTime is what the coil would have fired for.
+	MonitorOnly bool `json:"monitor_only,omitempty"`
+	// SinceLastRead is how long before the frame was built the last good reading was taken; it grows across bad reads.
+	SinceLastRead float64 `json:"seconds_since_last_read,omitempty"` // seconds
+	// Fault is why the coil stopped firing; it stays set until cleared.
+	Fault string `json:"fault,omitempty"`
+	// PIDReset marks the first frame after the controller's integral was reset.
+	PIDReset bool `json:"pid_reset,omitempty"`
+	// Sensors holds each thermocouple's reading when there are two; Temp combines them.
+	Sensors []float64 `json:"sensors,omitempty"`
+	// ColdJunction and SensorFault are only reported by sources that support them, e.g. the MAX31855.
+	ColdJunction *float64 `json:"cold_junction,omitempty"`
+	SensorFault  string   `json:"sensor_fault,omitempty"`
+	// Debug is only populated when PI_HEATER_DEBUG is set.
+	Debug *FrameDebug `json:"debug,omitempty"`
+}
+
+// FrameDebug holds diagnostics about the run loop that are too noisy for regular frames.
+type FrameDebug struct {
+	DroppedFrames uint64    `json:"dropped_frames"` // frames replaced before the hub read them
+	PIDOutput     float64   `json:"pid_output"`     // milliseconds
+	Terms         pid.Terms `json:"terms"`
+	Feedforward   float64   `json:"feedforward"`    // milliseconds
+	RawFireTime   float64   `json:"raw_fire_time"`  // milliseconds, before slew limiting
+	Coalesced     bool      `json:"coalesced"`      // the coil stayed on from the previous window instead of blipping off
+	TickInterval  float64   `json:"tick_interval"`  // milliseconds since the previous window started
+	ReadLatency   float64   `json:"read_latency"`   // milliseconds spent reading the thermocouple
+	SoftStartCap  float64   `json:"soft_start_cap"` // milliseconds; zero outside of a soft start
+	VoltageScale  float64   `json:"voltage_scale"`  // factor the controller's output was scaled by for the supply voltage
+	RollingDuty   float64   `json:"rolling_duty"`   // percent, averaged over PI_HEATER_MAX_DUTY_WINDOW up to and including this window
+	DutyLimited   bool      `json:"duty_limited"`   // the fire time was shortened to keep RollingDuty within PI_HEATER_MAX_DUTY
 }
 
 type Coil struct {
-	// Used to interface with device files
-	tempf *os.File
-	statf *os.File
-	tempb []byte
-	statb []byte
+	// Used to interface with the thermocouple and heating element
+	temp   TempReader
+	status StatusWriter
+	sim    *Simulator
+
+	coldJunction *float64
+	sensorFault  SensorFault
+
+	// An optional second thermocouple; the loop controls on the strategy's combination of both
+	temp2       TempReader
+	strategy    string
+	disagreeMax float64
+	sensors     []float64
+
+	// Latched by latchFault, cleared by a value on Clear
+	fault             string
+	writeFailureCount uint64 // accessed atomically
+	ready             uint32 // accessed atomically; set once a frame with a valid reading has been published
+	counters          counters
+	// target is the user requested target as math.Float64bits, accessed atomically; the PID setpoint can differ, e.g. once idle
+	target          uint64
+	writeFailures   []time.Time
+	breakerFailures int
+	breakerWindow   time.Duration
 
 	window time.Duration
 
-	pid           *pidctrl.PIDController
-	errLog        *log.Logger
-	infoLog       *log.Logger
-	nonInitialRun bool
+	// controlMode picks between the PID controller and bang-bang control within hysteresis of the target
+	controlMode string
+	hysteresis  float64
+	heating     bool
+	derivMode   string
+
+	pid     pid.Controller
+	maxFire float64 // milliseconds
+	ffGain  float64 // milliseconds of fire time per degree between target and ambient
+	ambient float64
+	maxSlew float64 // milliseconds per window; zero disables slew limiting
+
+	// Keep the duty cycle averaged over dutyWindow within maxDuty percent; dutyFires are the latest windows' fire times
+	maxDuty    float64
+	dutyWindow time.Duration
+	dutyFires  []time.Duration
+
+	// With a nominalVoltage, the output is scaled so the coil delivers the power it would at nominal when the
+	// supply is at supplyVoltage instead
+	nominalVoltage float64
+	supplyVoltage  float64
+
+	// A target at least softStartStep above the setpoint caps the fire time over the next softStartWindows windows
+	softStartWindows int
+	softStartStep    float64
+	softStartLeft    int
+
+	// Windows that would leave the coil off for no longer than coalesce at their end keep it on into the next window
+	coalesce time.Duration
+	heldOn   bool
+
+	// Force an off window once the coil has been on for maxContinuousOn across back to back full windows
+	maxContinuousOn time.Duration
+	onStreak        time.Duration
+	continuousOn    time.Duration
+	dbg             FrameDebug
+	lastPIDUpdate   time.Time
+	errLog          *log.Logger
+	infoLog         *log.Logger
+	// The spike detector arms once spikeWarmup readings have come in, letting a cold boot's readings settle
+	spikeWarmup int
+	warmReads   int
+	// Up to maxBadReads ErrBadRead readings in a row keep the last temperature; the next one faults
+	maxBadReads int
+	badReads    int
+	debug       bool
+
+	// Drop to idleTarget once idleTimeout passes without a new target; disabled when idleTimeout is zero.
+	idleTimeout  time.Duration
+	idleTarget   float64
+	lastTargetAt time.Time
+	idled        bool
+
+	// Fault if a target increase doesn't raise the temperature by minRise within riseTimeout while firing at least
+	// riseMinDuty percent of the time; riseStart is zero while the guard is disarmed
+	riseTimeout time.Duration
+	minRise     float64
+	riseMinDuty float64
+	riseStart   time.Time
+	riseFrom    float64
+	riseFired   time.Duration
+
+	// Drop to deadmanTarget once deadmanTimeout passes without a Deadman target command; deadmanAt is zero until one
+	// arms it
+	deadmanTimeout time.Duration
+	deadmanTarget  float64
+	deadmanAt      time.Time
+
+	// The bake in progress, if any
+	bake *bake
+
+	// The autotune in progress, if any, and the status frames report until the next target
+	tune            *autotune
+	tuneStatus      *AutotuneStatus
+	autotuneTimeout time.Duration
+
+	// The phase reported in the last frame; see updatePhase
+	phase string
+	// disabled forces the element off and skips the controller; see disable
+	disabled bool
+	// calibration converts the thermocouples' raw readings
+	calibration CalibrationConfig
+	// done is closed once Run returns
+	done chan struct{}
+	// monitorOnly never turns the coil on; see monitorStatus
+	monitorOnly bool
+
+	pidReset bool
+
+	// Ramp the target down to ambient over shutdownRamp once a value is received on Shutdown
+	shutdownRamp time.Duration
+	rampStart    time.Time
+	rampFrom     float64
+
+	events events
+
+	// staleAfter is how old the last good reading may get before Stale; 0 means staleWindows control windows.
+	staleAfter time.Duration
+
+	// The coil is at temperature once it holds within tolerance of the target for dwell
+	tolerance   float64
+	dwell       time.Duration
+	inBandSince time.Time
+	timeInBand  time.Duration
+	reached     bool
+
+	// The peak temperature since first coming within tolerance of the target, and the overshoot measured from it
+	peakArmed bool
+	peak      float64
+	overshoot *float64
+
+	// How clients should render temperatures; only reported, never used by the coil itself
+	display Display
+
+	// current holds the latest published CoilFrame; see CurrentFrame
+	current atomic.Value
+
+	// publishMu orders publish calls, which run on their own goroutines, by Seq; publishedSeq is the last one published
+	publishMu    sync.Mutex
+	publishedSeq uint64
+
+	// config is refreshed by the run loop whenever it changes so Config can be read from any goroutine
+	configMu sync.Mutex
+	config   Config
+
+	// jitter is how far each measured window strays from the configured window, in seconds
+	lastTick time.Time
+	jitter   *Histogram
+
+	// Relative target adjustments are clamped to [minTarget, maxTarget]; maxTarget is unlimited when zero.
+	minTarget float64
+	maxTarget float64
+	// Readings outside [tempFloor, tempCeiling] latch a fault instead of reaching the controller; see checkTempLimits.
+	tempFloor, tempCeiling float64
 
 	WaitGroup *sync.WaitGroup
+	// Clock drives the run loop's timing; defaults to RealClock.
+	Clock Clock
+	// Scheduler runs the loop's background work, e.g. publishing frames; without one each job gets its own goroutine.
+	Scheduler *Scheduler
 
-	Running          bool
-	Stop             chan struct{}
-	SetTarget        chan float64
+	Running bool
+	// Stop turns the coil off and ends the run loop straight away.
+	// Shutdown does the same after ramping the target down to ambient over PI_HEATER_SHUTDOWN_RAMP, if it is set;
+	// a second value on Shutdown or one on Stop cuts it short.
+	Stop         chan struct{}
+	Shutdown     chan struct{}
+	SetTarget    chan TargetCommand
+	Bake         chan BakeRequest
+	CancelBake   chan struct{}
+	Autotune     chan AutotuneRequest
+	StopAutotune chan struct{}
+	ApplyPreset  chan PresetRequest
+	// Enable turns the element back on after TargetCommand.Off, heating to the target it kept; the value names
+	// the sender, as with TargetCommand.Source.
+	Enable chan string
+	// SetWindow changes the control window while running; see ValidateWindow.
+	SetWindow chan WindowRequest
+	// ExportState replies with the coil's State on the channel it is sent, which must be buffered.
+	ExportState  chan chan State
+	RestoreState chan StateRequest
+	ResetPID     chan struct{}
+	Clear        chan struct{}
+	// SetVoltage takes the measured supply voltage when PI_HEATER_NOMINAL_VOLTAGE is set; see ValidateVoltage.
+	SetVoltage chan float64
+	// EStop turns the coil off at once and latches FaultEStop, replacing any other fault, until a value on Clear.
+	EStop            chan struct{}
 	Temp             float64
 	LastUpdated      time.Time
 	Firing           bool
 	FireTime         time.Duration
-	CurrentFrameChan chan CoilFrame
-	CurrentFrame     CoilFrame
+	CurrentFrameChan *FrameChan
+	History          *History
 }
 
+// NewCoil configures a coil from the PI_HEATER_* environment variables; nil loggers discard their output.
 func NewCoil(errLog, infoLog *log.Logger) (*Coil, error) {
 	var err error
+	if errLog == nil {
+		errLog = log.New(ioutil.Discard, "", 0)
+	}
+	if infoLog == nil {
+		infoLog = log.New(ioutil.Discard, "", 0)
+	}
 	c := &Coil{
-		tempb:            make([]byte, 6),
-		statb:            make([]byte, 3),
 		errLog:           errLog,
 		infoLog:          infoLog,
 		Stop:             make(chan struct{}),
-		SetTarget:        make(chan float64),
-		CurrentFrameChan: make(chan CoilFrame),
+		Shutdown:         make(chan struct{}),
+		SetTarget:        make(chan TargetCommand),
+		ApplyPreset:      make(chan PresetRequest),
+		Enable:           make(chan string),
+		SetWindow:        make(chan WindowRequest),
+		ExportState:      make(chan chan State),
+		SetVoltage:       make(chan float64),
+		RestoreState:     make(chan StateRequest),
+		ResetPID:         make(chan struct{}),
+		Clear:            make(chan struct{}),
+		EStop:            make(chan struct{}),
+		Bake:             make(chan BakeRequest),
+		CancelBake:       make(chan struct{}),
+		Autotune:         make(chan AutotuneRequest),
+		StopAutotune:     make(chan struct{}),
+		CurrentFrameChan: NewFrameChan(),
+		calibration:      DefaultCalibration,
+		done:             make(chan struct{}),
+		Clock:            RealClock{},
+		jitter:           NewHistogram(0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1),
+	}
+	c.resetOvershoot()
+
+	c.controlMode = os.Getenv("PI_HEATER_CONTROL_MODE")
+	if c.controlMode == "" {
+		c.controlMode = ControlPID
+	}
+	if c.controlMode != ControlPID && c.controlMode != ControlBangBang {
+		return nil, errors.New("unknown PI_HEATER_CONTROL_MODE: " + c.controlMode)
+	}
+	c.hysteresis, err = envFloat("PI_HEATER_HYSTERESIS", 5)
+	if err != nil {
+		return nil, err
+	}
+	if c.hysteresis < 0 {
+		return nil, errors.New("PI_HEATER_HYSTERESIS must not be negative")
 	}
 
 	// Grab PID parameters: P, I, D, MAX
-	s := os.Getenv("PI_HEATER_PID_P")
-	p, err := strconv.ParseFloat(s, 64)
+	p, err := controlGain(c.controlMode, "PI_HEATER_PID_P")
 	if err != nil {
-		return nil, errors.New("error while parsing PI_HEATER_PID_P: " + err.Error())
+		return nil, err
 	}
-	s = os.Getenv("PI_HEATER_PID_I")
-	i, err := strconv.ParseFloat(s, 64)
+	i, err := controlGain(c.controlMode, "PI_HEATER_PID_I")
 	if err != nil {
-		return nil, errors.New("error while parsing PI_HEATER_PID_I: " + err.Error())
+		return nil, err
 	}
-	s = os.Getenv("PI_HEATER_PID_D")
-	d, err := strconv.ParseFloat(s, 64)
+	d, err := controlGain(c.controlMode, "PI_HEATER_PID_D")
 	if err != nil {
-		return nil, errors.New("error while parsing PI_HEATER_PID_D: " + err.Error())
+		return nil, err
+	}
+	// Read before PI_HEATER_PID_MAX, which must leave room for it
+	c.coalesce, err = envDuration("PI_HEATER_COALESCE_GAP", 0)
+	if err != nil {
+		return nil, err
 	}
-	s = os.Getenv("PI_HEATER_PID_MAX")
+	s := os.Getenv("PI_HEATER_PID_MAX")
 	max, err := strconv.ParseInt(s, 10, 64)
 	if err != nil {
 		return nil, errors.New("error while parsing PI_HEATER_PID_MAX: " + err.Error())
 	}
-	// Make the window clamp a bit smaller to give some wiggle room and avoid writing to dev file from different goroutines.
-	adjustedMax := float64(max - 15)
-	c.pid = pidctrl.NewPIDController(p, i, d).SetOutputLimits(0, adjustedMax)
-	infoLog.Printf("P.I.D. controller: p=%.3f i=%.3f d=%.3f adjusted_max=%.0f milliseconds\n",
-		p, i, d, adjustedMax,
-	)
+	if err := c.ValidateWindow(max); err != nil {
+		return nil, errors.New("invalid PI_HEATER_PID_MAX: " + err.Error())
+	}
+	adjustedMax := float64(max - maxWiggle)
+	c.derivMode = os.Getenv("PI_HEATER_PID_DERIV_MODE")
+	if c.derivMode == "" {
+		c.derivMode = "measurement"
+	}
+	c.pid, err = pid.NewWithMode(c.derivMode, p, i, d, 0, adjustedMax)
+	if err != nil {
+		return nil, err
+	}
+	if c.controlMode == ControlBangBang {
+		infoLog.Printf("bang-bang control: hysteresis=%.2f adjusted_max=%.0f milliseconds\n", c.hysteresis, adjustedMax)
+	} else {
+		infoLog.Printf("P.I.D. controller: p=%.3f i=%.3f d=%.3f adjusted_max=%.0f milliseconds derivative_mode=%s\n",
+			p, i, d, adjustedMax, c.derivMode,
+		)
+	}
+
+	c.ffGain, err = envFloat("PI_HEATER_FEEDFORWARD_GAIN", 0)
+	if err != nil {
+		return nil, err
+	}
+	c.ambient, err = envFloat("PI_HEATER_AMBIENT_TEMP", 70)
+	if err != nil {
+		return nil, err
+	}
+
+	c.maxSlew, err = envFloat("PI_HEATER_MAX_FIRE_SLEW_MS_PER_WINDOW", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	c.breakerFailures, err = envInt("PI_HEATER_BREAKER_FAILURES", 3)
+	if err != nil {
+		return nil, err
+	}
+	c.breakerWindow, err = envDuration("PI_HEATER_BREAKER_WINDOW", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	c.shutdownRamp, err = envDuration("PI_HEATER_SHUTDOWN_RAMP", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	c.maxContinuousOn, err = envDuration("PI_HEATER_MAX_CONTINUOUS_ON", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	c.maxDuty, err = envFloat("PI_HEATER_MAX_DUTY", 100)
+	if err != nil {
+		return nil, err
+	}
+	if c.maxDuty <= 0 || c.maxDuty > 100 {
+		return nil, errors.New("PI_HEATER_MAX_DUTY must be more than 0 and at most 100")
+	}
+	c.dutyWindow, err = envDuration("PI_HEATER_MAX_DUTY_WINDOW", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	c.nominalVoltage, err = envFloat("PI_HEATER_NOMINAL_VOLTAGE", 0)
+	if err != nil {
+		return nil, err
+	}
+	if c.nominalVoltage < 0 {
+		return nil, errors.New("PI_HEATER_NOMINAL_VOLTAGE must not be negative")
+	}
+	c.supplyVoltage, err = envFloat("PI_HEATER_SUPPLY_VOLTAGE", c.nominalVoltage)
+	if err != nil {
+		return nil, err
+	}
+	if c.nominalVoltage > 0 {
+		if err = c.ValidateVoltage(c.supplyVoltage); err != nil {
+			return nil, errors.New("invalid PI_HEATER_SUPPLY_VOLTAGE: " + err.Error())
+		}
+	}
+
+	c.softStartWindows, err = envInt("PI_HEATER_SOFTSTART_WINDOWS", 0)
+	if err != nil {
+		return nil, err
+	}
+	if c.softStartWindows < 0 {
+		return nil, errors.New("PI_HEATER_SOFTSTART_WINDOWS must not be negative")
+	}
+	c.softStartStep, err = envFloat("PI_HEATER_SOFTSTART_STEP", 50)
+	if err != nil {
+		return nil, err
+	}
+
+	c.spikeWarmup, err = envInt("PI_HEATER_SPIKE_WARMUP", 1)
+	if err != nil {
+		return nil, err
+	}
+	if c.spikeWarmup < 1 {
+		return nil, errors.New("PI_HEATER_SPIKE_WARMUP must be at least 1")
+	}
+
+	c.autotuneTimeout, err = envDuration("PI_HEATER_AUTOTUNE_TIMEOUT", 2*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	c.maxBadReads, err = envInt("PI_HEATER_MAX_BAD_READS", 3)
+	if err != nil {
+		return nil, err
+	}
+
+	historySize, err := envInt("PI_HEATER_HISTORY_SIZE", 3600)
+	if err != nil {
+		return nil, err
+	}
+	c.History = NewHistory(historySize)
+
+	c.debug, err = envBool("PI_HEATER_DEBUG")
+	if err != nil {
+		return nil, err
+	}
+
+	c.idleTimeout, err = envDuration("PI_HEATER_IDLE_TIMEOUT", 0)
+	if err != nil {
+		return nil, err
+	}
+	c.idleTarget, err = envFloat("PI_HEATER_IDLE_TARGET", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	c.riseTimeout, err = envDuration("PI_HEATER_RISE_TIMEOUT", 0)
+	if err != nil {
+		return nil, err
+	}
+	c.minRise, err = envFloat("PI_HEATER_MIN_RISE", 10)
+	if err != nil {
+		return nil, err
+	}
+	c.riseMinDuty, err = envFloat("PI_HEATER_RISE_MIN_DUTY", 50)
+	if err != nil {
+		return nil, err
+	}
+	if c.riseTimeout > 0 && c.minRise <= 0 {
+		return nil, errors.New("PI_HEATER_MIN_RISE must be more than 0")
+	}
+
+	c.deadmanTimeout, err = envDuration("PI_HEATER_DEADMAN_TIMEOUT", 0)
+	if err != nil {
+		return nil, err
+	}
+	c.deadmanTarget, err = envFloat("PI_HEATER_DEADMAN_TARGET", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	c.minTarget, err = envFloat("PI_HEATER_MIN_TARGET", 0)
+	if err != nil {
+		return nil, err
+	}
+	c.maxTarget, err = envFloat("PI_HEATER_MAX_TARGET", 0)
+	if err != nil {
+		return nil, err
+	}
+	if c.maxTarget != 0 && c.maxTarget < c.minTarget {
+		return nil, errors.New("PI_HEATER_MAX_TARGET must not be less than PI_HEATER_MIN_TARGET")
+	}
+	if c.maxTarget != 0 && c.idleTarget > c.maxTarget {
+		return nil, errors.New("PI_HEATER_IDLE_TARGET must not be more than PI_HEATER_MAX_TARGET")
+	}
+	if c.maxTarget != 0 && c.deadmanTarget > c.maxTarget {
+		return nil, errors.New("PI_HEATER_DEADMAN_TARGET must not be more than PI_HEATER_MAX_TARGET")
+	}
+	if err = c.parseTempLimits(); err != nil {
+		return nil, err
+	}
+
+	decimals, err := envInt("PI_HEATER_DISPLAY_DECIMALS", DefaultDisplay.Decimals)
+	if err != nil {
+		return nil, err
+	}
+	c.display, err = parseDisplay(os.Getenv("PI_HEATER_DISPLAY_UNIT"), decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	c.tolerance, err = envFloat("PI_HEATER_REACHED_TOLERANCE", 5)
+	if err != nil {
+		return nil, err
+	}
+	c.dwell, err = envDuration("PI_HEATER_REACHED_DWELL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	c.staleAfter, err = envDuration("PI_HEATER_STALE_AFTER", 0)
+	if err != nil {
+		return nil, err
+	}
+	if c.staleAfter < 0 {
+		return nil, errors.New("PI_HEATER_STALE_AFTER must not be negative")
+	}
 
-	c.window = time.Duration(max) * time.Millisecond
+	c.changeWindow(max)
+
+	workers, err := envInt("PI_HEATER_TIMER_WORKERS", 0)
+	if err != nil {
+		return nil, err
+	}
+	if workers > 0 {
+		c.Clock = sharedWheel()
+		c.Scheduler = sharedScheduler(workers)
+		infoLog.Printf("driving the coil from the shared timer wheel with %d workers\n", workers)
+	}
+
+	simulate, err := envBool("PI_HEATER_SIMULATE")
+	if err != nil {
+		return nil, err
+	}
+	if c.monitorOnly, err = envBool("PI_HEATER_MONITOR_ONLY"); err != nil {
+		return nil, err
+	}
+	if c.monitorOnly {
+		infoLog.Printf("monitor only: reading the temperature and computing fire times, but never turning the coil on\n")
+	}
+	if simulate {
+		infoLog.Printf("simulating the thermocouple and heating element; no device files will be used\n")
+		c.sim = NewSimulator(c.Clock, c.ambient)
+		if c.sim.HeatRate, err = envFloat("PI_HEATER_SIM_HEAT_RATE", c.sim.HeatRate); err != nil {
+			return nil, err
+		}
+		c.temp = c.sim
+		c.status = c.guardStatus(c.sim)
+		c.refreshConfig()
+		return c, nil
+	}
+
+	if file := os.Getenv("PI_HEATER_REPLAY_FILE"); file != "" {
+		fast, err := envBool("PI_HEATER_REPLAY_FAST")
+		if err != nil {
+			return nil, err
+		}
+		replay, clock, err := newReplay(file, fast, infoLog)
+		if err != nil {
+			return nil, err
+		}
+		c.Clock = clock
+		c.temp = replay
+		c.status = &logStatus{log: infoLog}
+		c.refreshConfig()
+		return c, nil
+	}
+
+	insecure, err := envBool("PI_HEATER_INSECURE_GPIO_CHECK")
+	if err != nil {
+		return nil, err
+	}
+	SkipDeviceCheck = SkipDeviceCheck || insecure
+
+	divisor, err := envFloat("PI_HEATER_TEMP_DIVISOR", 4)
+	if err != nil {
+		return nil, err
+	}
+	if divisor <= 0 {
+		return nil, errors.New("PI_HEATER_TEMP_DIVISOR must be positive")
+	}
+
+	devices, err := newDeviceWait(infoLog)
+	if err != nil {
+		return nil, err
+	}
 	devfile := os.Getenv("PI_HEATER_TEMP_DEV_FILE")
-	c.tempf, err = os.OpenFile(devfile, os.O_RDONLY, os.ModeDevice)
+	if err = devices.wait("PI_HEATER_TEMP_DEV_FILE", devfile, os.O_RDONLY); err != nil {
+		return nil, err
+	}
+	if err = checkDevice("PI_HEATER_TEMP_DEV_FILE", devfile); err != nil {
+		return nil, err
+	}
+	c.temp, err = newTempReader(os.Getenv("PI_HEATER_TEMP_SOURCE"), devfile, divisor)
 	if err != nil {
 		return nil, err
 	}
+	if devfile = os.Getenv("PI_HEATER_TEMP_DEV_FILE_2"); devfile != "" {
+		if err = devices.wait("PI_HEATER_TEMP_DEV_FILE_2", devfile, os.O_RDONLY); err != nil {
+			return nil, err
+		}
+		if err = checkDevice("PI_HEATER_TEMP_DEV_FILE_2", devfile); err != nil {
+			return nil, err
+		}
+		c.temp2, err = newTempReader(os.Getenv("PI_HEATER_TEMP_SOURCE"), devfile, divisor)
+		if err != nil {
+			return nil, err
+		}
+		c.strategy = os.Getenv("PI_HEATER_TC_STRATEGY")
+		switch c.strategy {
+		case "":
+			c.strategy = "average"
+		case "average", "min", "max":
+		default:
+			return nil, errors.New("unknown PI_HEATER_TC_STRATEGY: " + c.strategy)
+		}
+		c.disagreeMax, err = envFloat("PI_HEATER_TC_DISAGREE_MAX", 50)
+		if err != nil {
+			return nil, err
+		}
+		infoLog.Printf("reading two thermocouples, controlling on their %s and faulting if they disagree by more than %.2f degrees\n", c.strategy, c.disagreeMax)
+	}
 
-	devfile = os.Getenv("PI_HEATER_STATUS_DEV_FILE")
-	c.statf, err = os.OpenFile(devfile, os.O_RDWR, os.ModeDevice)
+	var status readableStatus
+	switch backend := os.Getenv("PI_HEATER_STATUS_BACKEND"); backend {
+	case "", "file":
+		devfile = os.Getenv("PI_HEATER_STATUS_DEV_FILE")
+		if err = devices.wait("PI_HEATER_STATUS_DEV_FILE", devfile, os.O_RDWR); err != nil {
+			return nil, err
+		}
+		if err = checkDevice("PI_HEATER_STATUS_DEV_FILE", devfile); err != nil {
+			return nil, err
+		}
+		status, err = newFileStatus(devfile)
+	case "gpio":
+		if err = devices.wait("GPIO registers", "/dev/gpiomem", os.O_RDWR); err != nil {
+			return nil, err
+		}
+		status, err = newGPIOStatus()
+	default:
+		return nil, errors.New("unknown PI_HEATER_STATUS_BACKEND: " + backend)
+	}
 	if err != nil {
 		return nil, err
 	}
+	c.status = c.guardStatus(status)
+	if err = c.reconcileStatus(status); err != nil {
+		return nil, err
+	}
+	c.refreshConfig()
 	return c, nil
 }
 
-func (c *Coil) Run() {
+// Simulator returns the simulated kiln when PI_HEATER_SIMULATE is set, and nil otherwise.
+func (c *Coil) Simulator() *Simulator {
+	return c.sim
+}
+
+// reconcileStatus turns the coil off if the status device reports it on, e.g. after a crash left the relay latched.
+// Devices that can't be read are assumed to be off.
+func (c *Coil) reconcileStatus(s readableStatus) error {
+	on, err := s.Status()
+	if err != nil {
+		c.infoLog.Printf("could not read status device, assuming coil is off: %s\n", err.Error())
+		return nil
+	}
+	if !on {
+		return nil
+	}
+	c.errLog.Printf("status device reports the coil is on at startup; turning it off\n")
+	if err = s.SetStatus(false); err != nil {
+		return errors.New("error while turning off coil left on at startup: " + err.Error())
+	}
+	return nil
+}
+
+// Run is the coil's control loop; it returns once a value is received on Stop.
+// If WaitGroup is set, callers must call WaitGroup.Add(1) before starting Run; Run calls Done when it returns.
+// The error is only non-nil if the coil couldn't be turned off on the way out, so it may still be on.
+func (c *Coil) Run() error {
 	c.infoLog.Printf("starting coil run loop\n")
 	var err error
 	name := os.Args[0]
@@ -120,123 +754,632 @@ func (c *Coil) Run() {
 	if c.infoLog == nil {
 		c.infoLog = log.New(ioutil.Discard, name+" INFO: ", log.LstdFlags|log.Lshortfile)
 	}
-	clock := time.Tick(c.window)
+	if c.Clock == nil {
+		c.Clock = RealClock{}
+	}
+	ticker := c.Clock.Tick(c.window)
+	c.lastTargetAt = c.Clock.Now()
 	c.Running = true
-	c.WaitGroup.Add(1)
 	defer func() {
-		c.statf.Close()
-		c.tempf.Close()
+		ticker.Stop()
+		if closer, ok := c.status.(io.Closer); ok {
+			closer.Close()
+		}
+		if closer, ok := c.temp.(io.Closer); ok {
+			closer.Close()
+		}
+		if closer, ok := c.temp2.(io.Closer); ok {
+			closer.Close()
+		}
+		c.emit(EventStopped, c.fault)
+		close(c.done)
+		if c.WaitGroup != nil {
+			c.WaitGroup.Done()
+		}
 	}()
 
-	cancelOnOff := make(chan struct{})
+	stopPacing := make(chan struct{})
+	defer close(stopPacing)
+	if replay, ok := c.temp.(*Replay); ok && replay.read != nil {
+		go replay.pace(c.Clock.(*MockClock), c.window, stopPacing)
+	}
+
+	var offTimer <-chan time.Time
 	for c.Running {
 		select {
-		case <-clock:
+		case <-ticker.C():
+			frameStart := c.Clock.Now()
+			if !c.lastTick.IsZero() {
+				interval := frameStart.Sub(c.lastTick)
+				c.dbg.TickInterval = float64(interval) / float64(time.Millisecond)
+				c.jitter.Observe(math.Abs((interval - c.window).Seconds()))
+			}
+			c.lastTick = frameStart
 			oldTemp := c.Temp
 			err = c.updateTemp()
-			if err != nil {
-				c.errLog.Printf("error while updating coil temp: %s\nexiting...\n", err.Error())
-				c.pid.Set(0)
-				c.Stop <- struct{}{}
+			c.dbg.ReadLatency = float64(c.Clock.Now().Sub(frameStart)) / float64(time.Millisecond)
+			// A skipped window rides out a transient blank read on the last good temperature, so there must have been one;
+			// it feeds nothing but its frame
+			skipped := false
+			if err == ErrBadRead && c.badReads < c.maxBadReads && !c.LastUpdated.IsZero() {
+				c.badReads++
+				c.infoLog.Printf("bad thermocouple read %d of %d allowed in a row; keeping last temperature %.2ff\n", c.badReads, c.maxBadReads, c.Temp)
+				err = nil
+				skipped = true
+			} else if err == nil {
+				c.badReads = 0
+			}
+			switch {
+			case skipped:
+			case err != nil:
+				c.latchFault("error while updating coil temp: " + err.Error())
+			case c.sensorFault != 0:
+				// Trust the sensor's own fault bits when it has them
+				c.latchFault("thermocouple reported fault: " + c.sensorFault.String())
+			case math.Abs(oldTemp-c.Temp) >= MaxTempDiff && c.warmReads >= c.spikeWarmup:
+				// Make sure the temp hasnt spiked due to tehrmocouple issues
+				c.latchFault(ErrLostConn.Error())
+			case c.warmReads < c.spikeWarmup:
+				c.warmReads++
+				if c.warmReads == c.spikeWarmup {
+					c.infoLog.Printf("armed thermocouple spike detector after %d warm-up readings\n", c.warmReads)
+				}
 			}
 
-			// Make sure the temp hasnt spiked due to tehrmocouple issues
-			if math.Abs(oldTemp-c.Temp) >= MaxTempDiff && c.nonInitialRun {
-				c.errLog.Println("lost connection to thermocouple")
-				c.pid.Set(0)
-				c.Stop <- struct{}{}
+			validReading := err == nil && c.sensorFault == 0 && !skipped
+			if validReading {
+				c.trackBand(frameStart)
+				c.trackOvershoot()
+			} else if !skipped {
+				c.inBandSince, c.timeInBand = time.Time{}, 0
+			}
+			timeInBand := c.timeInBand
+			bakeRemaining := c.trackBake(frameStart)
+			var autotuneStatus *AutotuneStatus
+			if c.tuneStatus != nil {
+				status := *c.tuneStatus
+				autotuneStatus = &status
 			}
+			sensors := c.sensors
+			maxOvershoot := c.overshoot
+			atTemperature := !c.inBandSince.IsZero() && timeInBand >= c.dwell
 
-			c.nonInitialRun = true
+			var idleRemaining time.Duration
+			if c.idleTimeout > 0 && !c.idled {
+				idleRemaining = c.idleTimeout - frameStart.Sub(c.lastTargetAt)
+				if idleRemaining <= 0 {
+					idleRemaining = 0
+					c.idled = true
+					c.pid.Set(c.idleTarget)
+					c.errLog.Printf("no new target for %s; dropping target to idle value %.2ff\n", c.idleTimeout, c.idleTarget)
+				}
+			}
 
-			c.FireTime = time.Duration(c.pid.Update(c.Temp)) * time.Millisecond
-			c.infoLog.Printf("pulsing coil: %+v\n", c.FireTime)
-			frameStart := time.Now()
+			c.checkDeadman(frameStart)
 
-			// Pulse the coil
-			go func() {
-				err = c.OnOff(cancelOnOff, c.FireTime)
-				if err != nil {
-					c.errLog.Printf("error while pulsing coil: %s\nexiting...\n", err.Error())
-					c.pid.Set(0)
-					c.Stop <- struct{}{}
+			if !c.rampStart.IsZero() {
+				// Shutting down: faults still cut the coil off straight away
+				elapsed := frameStart.Sub(c.rampStart)
+				if c.fault != "" || elapsed >= c.shutdownRamp || c.rampFrom <= c.ambient {
+					return c.halt()
 				}
-			}()
-
-			// Send out this time slice's frame
-			go func() {
-				frame := CoilFrame{
-					Temp:          c.Temp,
-					Target:        c.pid.Get(),
-					FrameStart:    frameStart,
-					FrameDuration: c.window.Milliseconds(),
-					FireTime:      c.FireTime.Milliseconds(),
+				c.pid.Set(c.rampFrom + (c.ambient-c.rampFrom)*float64(elapsed)/float64(c.shutdownRamp))
+			}
+
+			prevFireTime := c.FireTime
+			if c.fault == "" && !c.disabled {
+				if skipped {
+					// Hold the last window's output rather than run the controller on a stale temperature
+					c.FireTime = c.limitContinuousOn(prevFireTime)
+				} else if fire, tuning := c.autotuneFire(frameStart); tuning {
+					c.FireTime = c.limitContinuousOn(fire)
+				} else {
+					c.FireTime = c.computeFireTime(frameStart)
 				}
-				select {
-				// If the previous frame is still in the channel, flush it out and send in a new one
-				case <-c.CurrentFrameChan:
-					c.CurrentFrameChan <- frame
-				case c.CurrentFrameChan <- frame:
+				c.FireTime = c.limitDuty(c.FireTime)
+				c.infoLog.Printf("pulsing coil: %+v\n", c.FireTime)
+			} else {
+				c.FireTime = 0
+				c.continuousOn, c.onStreak = 0, 0
+			}
+			if validReading && !c.monitorOnly {
+				// Without the element ever on, the rise guard would only ever fault
+				c.checkRise(frameStart, c.FireTime)
+				if c.fault != "" {
+					// The guard just tripped, so this window mustn't fire either
+					c.FireTime = 0
+					c.continuousOn, c.onStreak = 0, 0
 				}
-				c.CurrentFrame = frame
-			}()
+			}
+			// A window held on into the next energizes the coil for all of it
+			energized := c.FireTime
+			if c.coalesces(c.FireTime) {
+				energized = c.window
+			}
+			c.countWindow(energized)
+			c.trackDuty(energized)
+			if c.FireTime > 0 && prevFireTime == 0 {
+				c.emit(EventFiringStarted, "")
+			} else if c.FireTime == 0 && prevFireTime > 0 {
+				c.emit(EventFiringStopped, "")
+			}
+			pidReset := c.pidReset
+			c.pidReset = false
+			fault := c.fault
+			phase := c.updatePhase()
+			continuousOn := c.continuousOn
 
-		case target := <-c.SetTarget:
-			c.pid.Set(target)
-			c.infoLog.Printf("set new target for coil temperature: %.2ff\n", target)
-		case <-c.Stop:
-			c.pid.Set(0)
-			cancelOnOff <- struct{}{}
-			_, err = c.statf.Write([]byte("0"))
-			if err != nil {
-				c.errLog.Printf("error while shutting off coil, panicking: %s\n", err.Error())
-				panic(err)
+			// Pulse the coil; the off timer ends the pulse
+			offTimer = nil
+			c.dbg.Coalesced = c.heldOn && c.Firing && c.FireTime > 0
+			c.heldOn = false
+			if c.FireTime > 0 {
+				if err = c.setStatus(true); err == nil {
+					if c.coalesces(c.FireTime) {
+						// Stay on through the blip off at the end of the window; the next window turns the coil off if it needs to
+						c.heldOn = true
+					} else {
+						offTimer = c.Clock.After(c.FireTime)
+					}
+				}
+			} else if c.Firing {
+				c.setStatus(false)
+			}
+
+			// Send out this time slice's frame, built here so the job publishing it doesn't race the next window
+			frame := CoilFrame{
+				Seq:           atomic.AddUint64(&c.counters.seq, 1),
+				Timestamp:     time.Now(),
+				Temp:          c.Temp,
+				Target:        c.pid.Get(),
+				FrameStart:    frameStart,
+				FrameDuration: c.window.Milliseconds(),
+				FireTime:      c.FireTime.Milliseconds(),
+				IdleRemaining: idleRemaining.Milliseconds(),
+				BakeRemaining: bakeRemaining.Milliseconds(),
+				Autotune:      autotuneStatus,
+				MaxOvershoot:  maxOvershoot,
+				ContinuousOn:  continuousOn.Milliseconds(),
+				AtTemperature: atTemperature,
+				TimeInBand:    timeInBand.Milliseconds(),
+				ColdJunction:  c.coldJunction,
+				Sensors:       sensors,
+				PIDReset:      pidReset,
+				Phase:         phase,
+				Disabled:      c.disabled,
+				MonitorOnly:   c.monitorOnly,
+				Fault:         fault,
+			}
+			if !c.LastUpdated.IsZero() {
+				frame.SinceLastRead = c.Clock.Now().Sub(c.LastUpdated).Seconds()
+			}
+			if c.sensorFault != 0 {
+				frame.SensorFault = c.sensorFault.String()
+			}
+			if c.debug {
+				dbg := c.dbg
+				dbg.DroppedFrames = c.CurrentFrameChan.Dropped()
+				frame.Debug = &dbg
+			}
+			c.spawn(func() {
+				frame.TimeToTarget = c.History.timeToTarget(frameStart, frame.Temp, frame.Target)
+				c.publish(frame)
+				if validReading && atomic.CompareAndSwapUint32(&c.ready, 0, 1) {
+					c.infoLog.Printf("first valid frame published; coil is ready\n")
+				}
+			})
+
+		case <-offTimer:
+			offTimer = nil
+			c.setStatus(false)
+		case cmd := <-c.SetTarget:
+			c.handleTarget(cmd)
+		case source := <-c.Enable:
+			c.enable(source)
+		case req := <-c.Bake:
+			c.startBake(req)
+		case req := <-c.Autotune:
+			c.startAutotune(req)
+		case <-c.StopAutotune:
+			c.endAutotune(AutotuneAborted, "stopped on request")
+		case <-c.CancelBake:
+			if c.bake != nil {
+				c.infoLog.Printf("cancelled bake; turning the coil off\n")
+				c.disable("bake cancellation")
+			}
+		case req := <-c.ApplyPreset:
+			if err := c.ValidatePreset(req.Preset); err != nil {
+				req.Reply <- PresetResult{Err: err}
+				continue
+			}
+			if c.applyPreset(req.Preset) {
+				ticker.Stop()
+				ticker = c.Clock.Tick(c.window)
+				c.lastTick = time.Time{}
+			}
+			req.Reply <- PresetResult{Config: c.Config()}
+		case req := <-c.SetWindow:
+			if err := c.ValidateWindow(req.Window); err != nil {
+				req.Reply <- WindowResult{Err: err}
+				continue
+			}
+			if c.changeWindow(req.Window) {
+				ticker.Stop()
+				ticker = c.Clock.Tick(c.window)
+				c.lastTick = time.Time{}
 			}
-			c.Firing = false
-			c.Running = false
-			if c.WaitGroup != nil {
-				c.WaitGroup.Done()
+			req.Reply <- WindowResult{Config: c.Config()}
+		case v := <-c.SetVoltage:
+			if err := c.ValidateVoltage(v); err != nil {
+				c.errLog.Printf("ignoring supply voltage %g: %s\n", v, err.Error())
+				continue
 			}
-			c.infoLog.Printf("stopped coil run loop\n")
-			return
+			c.setSupplyVoltage(v)
+		case reply := <-c.ExportState:
+			reply <- c.state()
+		case req := <-c.RestoreState:
+			if err := c.ValidateState(req.State); err != nil {
+				req.Reply <- StateResult{Err: err}
+				continue
+			}
+			if c.restoreState(req.State) {
+				ticker.Stop()
+				ticker = c.Clock.Tick(c.window)
+				c.lastTick = time.Time{}
+			}
+			req.Reply <- StateResult{State: c.state()}
+		case <-c.ResetPID:
+			c.pid.Reset()
+			c.lastPIDUpdate = time.Time{}
+			c.pidReset = true
+			c.infoLog.Printf("reset P.I.D. controller state; target is still %.2ff\n", c.pid.Get())
+		case <-c.Clear:
+			if c.fault != "" {
+				c.infoLog.Printf("cleared fault: %s\n", c.fault)
+				c.emit(EventCleared, c.fault)
+			}
+			c.fault = ""
+			c.writeFailures = nil
+		case <-c.EStop:
+			c.errLog.Printf("EMERGENCY STOP: turning the coil off until cleared\n")
+			offTimer = nil
+			c.heldOn = false
+			c.setStatus(false)
+			if c.fault != FaultEStop {
+				c.fault = ""
+				c.latchFault(FaultEStop)
+			}
+		case <-c.Shutdown:
+			if c.shutdownRamp <= 0 || c.fault != "" || !c.rampStart.IsZero() {
+				return c.halt()
+			}
+			c.rampStart = c.Clock.Now()
+			c.rampFrom = c.pid.Get()
+			c.infoLog.Printf("ramping target down from %.2ff over %s before shutting down\n", c.rampFrom, c.shutdownRamp)
+		case <-c.Stop:
+			return c.halt()
 		}
 	}
+	return nil
 }
 
-func (c *Coil) updateTemp() error {
-	_, err := c.tempf.Read(c.tempb)
-	if err != nil {
-		return err
+// Done is closed once Run returns, however it came to, so whatever reads the coil's frames can tell it has gone quiet
+// for good.
+func (c *Coil) Done() <-chan struct{} {
+	return c.done
+}
+
+// TargetCommand sets the target, or moves it by Target if Relative, clamping the result to PI_HEATER_MAX_TARGET and for
+// relative moves PI_HEATER_MIN_TARGET too. Every source of new targets sends one, so the run loop orders them and logs
+// who set what; Source names the sender, e.g. "http 10.0.0.2:51234".
+// If Reply is set, the resulting target is sent on it, and it must be buffered.
+// Deadman marks commands from a control channel such as a websocket, which arm the deadman and keep it from dropping
+// the target; a relative move of zero does so without changing the target.
+// Off disables the element instead, keeping the target; any other command but a relative move of zero enables it again.
+type TargetCommand struct {
+	Target   float64 // InternalUnit
+	Relative bool
+	Off      bool
+	Source   string
+	Deadman  bool
+	Reply    chan float64
+}
+
+func (c *Coil) handleTarget(cmd TargetCommand) {
+	switch {
+	case cmd.Off:
+		c.disable(cmd.Source)
+	case math.IsNaN(cmd.Target) || math.IsInf(cmd.Target, 0):
+		c.errLog.Printf("ignoring target %g from %s\n", cmd.Target, cmd.Source)
+	case cmd.Relative:
+		if cmd.Target != 0 {
+			c.enable(cmd.Source)
+		}
+		target := math.Max(c.Target()+cmd.Target, c.minTarget)
+		if c.maxTarget != 0 {
+			target = math.Min(target, c.maxTarget)
+		}
+		c.infoLog.Printf("%s moved the target by %+.2ff\n", cmd.Source, cmd.Target)
+		c.setTarget(target)
+	default:
+		c.enable(cmd.Source)
+		c.infoLog.Printf("%s set the target to %.2ff\n", cmd.Source, cmd.Target)
+		c.setTarget(cmd.Target)
 	}
-	ts := string(c.tempb)
-	ts = strings.TrimRightFunc(ts, trimTest)
-	t, err := strconv.ParseFloat(ts, 64)
-	if err != nil {
+	c.feedDeadman(cmd)
+	if cmd.Reply != nil {
+		cmd.Reply <- c.Target()
+	}
+}
+
+// spawn runs job on the Scheduler if there is one, and on its own goroutine otherwise.
+func (c *Coil) spawn(job func()) {
+	if c.Scheduler != nil {
+		c.Scheduler.Go(job)
+		return
+	}
+	go job()
+}
+
+// Target returns the target the user last asked for; it is safe to call from any goroutine.
+// The frame's Target is the controller's setpoint, which differs from this once the idle timeout drops it.
+func (c *Coil) Target() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.target))
+}
+
+// setTarget is the only way a new target reaches the controller, so it clamps every target to maxTarget.
+// Clamping is about intent and is only logged; an over-temperature reading is a fault.
+func (c *Coil) setTarget(target float64) {
+	if !c.rampStart.IsZero() {
+		c.infoLog.Printf("ignoring new target %.2ff while ramping down to shut down\n", target)
+		return
+	}
+	if c.maxTarget != 0 && target > c.maxTarget {
+		c.errLog.Printf("clamping target %.2ff to PI_HEATER_MAX_TARGET %.2ff\n", target, c.maxTarget)
+		target = c.maxTarget
+	}
+	if c.bake != nil {
+		c.infoLog.Printf("new target %.2ff cancels the bake in progress\n", target)
+		c.bake = nil
+	}
+	c.endAutotune(AutotuneAborted, "a new target was set")
+	c.tuneStatus = nil
+	c.startSoftStart(target)
+	c.armRiseGuard(target)
+	atomic.StoreUint64(&c.target, math.Float64bits(target))
+	c.pid.Set(target)
+	c.lastTargetAt = c.Clock.Now()
+	c.idled = false
+	c.reached = false
+	c.resetOvershoot()
+	c.inBandSince = time.Time{}
+	c.timeInBand = 0
+	c.emit(EventTarget, "")
+	c.infoLog.Printf("set new target for coil temperature: %.2ff\n", target)
+}
+
+// setStatus turns the coil on or off.
+// Write failures are counted, and enough of them within breakerWindow latch a fault rather than retrying every window.
+func (c *Coil) setStatus(on bool) error {
+	err := c.status.SetStatus(on)
+	if err == nil {
+		c.Firing = on
+		return nil
+	}
+	atomic.AddUint64(&c.writeFailureCount, 1)
+	c.errLog.Printf("error while writing to status device: %s\n", err.Error())
+
+	now := c.Clock.Now()
+	failures := c.writeFailures[:0]
+	for _, t := range append(c.writeFailures, now) {
+		if now.Sub(t) < c.breakerWindow {
+			failures = append(failures, t)
+		}
+	}
+	c.writeFailures = failures
+	if len(c.writeFailures) >= c.breakerFailures {
+		c.latchFault(fmt.Sprintf("%d status device write failures within %s", len(c.writeFailures), c.breakerWindow))
+	}
+	return err
+}
+
+// latchFault stops the coil from firing until a value is received on Clear.
+// The run loop keeps reading the temperature and sending frames while faulted.
+func (c *Coil) latchFault(reason string) {
+	if c.fault != "" {
+		return
+	}
+	c.fault = reason
+	c.endAutotune(AutotuneAborted, "faulted: "+reason)
+	atomic.AddUint64(&c.counters.faults, 1)
+	c.errLog.Printf("coil faulted, firing disabled until cleared: %s\n", reason)
+	c.emit(EventFault, reason)
+	if c.Firing {
+		c.setStatus(false)
+	}
+}
+
+// LoopJitter is a histogram of how far, in seconds, the run loop's windows stray from the configured window.
+func (c *Coil) LoopJitter() *Histogram {
+	return c.jitter
+}
+
+// CurrentFrame returns the most recently published frame, or a zero frame before the first.
+// It is safe to call from any goroutine and never waits on the run loop; published frames are never modified,
+// and the pointers and slices in them are freshly allocated for each one.
+func (c *Coil) CurrentFrame() CoilFrame {
+	frame, _ := c.current.Load().(CoilFrame)
+	return frame
+}
+
+// Ready reports whether a frame with a valid reading has been published yet; until then CurrentFrame is zero valued.
+func (c *Coil) Ready() bool {
+	return atomic.LoadUint32(&c.ready) == 1
+}
+
+// WriteFailures returns how many writes to the status device have failed.
+func (c *Coil) WriteFailures() uint64 {
+	return atomic.LoadUint64(&c.writeFailureCount)
+}
+
+// computeFireTime runs the controller for the window starting at now and returns how long to fire the coil.
+func (c *Coil) computeFireTime(now time.Time) time.Duration {
+	if c.controlMode == ControlBangBang {
+		// Same safety limits, different control law
+		return c.limitContinuousOn(c.softStartCap(time.Duration(c.bangBang()) * time.Millisecond))
+	}
+	var dt time.Duration
+	if !c.lastPIDUpdate.IsZero() {
+		dt = now.Sub(c.lastPIDUpdate)
+	}
+	c.lastPIDUpdate = now
+	out := c.pid.Update(c.Temp, dt)
+	c.dbg.PIDOutput = out
+	c.dbg.Terms = c.pid.Terms()
+
+	// Feedforward supplies the base duty needed to hold the target so the integral doesn't have to
+	ff := c.ffGain * (c.pid.Get() - c.ambient)
+	c.dbg.Feedforward = ff
+	// A sagging supply needs a longer pulse for the same power
+	scale := c.voltageScale()
+	c.dbg.VoltageScale = scale
+	out = clamp((out+ff)*scale, 0, c.maxFire)
+	c.dbg.RawFireTime = out
+
+	// Limit how far the fire time can swing from the previous window's
+	if c.maxSlew > 0 {
+		prev := float64(c.FireTime.Milliseconds())
+		out = clamp(out, prev-c.maxSlew, prev+c.maxSlew)
+	}
+
+	fire := c.softStartCap(time.Duration(out) * time.Millisecond)
+	return c.limitContinuousOn(fire)
+}
+
+// limitContinuousOn forces fire to zero if it would keep the coil on for longer than maxContinuousOn.
+// A window counts towards the streak when it fires for the longest allowed pulse, leaving only a blip off at its end,
+// or when it is held on into the next window.
+func (c *Coil) limitContinuousOn(fire time.Duration) time.Duration {
+	c.continuousOn = c.onStreak + fire
+	if fire == 0 {
+		c.continuousOn = 0
+	}
+	if c.maxContinuousOn > 0 && c.continuousOn > c.maxContinuousOn {
+		c.infoLog.Printf("coil would be on continuously for %s, more than %s; forcing an off window\n", c.continuousOn, c.maxContinuousOn)
+		fire = 0
+		c.continuousOn = 0
+	}
+	if fire.Milliseconds() >= int64(c.maxFire) || c.coalesces(fire) {
+		c.onStreak += c.window
+	} else {
+		c.onStreak = 0
+	}
+	return fire
+}
+
+// coalesces reports whether a window firing for fire would leave the coil off for no longer than the coalescing gap,
+// and so holds it on into the next window. The next window's own fire time ends the held pulse.
+func (c *Coil) coalesces(fire time.Duration) bool {
+	return fire > 0 && c.window-fire <= c.coalesce
+}
+
+// publish makes frame the current frame and hands it to the hub.
+// A frame that lost the race to a newer one is left out of History and CurrentFrame, and counted as dropped.
+func (c *Coil) publish(frame CoilFrame) {
+	c.publishMu.Lock()
+	defer c.publishMu.Unlock()
+	if frame.Seq < c.publishedSeq {
+		// FrameChan drops it too, and counts it
+		c.CurrentFrameChan.Send(frame)
+		return
+	}
+	c.publishedSeq = frame.Seq
+	c.History.Add(frame)
+	c.current.Store(frame)
+	c.CurrentFrameChan.Send(frame)
+}
+
+// halt cancels any pulse in progress and turns the coil off; the run loop must return its error right after.
+// If the coil won't turn off, the failure is latched as a fault so the final frame and events report it.
+func (c *Coil) halt() error {
+	c.pid.Set(0)
+	c.Running = false
+	if err := c.status.SetStatus(false); err != nil {
+		err = errors.New("error while shutting off coil: " + err.Error())
+		c.latchFault(err.Error())
+		c.errLog.Printf("stopped coil run loop, but the coil may still be on: %s\n", err.Error())
 		return err
 	}
-	c.Temp = ((9.0)/(20.0))*t + 32.0
-	c.LastUpdated = time.Now()
-	c.infoLog.Printf("updated coil temperature: %.2ff\n", c.Temp)
+	c.Firing = false
+	c.infoLog.Printf("stopped coil run loop\n")
 	return nil
 }
 
-func (c *Coil) OnOff(cancel chan struct{}, d time.Duration) error {
-	_, err := c.statf.Write([]byte("1"))
+func (c *Coil) updateTemp() error {
+	reading, err := readSource(c.temp)
 	if err != nil {
 		return err
 	}
-	c.Firing = true
-	timer := time.After(d)
-	select {
-	case <-timer:
-	case <-cancel:
+	c.sensorFault = reading.Fault
+	if reading.Fault != 0 {
 		return nil
 	}
-	_, err = c.statf.Write([]byte("0"))
-	c.Firing = false
-	return err
+	if reading.HasColdJunction {
+		cj := Celsius.ToInternal(reading.ColdJunction)
+		c.coldJunction = &cj
+	}
+	temp := ConvertRaw(reading.Raw, c.calibration)
+	c.sensors = nil
+	if err = c.checkTempLimits("thermocouple", temp); err != nil {
+		return err
+	}
+	if c.temp2 != nil {
+		second, err := readSource(c.temp2)
+		if err == ErrBadRead {
+			return err
+		}
+		if err != nil {
+			return errors.New("error while reading second thermocouple: " + err.Error())
+		}
+		if second.Fault != 0 {
+			c.sensorFault = second.Fault
+			return nil
+		}
+		temp2 := ConvertRaw(second.Raw, c.calibration)
+		if err = c.checkTempLimits("second thermocouple", temp2); err != nil {
+			return err
+		}
+		c.sensors = []float64{temp, temp2}
+		if diff := math.Abs(temp - temp2); c.disagreeMax > 0 && diff > c.disagreeMax {
+			return fmt.Errorf("thermocouples disagree by %.2f degrees, more than PI_HEATER_TC_DISAGREE_MAX", diff)
+		}
+		switch c.strategy {
+		case "min":
+			temp = math.Min(temp, temp2)
+		case "max":
+			temp = math.Max(temp, temp2)
+		default:
+			temp = (temp + temp2) / 2
+		}
+	}
+	c.Temp = temp
+	c.LastUpdated = c.Clock.Now()
+	atomic.StoreInt64(&c.counters.lastRead, c.LastUpdated.UnixNano())
+	c.infoLog.Printf("updated coil temperature: %.2ff\n", c.Temp)
+	return nil
+}
+
+// readSource takes a reading from src, with fault bits and the cold junction if src reports them.
+func readSource(src TempReader) (Reading, error) {
+	if dr, ok := src.(DetailedTempReader); ok {
+		return dr.ReadDetailed()
+	}
+	var reading Reading
+	var err error
+	reading.Raw, err = src.ReadTemp()
+	return reading, err
 }
 
-func trimTest(c rune) bool {
-	return !unicode.IsNumber(c)
+func clamp(v, min, max float64) float64 {
+	return math.Min(math.Max(v, min), max)
 }
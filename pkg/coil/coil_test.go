@@ -0,0 +1,197 @@
+package coil
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOccasionalBlankReadsAreRiddenOut(t *testing.T) {
+	c, clock := newTestCoil(t, map[string]string{"PI_HEATER_MAX_BAD_READS": "2"})
+	blank := math.NaN()
+	c.temp = newScriptedReader(150, blank, 151, blank, blank, 152)
+	status := &recordingStatus{}
+	c.status = status
+	startCoil(t, c, clock)
+	c.SetTarget <- TargetCommand{Target: 300, Source: "test"}
+
+	var prev CoilFrame
+	for i, want := range []struct {
+		temp    float64
+		skipped bool
+	}{{150, false}, {150, true}, {151, false}, {151, true}, {151, true}, {152, false}} {
+		frame := step(t, c, clock)
+		if frame.Fault != "" {
+			t.Fatalf("window %d faulted: %s", i, frame.Fault)
+		}
+		if math.Abs(frame.Temp-want.temp) > 0.01 {
+			t.Errorf("window %d: temp = %.2f, want %.2f", i, frame.Temp, want.temp)
+		}
+		if want.skipped && frame.FireTime != prev.FireTime {
+			t.Errorf("window %d: a skipped window fired for %dms, want the last window's %dms", i, frame.FireTime, prev.FireTime)
+		}
+		prev = frame
+	}
+	if !c.Ready() {
+		t.Error("coil is not ready after good reads")
+	}
+}
+
+func TestTooManyBlankReadsFault(t *testing.T) {
+	c, clock := newTestCoil(t, map[string]string{"PI_HEATER_MAX_BAD_READS": "2"})
+	blank := math.NaN()
+	c.temp = newScriptedReader(150, blank, blank, blank)
+	c.status = &recordingStatus{}
+	startCoil(t, c, clock)
+
+	for i := 0; i < 3; i++ {
+		if frame := step(t, c, clock); frame.Fault != "" {
+			t.Fatalf("window %d faulted before the limit: %s", i, frame.Fault)
+		}
+	}
+	frame := step(t, c, clock)
+	if !strings.Contains(frame.Fault, ErrBadRead.Error()) {
+		t.Errorf("fault = %q after three blank reads in a row, want one for a bad read", frame.Fault)
+	}
+}
+
+func TestBlankFirstReadIsNotRiddenOut(t *testing.T) {
+	c, clock := newTestCoil(t, map[string]string{"PI_HEATER_MAX_BAD_READS": "3"})
+	c.temp = newScriptedReader(math.NaN())
+	status := &recordingStatus{}
+	c.status = status
+	startCoil(t, c, clock)
+	c.SetTarget <- TargetCommand{Target: 300, Source: "test"}
+
+	frame := step(t, c, clock)
+	if frame.Fault == "" {
+		t.Error("a blank first read didn't fault")
+	}
+	if frame.FireTime != 0 || status.everOn() {
+		t.Errorf("fired for %dms on a blank first read", frame.FireTime)
+	}
+	if c.Ready() {
+		t.Error("coil is ready without a good read")
+	}
+}
+
+// stuckStatus is a StatusWriter that can turn the coil on but never off.
+type stuckStatus struct{}
+
+func (stuckStatus) SetStatus(on bool) error {
+	if !on {
+		return errors.New("relay stuck")
+	}
+	return nil
+}
+
+func TestRunReturnsErrorWhenCoilWontTurnOff(t *testing.T) {
+	c, clock := newTestCoil(t, nil)
+	c.status = stuckStatus{}
+	events, unsubscribe := c.Subscribe()
+	defer unsubscribe()
+	result := make(chan error, 1)
+	go func() {
+		result <- c.Run()
+	}()
+	waitFor(t, "the run loop's ticker", func() bool { return clock.pending() > 0 })
+	c.Stop <- struct{}{}
+
+	var err error
+	select {
+	case err = <-result:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return")
+	}
+	if err == nil || !strings.Contains(err.Error(), "relay stuck") {
+		t.Errorf("Run() = %v, want the failure to turn the coil off", err)
+	}
+	if !strings.Contains(c.fault, "relay stuck") {
+		t.Errorf("fault = %q, want the failure to turn the coil off latched", c.fault)
+	}
+	for {
+		select {
+		case event := <-events:
+			if event.Type == EventStopped {
+				if !strings.Contains(event.Reason, "relay stuck") {
+					t.Errorf("stopped event reason = %q, want the fault", event.Reason)
+				}
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no stopped event")
+		}
+	}
+}
+
+func TestRunReturnsNilOnCleanStop(t *testing.T) {
+	c, clock := newTestCoil(t, nil)
+	c.status = &recordingStatus{}
+	result := make(chan error, 1)
+	go func() {
+		result <- c.Run()
+	}()
+	waitFor(t, "the run loop's ticker", func() bool { return clock.pending() > 0 })
+	c.Stop <- struct{}{}
+	if err := <-result; err != nil {
+		t.Errorf("Run() = %v after a clean stop", err)
+	}
+}
+
+func TestNilLoggers(t *testing.T) {
+	// The loop logs a bad target, a new one, the idle timeout running out and an emergency stop, with nowhere to log to
+	c, clock := newTestCoil(t, map[string]string{"PI_HEATER_IDLE_TIMEOUT": "2s"})
+	if c.errLog == nil || c.infoLog == nil {
+		t.Fatal("NewCoil(nil, nil) left a logger nil")
+	}
+	startCoil(t, c, clock)
+	c.SetTarget <- TargetCommand{Target: math.NaN(), Source: "test"}
+	c.SetTarget <- TargetCommand{Target: 300, Source: "test"}
+	for i := 0; i < 4; i++ {
+		step(t, c, clock)
+	}
+	c.EStop <- struct{}{}
+	c.Clear <- struct{}{}
+	step(t, c, clock)
+}
+
+func TestResetPIDZeroesIntegral(t *testing.T) {
+	c, clock := newTestCoil(t, map[string]string{"PI_HEATER_DEBUG": "1"})
+	c.temp = newScriptedReader(100)
+	startCoil(t, c, clock)
+	c.SetTarget <- TargetCommand{Target: 150, Source: "test"}
+
+	var frame CoilFrame
+	for i := 0; i < 5; i++ {
+		frame = step(t, c, clock)
+	}
+	if frame.Debug.Terms.I <= 0 {
+		t.Fatalf("integral term %v after 5 windows below the target, want it built up", frame.Debug.Terms.I)
+	}
+	if frame.PIDReset {
+		t.Fatal("frame marked pid_reset before any reset")
+	}
+
+	c.ResetPID <- struct{}{}
+	frame = step(t, c, clock)
+	if frame.Debug.Terms.I != 0 {
+		t.Errorf("integral term %v in the window after the reset, want 0", frame.Debug.Terms.I)
+	}
+	if !frame.PIDReset {
+		t.Error("first frame after the reset isn't marked pid_reset")
+	}
+	if frame.Target != 150 || c.Target() != 150 {
+		t.Errorf("target %v (frame %v) after the reset, want 150 unchanged", c.Target(), frame.Target)
+	}
+
+	// The marker is momentary, and the integral builds up again from zero
+	frame = step(t, c, clock)
+	if frame.PIDReset {
+		t.Error("second frame after the reset still marked pid_reset")
+	}
+	if frame.Debug.Terms.I <= 0 {
+		t.Errorf("integral term %v a window after the reset, want it building up again", frame.Debug.Terms.I)
+	}
+}
@@ -0,0 +1,111 @@
+package coil
+
+import (
+	"errors"
+	"os"
+	"strconv"
+)
+
+// Control modes select the law that turns temperature error into fire time.
+const (
+	ControlPID      = "pid"
+	ControlBangBang = "bangbang"
+)
+
+// Config is the coil's configuration as reported by GET /config.
+type Config struct {
+	Target         float64 `json:"target"` // as last requested, in Unit
+	ControlMode    string  `json:"control_mode"`
+	Hysteresis     float64 `json:"hysteresis,omitempty"` // degrees either side of the target; bang-bang only
+	P              float64 `json:"p"`
+	I              float64 `json:"i"`
+	D              float64 `json:"d"`
+	DerivativeMode string  `json:"derivative_mode,omitempty"`
+	Tolerance      float64 `json:"tolerance"` // degrees either side of the target counted as at temperature
+	Dwell          int64   `json:"dwell"`     // milliseconds in the band before the coil is at temperature
+	MinTarget      float64 `json:"min_target"`
+	MaxTarget      float64 `json:"max_target,omitempty"` // unlimited when omitted
+	Window         int64   `json:"window"`               // milliseconds
+	MaxFire        int64   `json:"max_fire"`             // milliseconds
+	StaleAfter     int64   `json:"stale_after"`          // milliseconds the last good reading may age before /health degrades
+	Unit           Unit    `json:"unit"`
+	Display        Display `json:"display"`
+	NominalVoltage float64 `json:"nominal_voltage,omitempty"` // set when the output is scaled for the supply voltage
+	Simulated      bool    `json:"simulated"`
+	MonitorOnly    bool    `json:"monitor_only,omitempty"` // the coil is never turned on; see PI_HEATER_MONITOR_ONLY
+
+	// TempFloor and TempCeiling bound the readings trusted as control inputs; each is unbounded when omitted.
+	TempFloor   *float64 `json:"temp_floor,omitempty"`
+	TempCeiling *float64 `json:"temp_ceiling,omitempty"`
+}
+
+// Config returns the coil's configuration; it is safe to call from any goroutine.
+func (c *Coil) Config() Config {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	cfg := c.config
+	cfg.Target = c.Target()
+	return cfg
+}
+
+// refreshConfig snapshots the configuration for Config; only NewCoil and the run loop may call it.
+func (c *Coil) refreshConfig() {
+	p, i, d := c.pid.Gains()
+	cfg := Config{
+		Target:         c.Target(),
+		ControlMode:    c.controlMode,
+		P:              p,
+		I:              i,
+		D:              d,
+		Tolerance:      c.tolerance,
+		Dwell:          c.dwell.Milliseconds(),
+		MinTarget:      c.minTarget,
+		MaxTarget:      c.maxTarget,
+		Window:         c.window.Milliseconds(),
+		MaxFire:        int64(c.maxFire),
+		StaleAfter:     c.staleLimit().Milliseconds(),
+		Unit:           InternalUnit,
+		Display:        c.display,
+		Simulated:      c.sim != nil,
+		MonitorOnly:    c.monitorOnly,
+		NominalVoltage: c.nominalVoltage,
+	}
+	cfg.TempFloor, cfg.TempCeiling = c.tempLimits()
+	if c.controlMode == ControlBangBang {
+		cfg.Hysteresis = c.hysteresis
+	} else {
+		cfg.DerivativeMode = c.derivMode
+	}
+	c.configMu.Lock()
+	c.config = cfg
+	c.configMu.Unlock()
+}
+
+// controlGain parses a PID gain; gains are optional in bang-bang mode since they aren't used.
+func controlGain(mode, name string) (float64, error) {
+	s := os.Getenv(name)
+	if s == "" && mode == ControlBangBang {
+		return 0, nil
+	}
+	g, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, errors.New("error while parsing " + name + ": " + err.Error())
+	}
+	return g, nil
+}
+
+// bangBang fires for the longest allowed pulse until the temperature reaches the top of the hysteresis band,
+// then stays off until it falls to the bottom of it.
+func (c *Coil) bangBang() float64 {
+	target := c.pid.Get()
+	switch {
+	case c.Temp >= target+c.hysteresis:
+		c.heating = false
+	case c.Temp <= target-c.hysteresis:
+		c.heating = true
+	}
+	if c.heating {
+		return c.maxFire
+	}
+	return 0
+}
@@ -0,0 +1,36 @@
+package coil
+
+import "time"
+
+// feedDeadman arms the deadman, or restarts its timer, for a target command from a control channel; a target from
+// anywhere else disarms it, since whoever sent it has taken over from the automation.
+func (c *Coil) feedDeadman(cmd TargetCommand) {
+	if c.deadmanTimeout == 0 {
+		return
+	}
+	if !cmd.Deadman {
+		if !c.deadmanAt.IsZero() {
+			c.infoLog.Printf("%s set the target; disarming the deadman\n", cmd.Source)
+		}
+		c.deadmanAt = time.Time{}
+		return
+	}
+	if c.deadmanAt.IsZero() {
+		c.infoLog.Printf("armed the deadman: the target drops to %.2ff unless %s sends another within %s\n",
+			c.deadmanTarget, cmd.Source, c.deadmanTimeout)
+	}
+	c.deadmanAt = c.Clock.Now()
+}
+
+// checkDeadman drops the target to the deadman's safe target once the armed deadman goes deadmanTimeout without a
+// command, emitting a deadman event.
+func (c *Coil) checkDeadman(now time.Time) {
+	if c.deadmanAt.IsZero() || now.Sub(c.deadmanAt) < c.deadmanTimeout {
+		return
+	}
+	c.deadmanAt = time.Time{}
+	c.errLog.Printf("no target command over the control channel for %s; dropping target to deadman value %.2ff\n",
+		c.deadmanTimeout, c.deadmanTarget)
+	c.setTarget(c.deadmanTarget)
+	c.emit(EventDeadman, "no target command for "+c.deadmanTimeout.String())
+}
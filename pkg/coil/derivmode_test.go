@@ -0,0 +1,50 @@
+package coil
+
+import (
+	"reflect"
+	"testing"
+)
+
+// stepResponse holds the coil at its target, then raises the target 50f and returns the fire times, in
+// milliseconds, of the windows that follow.
+func stepResponse(t *testing.T, mode string) []int64 {
+	t.Helper()
+	c, clock := newTestCoil(t, map[string]string{
+		"PI_HEATER_PID_DERIV_MODE": mode,
+		"PI_HEATER_PID_P":          "2",
+		"PI_HEATER_PID_I":          "0",
+		"PI_HEATER_PID_D":          "10",
+	})
+	c.temp = newScriptedReader(200)
+	startCoil(t, c, clock)
+	c.SetTarget <- TargetCommand{Target: 200, Source: "test"}
+	for i := 0; i < 3; i++ {
+		step(t, c, clock)
+	}
+	if frame := step(t, c, clock); frame.FireTime != 0 {
+		t.Fatalf("%s: fired for %dms holding at the target, want 0", mode, frame.FireTime)
+	}
+
+	c.SetTarget <- TargetCommand{Target: 250, Source: "test"}
+	var fires []int64
+	for i := 0; i < 3; i++ {
+		fires = append(fires, step(t, c, clock).FireTime)
+	}
+	return fires
+}
+
+func TestDerivModeStepResponse(t *testing.T) {
+	measurement := stepResponse(t, "measurement")
+	onError := stepResponse(t, "error")
+
+	// With the temperature steady, only the proportional term acts on the new error: 2 * 50f
+	for i, fire := range measurement {
+		if fire != 100 {
+			t.Errorf("measurement: window %d after the step fired for %dms, want 100ms without a kick", i+1, fire)
+		}
+	}
+	// Derivative on error kicks by 10 * 50f/s in the first window, then settles to the same output
+	if want := []int64{600, 100, 100}; !reflect.DeepEqual(onError, want) {
+		t.Errorf("error: fired for %vms after the step, want %vms", onError, want)
+	}
+}
@@ -0,0 +1,100 @@
+package coil
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SkipDeviceCheck lets the device files be something other than character devices or sysfs attributes,
+// e.g. FIFOs or plain files for testing.
+var SkipDeviceCheck = false
+
+// checkDevice makes sure path is a character device or a sysfs attribute such as a GPIO value file,
+// so a mistyped path can't have the coil reading, or overwriting, an ordinary file.
+func checkDevice(name, path string) error {
+	if SkipDeviceCheck {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.New("error while checking " + name + ": " + err.Error())
+	}
+	if info.Mode()&os.ModeCharDevice != 0 {
+		return nil
+	}
+	// sysfs attributes are regular files as far as Stat can tell
+	if abs, err := filepath.Abs(path); err == nil && strings.HasPrefix(abs, "/sys/") {
+		return nil
+	}
+	return errors.New(name + " " + path + " is not a character device or sysfs file; " +
+		"pass -insecure-gpio-check or set PI_HEATER_INSECURE_GPIO_CHECK to use it anyway")
+}
+
+// maxOpenInterval caps how long deviceWait waits between attempts.
+const maxOpenInterval = 30 * time.Second
+
+// deviceWait retries device files that aren't there yet at startup, e.g. a GPIO whose sysfs export,
+// or udev's permissions on it, hasn't caught up with the service starting.
+type deviceWait struct {
+	retries  int
+	interval time.Duration // wait before the first retry; doubles each retry after that
+	log      *log.Logger
+}
+
+// newDeviceWait is configured by PI_HEATER_DEVICE_OPEN_RETRIES and PI_HEATER_DEVICE_OPEN_INTERVAL.
+func newDeviceWait(infoLog *log.Logger) (*deviceWait, error) {
+	retries, err := envInt("PI_HEATER_DEVICE_OPEN_RETRIES", 0)
+	if err != nil {
+		return nil, err
+	}
+	if retries < 0 {
+		return nil, errors.New("PI_HEATER_DEVICE_OPEN_RETRIES must not be negative")
+	}
+	interval, err := envDuration("PI_HEATER_DEVICE_OPEN_INTERVAL", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, errors.New("PI_HEATER_DEVICE_OPEN_INTERVAL must be positive")
+	}
+	return &deviceWait{retries: retries, interval: interval, log: infoLog}, nil
+}
+
+// wait blocks until path can be opened with flag, retrying while it is missing or not yet permitted.
+// Any other error is left for the caller's own checks to report.
+func (d *deviceWait) wait(name, path string, flag int) error {
+	if path == "" || d.retries == 0 {
+		return nil
+	}
+	interval := d.interval
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(path, flag, 0)
+		if err == nil {
+			f.Close()
+			if attempt > 0 {
+				d.log.Printf("%s %s is available after %d retries\n", name, path, attempt)
+			}
+			return nil
+		}
+		if !os.IsNotExist(err) && !os.IsPermission(err) {
+			return nil
+		}
+		if attempt == d.retries {
+			return fmt.Errorf("%s %s never became available after %d retries over %s: %s",
+				name, path, attempt, waited, err.Error(),
+			)
+		}
+		d.log.Printf("%s not available yet, retrying in %s (%d of %d): %s\n", name, interval, attempt+1, d.retries, err.Error())
+		time.Sleep(interval)
+		waited += interval
+		if interval *= 2; interval > maxOpenInterval {
+			interval = maxOpenInterval
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package coil
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createLater creates path with contents after delay.
+func createLater(t *testing.T, path, contents string, delay time.Duration) {
+	done := make(chan struct{})
+	t.Cleanup(func() { <-done })
+	go func() {
+		defer close(done)
+		time.Sleep(delay)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Error(err)
+		}
+	}()
+}
+
+func TestDeviceWait(t *testing.T) {
+	dir := tempDir(t)
+	discard := log.New(ioutil.Discard, "", 0)
+
+	late := filepath.Join(dir, "late")
+	createLater(t, late, "0", 30*time.Millisecond)
+	d := &deviceWait{retries: 10, interval: 5 * time.Millisecond, log: discard}
+	if err := d.wait("late", late, os.O_RDONLY); err != nil {
+		t.Errorf("a file created after a few retries: %s", err)
+	}
+
+	missing := filepath.Join(dir, "missing")
+	d = &deviceWait{retries: 2, interval: time.Millisecond, log: discard}
+	err := d.wait("PI_HEATER_TEMP_DEV_FILE", missing, os.O_RDONLY)
+	if err == nil || !strings.Contains(err.Error(), "PI_HEATER_TEMP_DEV_FILE "+missing+" never became available after 2 retries over 3ms") {
+		t.Errorf("a file that never appears: %v", err)
+	}
+
+	// Without retries, and for errors retrying won't fix, the device checks that follow report the problem
+	d = &deviceWait{retries: 0, interval: time.Millisecond, log: discard}
+	if err := d.wait("missing", missing, os.O_RDONLY); err != nil {
+		t.Errorf("no retries: %s", err)
+	}
+	d = &deviceWait{retries: 2, interval: time.Hour, log: discard}
+	if err := d.wait("directory", dir, os.O_RDWR); err != nil {
+		t.Errorf("a directory opened for writing: %s", err)
+	}
+	if err := d.wait("unset", "", os.O_RDONLY); err != nil {
+		t.Errorf("an unset path: %s", err)
+	}
+}
+
+func TestNewCoilWaitsForDeviceFiles(t *testing.T) {
+	dir := tempDir(t)
+	tempFile, statusFile := filepath.Join(dir, "temp"), filepath.Join(dir, "status")
+	if err := ioutil.WriteFile(statusFile, []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	SkipDeviceCheck = true
+	defer func() { SkipDeviceCheck = false }()
+
+	env := map[string]string{
+		"PI_HEATER_SIMULATE":        "",
+		"PI_HEATER_TEMP_DEV_FILE":   tempFile,
+		"PI_HEATER_STATUS_DEV_FILE": statusFile,
+	}
+	setEnv(t, env)
+	if _, err := NewCoil(nil, nil); err == nil {
+		t.Fatal("NewCoil succeeded without a temperature device file")
+	}
+
+	env["PI_HEATER_DEVICE_OPEN_RETRIES"] = "20"
+	env["PI_HEATER_DEVICE_OPEN_INTERVAL"] = "5ms"
+	setEnv(t, env)
+	createLater(t, tempFile, "600", 30*time.Millisecond)
+	if _, err := NewCoil(nil, nil); err != nil {
+		t.Errorf("NewCoil with a temperature device file that turns up late: %s", err)
+	}
+
+	for key, value := range map[string]string{
+		"PI_HEATER_DEVICE_OPEN_RETRIES":  "-1",
+		"PI_HEATER_DEVICE_OPEN_INTERVAL": "0s",
+	} {
+		env[key] = value
+		setEnv(t, env)
+		if _, err := NewCoil(nil, nil); err == nil {
+			t.Errorf("NewCoil accepted %s=%s", key, value)
+		}
+		delete(env, key)
+	}
+}
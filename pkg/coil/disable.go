@@ -0,0 +1,28 @@
+package coil
+
+import "time"
+
+// disable forces the element off without touching the target; the run loop skips the controller until enable.
+// A target of 0 is not the same thing: the controller still runs and chases 0, which a sensor can read below.
+// The bake or autotune in progress is dropped, since neither can go on without heat.
+func (c *Coil) disable(source string) {
+	if c.disabled {
+		return
+	}
+	c.disabled = true
+	c.endAutotune(AutotuneAborted, "the element was disabled")
+	c.bake = nil
+	c.infoLog.Printf("%s disabled the element; the target stays at %.2ff\n", source, c.Target())
+}
+
+// enable undoes disable. The controller starts over, as its integral and last update are stale by now.
+func (c *Coil) enable(source string) {
+	if !c.disabled {
+		return
+	}
+	c.disabled = false
+	c.pid.Reset()
+	c.lastPIDUpdate = time.Time{}
+	c.pidReset = true
+	c.infoLog.Printf("%s enabled the element\n", source)
+}
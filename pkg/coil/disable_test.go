@@ -0,0 +1,45 @@
+package coil
+
+import "testing"
+
+func TestOffIsNotZeroTarget(t *testing.T) {
+	c, clock := newTestCoil(t, nil)
+	// A sensor in the cold reads below zero, so a target of 0 still has something to heat towards
+	c.temp = newScriptedReader(-20)
+	status := &recordingStatus{}
+	c.status = status
+	startCoil(t, c, clock)
+
+	c.SetTarget <- TargetCommand{Target: 0, Source: "test"}
+	frame := step(t, c, clock)
+	if frame.Disabled || frame.Phase == PhaseDisabled {
+		t.Errorf("target 0: disabled %v phase %q, want it enabled", frame.Disabled, frame.Phase)
+	}
+	if frame.FireTime == 0 || !status.everOn() {
+		t.Errorf("target 0 at -20: fired for %dms, want the controller to chase 0", frame.FireTime)
+	}
+
+	c.SetTarget <- TargetCommand{Off: true, Source: "test"}
+	for i := 0; i < 3; i++ {
+		frame = step(t, c, clock)
+		if !frame.Disabled || frame.Phase != PhaseDisabled {
+			t.Errorf("off: disabled %v phase %q, want disabled", frame.Disabled, frame.Phase)
+		}
+		if frame.FireTime != 0 || status.on() {
+			t.Errorf("off: fired for %dms", frame.FireTime)
+		}
+	}
+	if frame.Target != 0 || c.Target() != 0 {
+		t.Errorf("off moved the target to %.2f", c.Target())
+	}
+
+	// Any numeric target enables the element again, 0 included
+	c.SetTarget <- TargetCommand{Target: 0, Source: "test"}
+	frame = step(t, c, clock)
+	if frame.Disabled || frame.FireTime == 0 {
+		t.Errorf("target 0 after off: disabled %v, fired for %dms, want it enabled and firing", frame.Disabled, frame.FireTime)
+	}
+	if !frame.PIDReset {
+		t.Error("enabling didn't restart the controller")
+	}
+}
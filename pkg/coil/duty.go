@@ -0,0 +1,55 @@
+package coil
+
+import "time"
+
+// dutyWindows is how many windows, the current one included, the rolling duty cycle is averaged over.
+func (c *Coil) dutyWindows() int {
+	n := int((c.dutyWindow + c.window - 1) / c.window)
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// limitDuty shortens fire so the duty cycle averaged over dutyWindow, this window included, stays within maxDuty.
+func (c *Coil) limitDuty(fire time.Duration) time.Duration {
+	c.dbg.DutyLimited = false
+	if c.maxDuty >= 100 {
+		return fire
+	}
+	prev := c.dutyFires
+	if n := c.dutyWindows() - 1; len(prev) > n {
+		prev = prev[len(prev)-n:]
+	}
+	var fired time.Duration
+	for _, f := range prev {
+		fired += f
+	}
+	span := time.Duration(len(prev)+1) * c.window
+	allowed := time.Duration(float64(span)*c.maxDuty/100) - fired
+	if allowed < 0 {
+		allowed = 0
+	}
+	if fire > allowed {
+		c.dbg.DutyLimited = true
+		c.infoLog.Printf("limiting fire time to %s to keep the rolling duty cycle within PI_HEATER_MAX_DUTY %.0f%%\n", allowed, c.maxDuty)
+		return allowed.Truncate(time.Millisecond)
+	}
+	return fire
+}
+
+// trackDuty records how long the window just decided energizes the coil, for limitDuty and the debug frame's rolling duty.
+func (c *Coil) trackDuty(fire time.Duration) {
+	c.dutyFires = append(c.dutyFires, fire)
+	if extra := len(c.dutyFires) - c.dutyWindows(); extra > 0 {
+		c.dutyFires = append(c.dutyFires[:0], c.dutyFires[extra:]...)
+	}
+	var fired time.Duration
+	for _, f := range c.dutyFires {
+		fired += f
+	}
+	c.dbg.RollingDuty = 0
+	if len(c.dutyFires) > 0 {
+		c.dbg.RollingDuty = 100 * float64(fired) / float64(time.Duration(len(c.dutyFires))*c.window)
+	}
+}
@@ -0,0 +1,60 @@
+package coil
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+// envBool parses the named environment variable as a bool, returning false if it is unset.
+func envBool(name string) (bool, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, errors.New("error while parsing " + name + ": " + err.Error())
+	}
+	return b, nil
+}
+
+// envFloat parses the named environment variable as a float, returning def if it is unset.
+func envFloat(name string, def float64) (float64, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, errors.New("error while parsing " + name + ": " + err.Error())
+	}
+	return f, nil
+}
+
+// envDuration parses the named environment variable as a time.Duration, returning def if it is unset.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.New("error while parsing " + name + ": " + err.Error())
+	}
+	return d, nil
+}
+
+// envInt parses the named environment variable as an int, returning def if it is unset.
+func envInt(name string, def int) (int, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("error while parsing " + name + ": " + err.Error())
+	}
+	return i, nil
+}
@@ -0,0 +1,139 @@
+package coil
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types sent to subscribers.
+const (
+	EventTarget        = "target"
+	EventFault         = "fault"
+	EventCleared       = "cleared"
+	EventReached       = "reached"
+	EventFiringStarted = "firing_started"
+	EventFiringStopped = "firing_stopped"
+	EventPhase         = "phase"
+	// EventDone is emitted when a bake's hold completes and the coil turns off.
+	EventDone = "done"
+	// EventAutotune is emitted when an autotune ends; Reason is its state, and why it was aborted if it was.
+	EventAutotune = "autotune"
+	// EventDeadman is emitted when the deadman drops the target; Reason says why.
+	EventDeadman = "deadman"
+	// EventStopped is the last event, emitted when the run loop returns; Reason is the fault latched then, if any.
+	EventStopped = "stopped"
+	// EventState is never emitted by the coil; it describes the current state to a new subscriber.
+	EventState = "state"
+)
+
+// CoilEvent is a notable change in the coil's state, as opposed to the routine frame sent every window.
+type CoilEvent struct {
+	// ID increases by one with every event the coil emits, so a subscriber can tell what it missed.
+	ID     uint64    `json:"id,omitempty"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Temp   float64   `json:"temp"`
+	Target float64   `json:"target"`
+	Unit   Unit      `json:"unit"`
+	// Phase is the coil's phase when the event happened; for phase events, the phase it changed to.
+	Phase string `json:"phase,omitempty"`
+	// Reason is the fault: why the coil faulted, which fault was cleared, or for state events, the fault still latched.
+	Reason string `json:"reason,omitempty"`
+}
+
+// eventBuffer is how many events a subscriber can fall behind by before new ones are dropped for it.
+const eventBuffer = 16
+
+// eventBacklog is how many recent events are kept for subscribers resuming after a disconnect.
+const eventBacklog = 64
+
+// events fans events out to subscribers without ever blocking the run loop.
+type events struct {
+	mu     sync.Mutex
+	subs   map[chan CoilEvent]struct{}
+	lastID uint64
+	recent []CoilEvent
+}
+
+// Subscribe returns a channel that receives the coil's events, and a func that unsubscribes and closes it.
+// Events are dropped for subscribers that fall too far behind.
+func (c *Coil) Subscribe() (<-chan CoilEvent, func()) {
+	_, ch, cancel := c.SubscribeSince(^uint64(0))
+	return ch, cancel
+}
+
+// SubscribeSince is Subscribe for a subscriber that has seen every event up to and including lastID.
+// It also returns the recent events after lastID, which come before anything on the channel.
+// Only the most recent events are kept, so the backlog may start after lastID+1.
+func (c *Coil) SubscribeSince(lastID uint64) ([]CoilEvent, <-chan CoilEvent, func()) {
+	ch := make(chan CoilEvent, eventBuffer)
+	c.events.mu.Lock()
+	var backlog []CoilEvent
+	for _, event := range c.events.recent {
+		if event.ID > lastID {
+			backlog = append(backlog, event)
+		}
+	}
+	if c.events.subs == nil {
+		c.events.subs = map[chan CoilEvent]struct{}{}
+	}
+	c.events.subs[ch] = struct{}{}
+	c.events.mu.Unlock()
+	var once sync.Once
+	return backlog, ch, func() {
+		once.Do(func() {
+			c.events.mu.Lock()
+			delete(c.events.subs, ch)
+			c.events.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (c *Coil) emit(eventType, reason string) {
+	event := CoilEvent{
+		Type:   eventType,
+		Time:   c.Clock.Now(),
+		Temp:   c.Temp,
+		Target: c.pid.Get(),
+		Unit:   InternalUnit,
+		Phase:  c.phase,
+		Reason: reason,
+	}
+	c.events.mu.Lock()
+	defer c.events.mu.Unlock()
+	c.events.lastID++
+	event.ID = c.events.lastID
+	c.events.recent = append(c.events.recent, event)
+	if len(c.events.recent) > eventBacklog {
+		c.events.recent = c.events.recent[1:]
+	}
+	for ch := range c.events.subs {
+		select {
+		case ch <- event:
+		default:
+			c.errLog.Printf("dropped %s event for a subscriber that fell behind\n", eventType)
+		}
+	}
+}
+
+// trackBand times how long the temperature has held within tolerance of the target, restarting whenever it leaves
+// the band or the target changes. The coil is at temperature once that reaches dwell, which emits a reached event
+// once per target.
+func (c *Coil) trackBand(now time.Time) {
+	target := c.pid.Get()
+	if c.Temp < target-c.tolerance || c.Temp > target+c.tolerance {
+		c.inBandSince = time.Time{}
+		c.timeInBand = 0
+		return
+	}
+	if c.inBandSince.IsZero() {
+		c.inBandSince = now
+	}
+	c.timeInBand = now.Sub(c.inBandSince)
+	if c.timeInBand >= c.dwell && !c.reached && target != 0 {
+		c.reached = true
+		c.infoLog.Printf("reached target %.2ff\n", target)
+		c.emit(EventReached, "")
+	}
+}
@@ -0,0 +1,51 @@
+package coil
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// FrameChan is a single-slot channel that always holds the most recent frame.
+// Sending never blocks, whether or not anything is reading: a frame that was not read before the next one arrives
+// is replaced and counted as dropped. Frames are published from their own goroutines, so several may send at once;
+// a frame older than one already sent is dropped rather than let it replace a newer one.
+type FrameChan struct {
+	dropped uint64 // accessed atomically
+	c       chan CoilFrame
+
+	// mu serializes senders, so the slot is always free by the time one sends into it
+	mu      sync.Mutex
+	lastSeq uint64
+}
+
+func NewFrameChan() *FrameChan {
+	return &FrameChan{c: make(chan CoilFrame, 1)}
+}
+
+// Send replaces any unread frame with frame.
+func (f *FrameChan) Send(frame CoilFrame) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if frame.Seq < f.lastSeq {
+		atomic.AddUint64(&f.dropped, 1)
+		return
+	}
+	f.lastSeq = frame.Seq
+	select {
+	case <-f.c:
+		atomic.AddUint64(&f.dropped, 1)
+	default:
+	}
+	// Only senders fill the slot and they hold mu, so this can't block
+	f.c <- frame
+}
+
+// C returns the channel frames are received on.
+func (f *FrameChan) C() <-chan CoilFrame {
+	return f.c
+}
+
+// Dropped returns how many frames were replaced before being read.
+func (f *FrameChan) Dropped() uint64 {
+	return atomic.LoadUint64(&f.dropped)
+}
@@ -0,0 +1,59 @@
+package coil
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestFrameChanCountsDrops(t *testing.T) {
+	f := NewFrameChan()
+	for seq := uint64(1); seq <= 5; seq++ {
+		f.Send(CoilFrame{Seq: seq})
+	}
+	if got := f.Dropped(); got != 4 {
+		t.Errorf("Dropped() = %d after five unread sends, want 4", got)
+	}
+	if frame := <-f.C(); frame.Seq != 5 {
+		t.Errorf("read seq %d, want the newest, 5", frame.Seq)
+	}
+	f.Send(CoilFrame{Seq: 4})
+	if got := f.Dropped(); got != 5 {
+		t.Errorf("Dropped() = %d after a stale send, want 5", got)
+	}
+	select {
+	case frame := <-f.C():
+		t.Errorf("a stale send delivered seq %d", frame.Seq)
+	default:
+	}
+}
+
+func BenchmarkFrameChanDrops(b *testing.B) {
+	f := NewFrameChan()
+	var seq, received uint64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for frame := range f.C() {
+			if frame.Terminated {
+				return
+			}
+			atomic.AddUint64(&received, 1)
+		}
+	}()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			f.Send(CoilFrame{Seq: atomic.AddUint64(&seq, 1)})
+		}
+	})
+	b.StopTimer()
+	// Seq is past every frame sent, so this can't be dropped as stale; it can only replace one left unread
+	f.Send(CoilFrame{Seq: atomic.AddUint64(&seq, 1), Terminated: true})
+	<-done
+	sent := uint64(b.N) + 1
+	if got := atomic.LoadUint64(&received) + 1 + f.Dropped(); got != sent {
+		b.Errorf("received plus dropped = %d, want the %d sent", got, sent)
+	}
+	b.ReportMetric(float64(f.Dropped())/float64(sent), "drops/op")
+}
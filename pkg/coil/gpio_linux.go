@@ -0,0 +1,84 @@
+//go:build linux
+// +build linux
+
+package coil
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strconv"
+
+	"periph.io/x/conn/v3/driver/driverreg"
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpioreg"
+	"periph.io/x/host/v3/bcm283x"
+)
+
+// gpioPins is how many GPIO pins the BCM2835, BCM2836, BCM2837 and BCM2711 have, i.e. every Raspberry Pi up to the 4.
+const gpioPins = 54
+
+// gpioStatus drives the element from a GPIO pin through periph.io's bcm283x driver, which writes the GPIO registers
+// /dev/gpiomem maps, so the pin doesn't have to be exported through sysfs first. periph.io is used rather than
+// go-rpio because it checks the SoC before touching any registers, its pin calls return errors, and only its
+// bcm283x driver need be linked in. It stays on v3.7, as later releases need a newer go than go.mod's 1.14.
+type gpioStatus struct {
+	pin    gpio.PinIO
+	invert bool
+}
+
+// unsupportedGPIO is in /proc/device-tree/compatible on boards whose GPIO registers aren't the BCM2835's.
+// The Pi 5's BCM2712 hands its GPIO to the RP1 chip, which the bcm283x driver can't drive; use the file backend.
+var unsupportedGPIO = []string{"brcm,bcm2712"}
+
+// newGPIOStatus makes PI_HEATER_STATUS_GPIO_PIN, a BCM pin number, an output.
+// PI_HEATER_INVERT_OUTPUT drives the pin low to turn the coil on.
+// The pin's level is set to off before it becomes an output, so an active-low relay isn't energised in between.
+func newGPIOStatus() (*gpioStatus, error) {
+	pin, err := envInt("PI_HEATER_STATUS_GPIO_PIN", -1)
+	if err != nil {
+		return nil, err
+	}
+	if pin < 0 || pin >= gpioPins {
+		return nil, errors.New("PI_HEATER_STATUS_GPIO_PIN must be a BCM pin number from 0 to " + strconv.Itoa(gpioPins-1))
+	}
+	invert, err := envBool("PI_HEATER_INVERT_OUTPUT")
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a device tree the board can't be told apart, and the driver fails to load if it isn't a Pi
+	if compatible, err := ioutil.ReadFile("/proc/device-tree/compatible"); err == nil {
+		for _, soc := range unsupportedGPIO {
+			if bytes.Contains(compatible, []byte(soc)) {
+				return nil, errors.New("PI_HEATER_STATUS_BACKEND gpio doesn't support the " + soc + "; use the file backend")
+			}
+		}
+	}
+
+	if _, err := driverreg.Init(); err != nil {
+		return nil, errors.New("error while loading the GPIO driver: " + err.Error())
+	}
+	if !bcm283x.Present() {
+		return nil, errors.New("PI_HEATER_STATUS_BACKEND gpio needs a Raspberry Pi up to the 4; use the file backend")
+	}
+	p := gpioreg.ByName("GPIO" + strconv.Itoa(pin))
+	if p == nil {
+		return nil, errors.New("error while opening GPIO pin " + strconv.Itoa(pin) + ": the GPIO driver didn't register it")
+	}
+	s := &gpioStatus{pin: p, invert: invert}
+	// Out sets the level before switching the pin to an output
+	if err := s.SetStatus(false); err != nil {
+		return nil, errors.New("error while making GPIO pin " + strconv.Itoa(pin) + " an output: " + err.Error())
+	}
+	return s, nil
+}
+
+func (s *gpioStatus) SetStatus(on bool) error {
+	return s.pin.Out(gpio.Level(on != s.invert))
+}
+
+// Status reads back whether the pin's level has the coil on.
+func (s *gpioStatus) Status() (bool, error) {
+	return bool(s.pin.Read()) != s.invert, nil
+}
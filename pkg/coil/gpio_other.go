@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package coil
+
+import "errors"
+
+var errNoGPIO = errors.New("PI_HEATER_STATUS_BACKEND gpio is only supported on Linux")
+
+// gpioStatus is only implemented on Linux, where /dev/gpiomem maps the GPIO registers.
+type gpioStatus struct{}
+
+func newGPIOStatus() (*gpioStatus, error) {
+	return nil, errNoGPIO
+}
+
+func (s *gpioStatus) SetStatus(on bool) error {
+	return errNoGPIO
+}
+
+func (s *gpioStatus) Status() (bool, error) {
+	return false, errNoGPIO
+}
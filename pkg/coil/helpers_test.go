@@ -0,0 +1,192 @@
+package coil
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testEnv is the least configuration NewCoil needs; it simulates the kiln so no device files are opened.
+var testEnv = map[string]string{
+	"PI_HEATER_SIMULATE": "1",
+	"PI_HEATER_PID_P":    "5",
+	"PI_HEATER_PID_I":    "0.1",
+	"PI_HEATER_PID_D":    "1",
+	"PI_HEATER_PID_MAX":  "1000",
+}
+
+// testEpoch is where every test's MockClock starts.
+var testEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// setEnv replaces every PI_HEATER_ variable with testEnv overlaid with env, restoring them when t ends.
+func setEnv(t testing.TB, env map[string]string) {
+	var saved []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "PI_HEATER_") {
+			saved = append(saved, kv)
+			os.Unsetenv(kv[:strings.Index(kv, "=")])
+		}
+	}
+	t.Cleanup(func() {
+		for _, kv := range os.Environ() {
+			if strings.HasPrefix(kv, "PI_HEATER_") {
+				os.Unsetenv(kv[:strings.Index(kv, "=")])
+			}
+		}
+		for _, kv := range saved {
+			i := strings.Index(kv, "=")
+			os.Setenv(kv[:i], kv[i+1:])
+		}
+	})
+	for k, v := range testEnv {
+		os.Setenv(k, v)
+	}
+	for k, v := range env {
+		if v == "" {
+			os.Unsetenv(k)
+			continue
+		}
+		os.Setenv(k, v)
+	}
+}
+
+// newTestCoil configures a coil from testEnv and env, driven by a MockClock that the simulator follows too.
+func newTestCoil(t testing.TB, env map[string]string) (*Coil, *MockClock) {
+	t.Helper()
+	setEnv(t, env)
+	c, err := NewCoil(nil, nil)
+	if err != nil {
+		t.Fatalf("NewCoil: %s", err)
+	}
+	clock := NewMockClock(testEpoch)
+	c.Clock = clock
+	if c.sim != nil {
+		c.sim.Clock = clock
+		c.sim.last = clock.Now()
+	}
+	return c, clock
+}
+
+// startCoil runs c's loop until t ends, returning once the loop's ticker is waiting on clock.
+func startCoil(t testing.TB, c *Coil, clock *MockClock) {
+	t.Helper()
+	go c.Run()
+	t.Cleanup(func() {
+		select {
+		case c.Stop <- struct{}{}:
+		case <-c.Done():
+		}
+		<-c.Done()
+	})
+	waitFor(t, "the run loop's ticker", func() bool { return clock.pending() > 0 })
+}
+
+// pending returns how many timers are waiting on m.
+func (m *MockClock) pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.timers)
+}
+
+// step advances clock through one of c's windows and returns the frame the window published.
+func step(t testing.TB, c *Coil, clock *MockClock) CoilFrame {
+	t.Helper()
+	clock.Advance(c.window)
+	return nextFrame(t, c)
+}
+
+// nextFrame waits for the next frame c publishes.
+func nextFrame(t testing.TB, c *Coil) CoilFrame {
+	t.Helper()
+	select {
+	case frame := <-c.CurrentFrameChan.C():
+		return frame
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a frame")
+	}
+	return CoilFrame{}
+}
+
+// tempDir is a directory removed when t ends.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "pi-heater")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// waitFor polls cond until it holds, failing t if it doesn't within a few seconds.
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// scriptedReader is a TempReader returning temps in order, in InternalUnit, and then the last of them for good.
+// A NaN is a blank read.
+type scriptedReader struct {
+	mu    sync.Mutex
+	temps []float64
+	reads int
+}
+
+func newScriptedReader(temps ...float64) *scriptedReader {
+	return &scriptedReader{temps: temps}
+}
+
+func (r *scriptedReader) ReadTemp() (float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	temp := r.temps[len(r.temps)-1]
+	if r.reads < len(r.temps) {
+		temp = r.temps[r.reads]
+	}
+	r.reads++
+	if math.IsNaN(temp) {
+		return 0, ErrBadRead
+	}
+	return Celsius.FromInternal(temp) / DefaultCalibration.Resolution, nil
+}
+
+// recordingStatus is a StatusWriter that remembers every status it was set to.
+type recordingStatus struct {
+	mu     sync.Mutex
+	writes []bool
+}
+
+func (s *recordingStatus) SetStatus(on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes = append(s.writes, on)
+	return nil
+}
+
+// on reports whether the status was last set on.
+func (s *recordingStatus) on() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.writes) > 0 && s.writes[len(s.writes)-1]
+}
+
+// everOn reports whether the status was ever set on.
+func (s *recordingStatus) everOn() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, on := range s.writes {
+		if on {
+			return true
+		}
+	}
+	return false
+}
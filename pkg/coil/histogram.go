@@ -0,0 +1,55 @@
+package coil
+
+import "sync"
+
+// Histogram counts observations into buckets by upper bound; it is safe for concurrent use.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	sum    float64
+	total  uint64
+	max    float64
+}
+
+// NewHistogram returns a histogram with the given ascending bucket upper bounds.
+func NewHistogram(bounds ...float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.total++
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// Stats returns how many observations there have been, their sum and the largest of them.
+func (h *Histogram) Stats() (total uint64, sum, max float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.total, h.sum, h.max
+}
+
+// Snapshot returns the bucket bounds, the cumulative count of observations at or below each bound,
+// and the sum and count of every observation.
+func (h *Histogram) Snapshot() (bounds []float64, cumulative []uint64, sum float64, total uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cumulative = make([]uint64, len(h.counts))
+	var running uint64
+	for i, count := range h.counts {
+		running += count
+		cumulative[i] = running
+	}
+	return h.bounds, cumulative, h.sum, h.total
+}
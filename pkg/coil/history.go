@@ -0,0 +1,97 @@
+package coil
+
+import (
+	"sync"
+	"time"
+)
+
+// History is a fixed size ring buffer of the most recent frames; it is safe for concurrent use.
+type History struct {
+	mu     sync.RWMutex
+	frames []CoilFrame
+	next   int
+	full   bool
+}
+
+func NewHistory(size int) *History {
+	if size < 1 {
+		size = 1
+	}
+	return &History{frames: make([]CoilFrame, size)}
+}
+
+func (h *History) Add(frame CoilFrame) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.frames[h.next] = frame
+	h.next = (h.next + 1) % len(h.frames)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Last returns up to the n most recent frames, oldest first.
+func (h *History) Last(n int) []CoilFrame {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	count := h.next
+	if h.full {
+		count = len(h.frames)
+	}
+	if n > count || n < 0 {
+		n = count
+	}
+	frames := make([]CoilFrame, n)
+	start := h.next - n
+	if start < 0 {
+		start += len(h.frames)
+	}
+	for i := range frames {
+		frames[i] = h.frames[(start+i)%len(h.frames)]
+	}
+	return frames
+}
+
+// Since returns the frames that started after t, oldest first.
+func (h *History) Since(t time.Time) []CoilFrame {
+	frames := h.Last(-1)
+	for i, frame := range frames {
+		if frame.FrameStart.After(t) {
+			return frames[i:]
+		}
+	}
+	return nil
+}
+
+// slopeWindow is how many recent frames the heating rate is estimated from.
+const slopeWindow = 10
+
+// timeToTarget extrapolates the recent heating rate to estimate how many seconds until temp reaches target.
+// It returns nil when there's too little history or the temperature is flat or moving away from the target.
+func (h *History) timeToTarget(now time.Time, temp, target float64) *float64 {
+	frames := h.Last(slopeWindow)
+	if len(frames) < 2 {
+		return nil
+	}
+	// Least squares fit of temperature against seconds before now
+	var sumX, sumY, sumXY, sumXX float64
+	for _, frame := range frames {
+		x := frame.FrameStart.Sub(now).Seconds()
+		sumX += x
+		sumY += frame.Temp
+		sumXY += x * frame.Temp
+		sumXX += x * x
+	}
+	n := float64(len(frames))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return nil
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	remaining := target - temp
+	if slope == 0 || remaining/slope <= 0 {
+		return nil
+	}
+	eta := remaining / slope
+	return &eta
+}
@@ -0,0 +1,53 @@
+package coil
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// parseTempLimits reads PI_HEATER_TEMP_FLOOR and PI_HEATER_TEMP_CEILING, the lowest and highest readings that are
+// physically plausible for the kiln, in InternalUnit. Each is unbounded when unset.
+func (c *Coil) parseTempLimits() error {
+	var err error
+	c.tempFloor, err = envFloat("PI_HEATER_TEMP_FLOOR", math.Inf(-1))
+	if err != nil {
+		return err
+	}
+	c.tempCeiling, err = envFloat("PI_HEATER_TEMP_CEILING", math.Inf(1))
+	if err != nil {
+		return err
+	}
+	if c.tempFloor >= c.tempCeiling {
+		return errors.New("PI_HEATER_TEMP_FLOOR must be less than PI_HEATER_TEMP_CEILING")
+	}
+	if c.maxTarget != 0 && c.maxTarget >= c.tempCeiling {
+		return errors.New("PI_HEATER_MAX_TARGET must be less than PI_HEATER_TEMP_CEILING")
+	}
+	return nil
+}
+
+// checkTempLimits rejects a reading outside the floor and ceiling. Such a reading is almost certainly a sensor fault,
+// and one reading low would otherwise have the controller fire flat out to warm it up.
+func (c *Coil) checkTempLimits(name string, temp float64) error {
+	switch {
+	case temp < c.tempFloor:
+		return fmt.Errorf("%s read %.2ff, below PI_HEATER_TEMP_FLOOR %.2ff", name, temp, c.tempFloor)
+	case temp > c.tempCeiling:
+		return fmt.Errorf("%s read %.2ff, above PI_HEATER_TEMP_CEILING %.2ff", name, temp, c.tempCeiling)
+	}
+	return nil
+}
+
+// tempLimits returns the floor and ceiling for Config, nil where unbounded.
+func (c *Coil) tempLimits() (floor, ceiling *float64) {
+	if !math.IsInf(c.tempFloor, -1) {
+		f := c.tempFloor
+		floor = &f
+	}
+	if !math.IsInf(c.tempCeiling, 1) {
+		f := c.tempCeiling
+		ceiling = &f
+	}
+	return floor, ceiling
+}
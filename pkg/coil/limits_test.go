@@ -0,0 +1,76 @@
+package coil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTempLimitsLatchFault(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		env     map[string]string
+		reading float64
+		want    string
+	}{
+		{"floor", map[string]string{"PI_HEATER_TEMP_FLOOR": "0"}, -50, "below PI_HEATER_TEMP_FLOOR"},
+		{"ceiling", map[string]string{"PI_HEATER_TEMP_CEILING": "2000"}, 5000, "above PI_HEATER_TEMP_CEILING"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, clock := newTestCoil(t, tc.env)
+			c.temp = newScriptedReader(150, tc.reading, 150)
+			status := &recordingStatus{}
+			c.status = status
+			startCoil(t, c, clock)
+			c.SetTarget <- TargetCommand{Target: 300, Source: "test"}
+
+			if frame := step(t, c, clock); frame.Fault != "" || frame.FireTime == 0 {
+				t.Fatalf("first window: fault %q, fired for %dms; want a fault free pulse", frame.Fault, frame.FireTime)
+			}
+			frame := step(t, c, clock)
+			if !strings.Contains(frame.Fault, tc.want) {
+				t.Errorf("fault = %q, want one %s", frame.Fault, tc.want)
+			}
+			if frame.FireTime != 0 || status.on() {
+				t.Errorf("still firing for %dms after the implausible reading", frame.FireTime)
+			}
+			if frame.Temp != 150 {
+				t.Errorf("temp = %.2f, want the last plausible reading, 150", frame.Temp)
+			}
+			// The fault stays latched once readings are plausible again, until it is cleared
+			if frame := step(t, c, clock); frame.Fault == "" || frame.FireTime != 0 {
+				t.Errorf("fault %q and a %dms pulse once readings recover, want it still latched", frame.Fault, frame.FireTime)
+			}
+		})
+	}
+}
+
+func TestImplausibleFirstReadNeverFires(t *testing.T) {
+	c, clock := newTestCoil(t, map[string]string{"PI_HEATER_TEMP_FLOOR": "0"})
+	c.temp = newScriptedReader(-40)
+	status := &recordingStatus{}
+	c.status = status
+	startCoil(t, c, clock)
+	c.SetTarget <- TargetCommand{Target: 300, Source: "test"}
+
+	frame := step(t, c, clock)
+	if !strings.Contains(frame.Fault, "below PI_HEATER_TEMP_FLOOR") {
+		t.Errorf("fault = %q, want one below the floor", frame.Fault)
+	}
+	if status.everOn() || c.Ready() {
+		t.Error("coil fired or became ready on an implausible first reading")
+	}
+}
+
+func TestTempLimitsValidation(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"PI_HEATER_TEMP_FLOOR": "100", "PI_HEATER_TEMP_CEILING": "100"},
+		{"PI_HEATER_TEMP_FLOOR": "200", "PI_HEATER_TEMP_CEILING": "100"},
+		{"PI_HEATER_TEMP_CEILING": "1000", "PI_HEATER_MAX_TARGET": "1000"},
+		{"PI_HEATER_TEMP_FLOOR": "cold"},
+	} {
+		setEnv(t, env)
+		if _, err := NewCoil(nil, nil); err == nil {
+			t.Errorf("NewCoil accepted %v", env)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package coil
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics is a snapshot of the coil's counters and gauges, the in-process equivalent of GET /metrics.
+// It does not update after Metrics returns it.
+type Metrics struct {
+	Windows       uint64        // control windows run
+	TotalFireTime time.Duration // across every window
+	Faults        uint64        // faults latched
+	WriteFailures uint64        // failed writes to the status device
+	DroppedFrames uint64        // frames replaced before the hub read them
+	DutyCycle     float64       // percent of the most recent window spent firing
+	JitterCount   uint64        // windows measured for jitter
+	JitterMean    time.Duration
+	JitterMax     time.Duration
+	LastSeq       uint64 // Seq of the most recent frame
+	// SinceLastRead is how long ago the last good thermocouple reading was taken; negative until there has been one.
+	SinceLastRead time.Duration
+	// MaxOvershoot is the overshoot measured since the latest target, in degrees; NaN until it is measured.
+	MaxOvershoot float64
+}
+
+// counters are updated by the run loop and read by Metrics; every field is accessed atomically.
+type counters struct {
+	windows   uint64
+	fireTime  int64 // nanoseconds
+	faults    uint64
+	duty      uint64 // math.Float64bits of the latest duty cycle
+	seq       uint64 // Seq of the latest frame
+	overshoot uint64 // math.Float64bits of the latest target's overshoot
+	lastRead  int64  // UnixNano, on the coil's clock, of the last good reading; 0 until there has been one
+}
+
+// Metrics returns a snapshot of the coil's metrics; it is safe to call from any goroutine and doesn't allocate.
+func (c *Coil) Metrics() Metrics {
+	count, sum, max := c.jitter.Stats()
+	m := Metrics{
+		Windows:       atomic.LoadUint64(&c.counters.windows),
+		TotalFireTime: time.Duration(atomic.LoadInt64(&c.counters.fireTime)),
+		Faults:        atomic.LoadUint64(&c.counters.faults),
+		WriteFailures: c.WriteFailures(),
+		DroppedFrames: c.CurrentFrameChan.Dropped(),
+		DutyCycle:     math.Float64frombits(atomic.LoadUint64(&c.counters.duty)),
+		JitterCount:   count,
+		LastSeq:       atomic.LoadUint64(&c.counters.seq),
+		JitterMax:     time.Duration(max * float64(time.Second)),
+		MaxOvershoot:  math.Float64frombits(atomic.LoadUint64(&c.counters.overshoot)),
+	}
+	m.SinceLastRead, _ = c.SinceLastRead()
+	if count > 0 {
+		m.JitterMean = time.Duration(sum / float64(count) * float64(time.Second))
+	}
+	return m
+}
+
+// SinceLastRead returns how long ago the last good thermocouple reading was taken, on the coil's clock,
+// and false with a negative duration if there hasn't been one. It keeps growing if the run loop wedges,
+// which frames, only built by the loop, can't show.
+func (c *Coil) SinceLastRead() (time.Duration, bool) {
+	last := atomic.LoadInt64(&c.counters.lastRead)
+	if last == 0 {
+		return -1, false
+	}
+	return c.Clock.Now().Sub(time.Unix(0, last)), true
+}
+
+// staleWindows is how many control windows the last good reading may age by when PI_HEATER_STALE_AFTER is unset.
+const staleWindows = 5
+
+// staleLimit is how old the last good reading may get before Stale, following window changes unless
+// PI_HEATER_STALE_AFTER fixes it.
+func (c *Coil) staleLimit() time.Duration {
+	if c.staleAfter > 0 {
+		return c.staleAfter
+	}
+	return staleWindows * c.window
+}
+
+// Stale reports whether the last good reading is older than Config.StaleAfter, or there hasn't been one yet.
+func (c *Coil) Stale() bool {
+	since, ok := c.SinceLastRead()
+	return !ok || since > time.Duration(c.Config().StaleAfter)*time.Millisecond
+}
+
+// countWindow records a window that energized the coil for fire.
+func (c *Coil) countWindow(fire time.Duration) {
+	atomic.AddUint64(&c.counters.windows, 1)
+	atomic.AddInt64(&c.counters.fireTime, int64(fire))
+	atomic.StoreUint64(&c.counters.duty, math.Float64bits(100*float64(fire)/float64(c.window)))
+}
@@ -0,0 +1,70 @@
+package coil
+
+import (
+	"testing"
+	"time"
+)
+
+// stallingReader is a TempReader that reads 150 until stall is closed, then blocks until release is.
+type stallingReader struct {
+	stall, release chan struct{}
+}
+
+func (r *stallingReader) ReadTemp() (float64, error) {
+	select {
+	case <-r.stall:
+		<-r.release
+	default:
+	}
+	return Celsius.FromInternal(150) / DefaultCalibration.Resolution, nil
+}
+
+func TestSinceLastReadGrowsWhileLoopStalls(t *testing.T) {
+	c, clock := newTestCoil(t, map[string]string{"PI_HEATER_STALE_AFTER": "3s"})
+	reader := &stallingReader{stall: make(chan struct{}), release: make(chan struct{})}
+	c.temp = reader
+	c.status = &recordingStatus{}
+
+	if since, ok := c.SinceLastRead(); ok || since >= 0 {
+		t.Errorf("SinceLastRead() = %s, %v before any reading, want negative and false", since, ok)
+	}
+	if !c.Stale() {
+		t.Error("not stale before any reading")
+	}
+
+	startCoil(t, c, clock)
+	step(t, c, clock)
+	if since, ok := c.SinceLastRead(); !ok || since != 0 {
+		t.Errorf("SinceLastRead() = %s, %v right after a reading, want 0 and true", since, ok)
+	}
+	if c.Stale() {
+		t.Error("stale right after a reading")
+	}
+
+	// Wedge the loop in its next read; the clock keeps going without it
+	close(reader.stall)
+	defer close(reader.release)
+	clock.Advance(c.window)
+	var last time.Duration
+	for i := 1; i <= 5; i++ {
+		clock.Advance(time.Second)
+		since, _ := c.SinceLastRead()
+		if since <= last {
+			t.Fatalf("SinceLastRead() = %s after the loop stalled, want it to keep growing past %s", since, last)
+		}
+		last = since
+		if want := since > 3*time.Second; c.Stale() != want {
+			t.Errorf("Stale() = %v %s after the last reading, want %v", !want, since, want)
+		}
+	}
+	if m := c.Metrics(); m.SinceLastRead != last {
+		t.Errorf("Metrics().SinceLastRead = %s, want %s", m.SinceLastRead, last)
+	}
+}
+
+func TestStaleLimitFollowsWindow(t *testing.T) {
+	c, _ := newTestCoil(t, nil)
+	if got, want := time.Duration(c.Config().StaleAfter)*time.Millisecond, staleWindows*c.window; got != want {
+		t.Errorf("StaleAfter = %s without PI_HEATER_STALE_AFTER, want %d windows, %s", got, staleWindows, want)
+	}
+}
@@ -0,0 +1,31 @@
+package coil
+
+import "log"
+
+// monitorStatus stands in front of the status device under PI_HEATER_MONITOR_ONLY, for commissioning: the run loop
+// computes fire times and reports them as usual, but only ever turns the element off. Turning it off still goes
+// through, so an element left on is still turned off.
+type monitorStatus struct {
+	next StatusWriter
+	log  *log.Logger
+	on   bool
+}
+
+func (s *monitorStatus) SetStatus(on bool) error {
+	if on && !s.on {
+		s.log.Printf("monitor only: leaving the coil off instead of turning it on\n")
+	}
+	s.on = on
+	if on {
+		return nil
+	}
+	return s.next.SetStatus(false)
+}
+
+// guardStatus returns s, or s behind a monitorStatus under PI_HEATER_MONITOR_ONLY.
+func (c *Coil) guardStatus(s StatusWriter) StatusWriter {
+	if !c.monitorOnly {
+		return s
+	}
+	return &monitorStatus{next: s, log: c.infoLog}
+}
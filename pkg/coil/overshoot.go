@@ -0,0 +1,38 @@
+package coil
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// trackOvershoot follows the peak temperature once the coil first comes within tolerance of a new target, and
+// measures the overshoot as how far the peak went above the target once the temperature settles: when it is back
+// within tolerance after peaking above it, or at temperature if it never left. Only the run loop may call it.
+func (c *Coil) trackOvershoot() {
+	target := c.pid.Get()
+	if c.overshoot != nil || target <= c.ambient {
+		return
+	}
+	if !c.peakArmed {
+		if c.inBandSince.IsZero() {
+			return
+		}
+		c.peakArmed = true
+		c.peak = c.Temp
+	}
+	c.peak = math.Max(c.peak, c.Temp)
+	if !c.reached && (c.peak <= target+c.tolerance || c.inBandSince.IsZero()) {
+		return
+	}
+	overshoot := math.Max(0, c.peak-target)
+	c.overshoot = &overshoot
+	atomic.StoreUint64(&c.counters.overshoot, math.Float64bits(overshoot))
+	c.infoLog.Printf("settled at target %.2ff after peaking at %.2ff, %.2ff over\n", target, c.peak, overshoot)
+}
+
+// resetOvershoot starts measuring the overshoot afresh for a new target.
+func (c *Coil) resetOvershoot() {
+	c.peakArmed = false
+	c.overshoot = nil
+	atomic.StoreUint64(&c.counters.overshoot, math.Float64bits(math.NaN()))
+}
@@ -0,0 +1,46 @@
+package coil
+
+// Phases the coil can be in, reported in each frame.
+const (
+	// PhaseWarmup is while the thermocouple's first readings settle, before the spike detector arms.
+	PhaseWarmup = "warmup"
+	// PhaseRamping is heating towards a target the coil has not reached yet.
+	PhaseRamping = "ramping"
+	// PhaseHolding is regulating around a target the coil has reached.
+	PhaseHolding = "holding"
+	// PhaseIdle is when there is no target above ambient to heat towards.
+	PhaseIdle = "idle"
+	// PhasePaused is after the idle timeout dropped the target, until a new one is set.
+	PhasePaused = "paused"
+	// PhaseFaulted is while a fault is latched.
+	PhaseFaulted = "faulted"
+	// PhaseDisabled is while the element is forced off, whatever the target; see TargetCommand.Off.
+	PhaseDisabled = "disabled"
+)
+
+// updatePhase works out the coil's phase from the loop's state, emitting a phase event when it changes.
+func (c *Coil) updatePhase() string {
+	var phase string
+	switch {
+	case c.fault != "":
+		phase = PhaseFaulted
+	case c.disabled:
+		phase = PhaseDisabled
+	case c.warmReads < c.spikeWarmup:
+		phase = PhaseWarmup
+	case c.idled:
+		phase = PhasePaused
+	case c.pid.Get() <= c.ambient:
+		phase = PhaseIdle
+	case c.reached:
+		phase = PhaseHolding
+	default:
+		phase = PhaseRamping
+	}
+	if phase != c.phase {
+		c.infoLog.Printf("coil phase changed from %s to %s\n", c.phase, phase)
+		c.phase = phase
+		c.emit(EventPhase, "")
+	}
+	return phase
+}
@@ -0,0 +1,76 @@
+package coil
+
+import (
+	"reflect"
+	"testing"
+)
+
+// hold makes r read temp from now on.
+func (r *scriptedReader) hold(temp float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.temps, r.reads = []float64{temp}, 0
+}
+
+func TestPhaseTransitions(t *testing.T) {
+	c, clock := newTestCoil(t, map[string]string{
+		"PI_HEATER_SPIKE_WARMUP":      "2",
+		"PI_HEATER_REACHED_DWELL":     "2s",
+		"PI_HEATER_REACHED_TOLERANCE": "5",
+		"PI_HEATER_IDLE_TIMEOUT":      "30s",
+	})
+	reader := newScriptedReader(100)
+	c.temp = reader
+	events, unsubscribe := c.Subscribe()
+	defer unsubscribe()
+	startCoil(t, c, clock)
+
+	// stepUntil runs windows until a frame reports phase
+	stepUntil := func(phase string) {
+		t.Helper()
+		for i := 0; i < 40; i++ {
+			if frame := step(t, c, clock); frame.Phase == phase {
+				return
+			}
+		}
+		t.Fatalf("never reached %s", phase)
+	}
+	var want, emitted []string
+	for _, tc := range []struct {
+		phase  string
+		before func()
+	}{
+		{PhaseWarmup, nil},
+		{PhaseIdle, nil},
+		{PhaseRamping, func() { c.SetTarget <- TargetCommand{Target: 300, Source: "test"} }},
+		{PhaseHolding, func() { reader.hold(180); step(t, c, clock); reader.hold(260); step(t, c, clock); reader.hold(298) }},
+		{PhaseDisabled, func() { c.SetTarget <- TargetCommand{Off: true, Source: "test"} }},
+		// A new target starts the dwell over
+		{PhaseRamping, func() { c.SetTarget <- TargetCommand{Target: 300, Source: "test"} }},
+		{PhaseHolding, nil},
+		{PhaseFaulted, func() { c.EStop <- struct{}{} }},
+		{PhaseHolding, func() { c.Clear <- struct{}{} }},
+		// The idle timeout runs out 30s after the last target
+		{PhasePaused, nil},
+	} {
+		if tc.before != nil {
+			tc.before()
+		}
+		stepUntil(tc.phase)
+		want = append(want, tc.phase)
+		// Events are emitted before the frame is published, so the phase's are waiting by now
+		for drained := false; !drained; {
+			select {
+			case event := <-events:
+				if event.Type == EventPhase {
+					emitted = append(emitted, event.Phase)
+				}
+			default:
+				drained = true
+			}
+		}
+	}
+	if !reflect.DeepEqual(emitted, want) {
+		t.Errorf("phase events %v, want %v", emitted, want)
+	}
+}
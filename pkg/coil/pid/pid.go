@@ -0,0 +1,175 @@
+// Package pid provides the PID controllers that drive a coil's fire time.
+package pid
+
+import (
+	"errors"
+	"time"
+
+	"github.com/felixge/pidctrl"
+)
+
+// Terms is each term's contribution to a controller's most recent output, before clamping.
+type Terms struct {
+	P float64 `json:"p"`
+	I float64 `json:"i"`
+	D float64 `json:"d"`
+}
+
+// Controller is a PID controller whose output is clamped to its output limits.
+type Controller interface {
+	// Update feeds the controller a measurement taken dt after the previous one and returns its output.
+	Update(value float64, dt time.Duration) float64
+	Set(setpoint float64)
+	Get() float64
+	SetGains(p, i, d float64)
+	Gains() (p, i, d float64)
+	SetOutputLimits(min, max float64)
+	// Reset clears the accumulated integral and derivative state, keeping the setpoint and gains.
+	Reset()
+	Terms() Terms
+}
+
+// NewWithMode returns a controller whose derivative acts on mode: "measurement" or "error".
+func NewWithMode(mode string, p, i, d, min, max float64) (Controller, error) {
+	switch mode {
+	case "measurement":
+		return New(p, i, d, min, max), nil
+	case "error":
+		return NewDerivativeOnError(p, i, d, min, max), nil
+	}
+	return nil, errors.New("unknown PID derivative mode: " + mode)
+}
+
+// New returns a controller backed by github.com/felixge/pidctrl, which takes the derivative of the measurement.
+// That avoids the output kick when the setpoint changes.
+func New(p, i, d, min, max float64) Controller {
+	return &measurement{pid: pidctrl.NewPIDController(p, i, d).SetOutputLimits(min, max)}
+}
+
+type measurement struct {
+	pid *pidctrl.PIDController
+	// pidctrl keeps its state private, so mirror what Terms needs
+	integral  float64
+	prevValue float64
+	terms     Terms
+}
+
+func (c *measurement) Update(value float64, dt time.Duration) float64 {
+	p, i, d := c.pid.PID()
+	min, max := c.pid.OutputLimits()
+	seconds := dt.Seconds()
+	err := c.pid.Get() - value
+	c.integral = clamp(c.integral+err*seconds*i, min, max)
+	var deriv float64
+	if seconds > 0 {
+		deriv = -((value - c.prevValue) / seconds)
+	}
+	c.prevValue = value
+	c.terms = Terms{P: p * err, I: c.integral, D: d * deriv}
+	return c.pid.UpdateDuration(value, dt)
+}
+
+func (c *measurement) Set(setpoint float64) {
+	c.pid.Set(setpoint)
+}
+
+func (c *measurement) Get() float64 {
+	return c.pid.Get()
+}
+
+func (c *measurement) SetGains(p, i, d float64) {
+	c.pid.SetPID(p, i, d)
+}
+
+func (c *measurement) Gains() (float64, float64, float64) {
+	return c.pid.PID()
+}
+
+func (c *measurement) SetOutputLimits(min, max float64) {
+	c.pid.SetOutputLimits(min, max)
+	c.integral = clamp(c.integral, min, max)
+}
+
+func (c *measurement) Reset() {
+	p, i, d := c.pid.PID()
+	min, max := c.pid.OutputLimits()
+	c.pid = pidctrl.NewPIDController(p, i, d).SetOutputLimits(min, max).Set(c.pid.Get())
+	c.integral, c.prevValue = 0, 0
+	c.terms = Terms{}
+}
+
+func (c *measurement) Terms() Terms {
+	return c.terms
+}
+
+// NewDerivativeOnError returns a textbook controller taking the derivative of the error.
+// Its integral handling matches New.
+func NewDerivativeOnError(p, i, d, min, max float64) Controller {
+	return &onError{p: p, i: i, d: d, outMin: min, outMax: max}
+}
+
+type onError struct {
+	p, i, d        float64
+	setpoint       float64
+	prevErr        float64
+	hasPrev        bool
+	integral       float64
+	outMin, outMax float64
+	terms          Terms
+}
+
+func (c *onError) Update(value float64, duration time.Duration) float64 {
+	dt := duration.Seconds()
+	err := c.setpoint - value
+	c.integral = clamp(c.integral+err*dt*c.i, c.outMin, c.outMax)
+	var d float64
+	if dt > 0 && c.hasPrev {
+		d = (err - c.prevErr) / dt
+	}
+	c.prevErr = err
+	c.hasPrev = true
+	c.terms = Terms{P: c.p * err, I: c.integral, D: c.d * d}
+	return clamp(c.terms.P+c.terms.I+c.terms.D, c.outMin, c.outMax)
+}
+
+func (c *onError) Set(setpoint float64) {
+	c.setpoint = setpoint
+}
+
+func (c *onError) Get() float64 {
+	return c.setpoint
+}
+
+func (c *onError) SetGains(p, i, d float64) {
+	c.p, c.i, c.d = p, i, d
+}
+
+func (c *onError) Gains() (float64, float64, float64) {
+	return c.p, c.i, c.d
+}
+
+func (c *onError) SetOutputLimits(min, max float64) {
+	c.outMin, c.outMax = min, max
+	c.integral = clamp(c.integral, min, max)
+}
+
+func (c *onError) Reset() {
+	c.integral = 0
+	c.prevErr = 0
+	c.hasPrev = false
+	c.terms = Terms{}
+}
+
+func (c *onError) Terms() Terms {
+	return c.terms
+}
+
+func clamp(v, min, max float64) float64 {
+	if v > max {
+		return max
+	}
+	if v < min {
+		return min
+	}
+	return v
+}
@@ -0,0 +1,98 @@
+package coil
+
+import (
+	"errors"
+	"time"
+)
+
+// Preset is a target and set of gains applied together in a single step of the run loop,
+// so the controller never runs with a mix of old and new settings.
+// Fields left nil keep their current values.
+type Preset struct {
+	Target *float64 `json:"target,omitempty"`
+	Unit   Unit     `json:"unit,omitempty"` // of Target; defaults to InternalUnit
+	P      *float64 `json:"p,omitempty"`
+	I      *float64 `json:"i,omitempty"`
+	D      *float64 `json:"d,omitempty"`
+	Max    *int64   `json:"max,omitempty"` // milliseconds, as with PI_HEATER_PID_MAX
+	// Tolerance is the band either side of the target, in Unit, that counts as at temperature.
+	Tolerance *float64 `json:"tolerance,omitempty"`
+	// ResetIntegral clears the controller's integral once the preset is applied.
+	ResetIntegral bool `json:"reset_integral,omitempty"`
+}
+
+// PresetRequest asks the run loop to apply Preset.
+// The resulting configuration, or why the preset was rejected, is sent on Reply, which must be buffered.
+type PresetRequest struct {
+	Preset Preset
+	Reply  chan PresetResult
+}
+
+type PresetResult struct {
+	Config Config
+	Err    error
+}
+
+// ValidatePreset reports why p can't be applied, if it can't; nothing is applied from an invalid preset.
+func (c *Coil) ValidatePreset(p Preset) error {
+	unit, err := ParseUnit(string(p.Unit))
+	if err != nil {
+		return err
+	}
+	if p.Target != nil {
+		target := unit.ToInternal(*p.Target)
+		if target < c.minTarget || (c.maxTarget != 0 && target > c.maxTarget) {
+			return errors.New("target is outside of PI_HEATER_MIN_TARGET and PI_HEATER_MAX_TARGET")
+		}
+	}
+	for name, gain := range map[string]*float64{"p": p.P, "i": p.I, "d": p.D} {
+		if gain != nil && *gain < 0 {
+			return errors.New("gain " + name + " must not be negative")
+		}
+	}
+	if p.Tolerance != nil && *p.Tolerance < 0 {
+		return errors.New("tolerance must not be negative")
+	}
+	if p.Max != nil {
+		if err := c.ValidateWindow(*p.Max); err != nil {
+			return errors.New("invalid max: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// applyPreset applies a validated preset; it reports whether the window length changed.
+func (c *Coil) applyPreset(p Preset) bool {
+	if p.P != nil || p.I != nil || p.D != nil {
+		kp, ki, kd := c.pid.Gains()
+		if p.P != nil {
+			kp = *p.P
+		}
+		if p.I != nil {
+			ki = *p.I
+		}
+		if p.D != nil {
+			kd = *p.D
+		}
+		c.pid.SetGains(kp, ki, kd)
+	}
+	windowChanged := p.Max != nil && c.changeWindow(*p.Max)
+	if p.ResetIntegral {
+		c.pid.Reset()
+		c.lastPIDUpdate = time.Time{}
+		c.pidReset = true
+	}
+	unit, _ := ParseUnit(string(p.Unit))
+	if p.Tolerance != nil {
+		// A difference converts by scale alone, without the offset
+		c.tolerance = unit.ToInternal(*p.Tolerance) - unit.ToInternal(0)
+		c.inBandSince, c.timeInBand = time.Time{}, 0
+	}
+	if p.Target != nil {
+		c.enable("preset")
+		c.setTarget(unit.ToInternal(*p.Target))
+	}
+	c.refreshConfig()
+	c.infoLog.Printf("applied preset: %+v\n", c.Config())
+	return windowChanged
+}
@@ -0,0 +1,106 @@
+package coil
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestPublishNeverGoesBackwards(t *testing.T) {
+	c, _ := newTestCoil(t, nil)
+	const frames = 200
+	order := rand.Perm(frames)
+	var wg sync.WaitGroup
+	for _, i := range order {
+		wg.Add(1)
+		go func(seq uint64) {
+			defer wg.Done()
+			c.publish(CoilFrame{Seq: seq})
+		}(uint64(i + 1))
+	}
+	wg.Wait()
+
+	if seq := c.CurrentFrame().Seq; seq != frames {
+		t.Errorf("CurrentFrame().Seq = %d, want the newest, %d", seq, frames)
+	}
+	history := c.History.Last(frames)
+	for i := 1; i < len(history); i++ {
+		if history[i].Seq <= history[i-1].Seq {
+			t.Fatalf("history goes from seq %d back to %d", history[i-1].Seq, history[i].Seq)
+		}
+	}
+	if got := c.CurrentFrameChan.Dropped(); got != frames-1 {
+		t.Errorf("Dropped() = %d, want every frame but the one still unread, %d", got, frames-1)
+	}
+}
+
+func TestLoopRunsWithoutConsumer(t *testing.T) {
+	c, clock := newTestCoil(t, nil)
+	startCoil(t, c, clock)
+	// Nothing reads CurrentFrameChan, so every frame but the last is replaced unread
+	for i := 0; i < 20; i++ {
+		seq := c.CurrentFrame().Seq
+		waitFor(t, "another frame with nothing reading", func() bool {
+			if c.CurrentFrame().Seq > seq {
+				return true
+			}
+			// A tick is dropped if the loop is still busy with the last one, so keep ticking
+			clock.Advance(c.window)
+			return false
+		})
+	}
+	if c.CurrentFrameChan.Dropped() == 0 {
+		t.Error("no frames counted as dropped with nothing reading")
+	}
+}
+
+func TestSeqAcrossRunWithDrops(t *testing.T) {
+	c, clock := newTestCoil(t, nil)
+	startCoil(t, c, clock)
+	c.SetTarget <- TargetCommand{Target: 300, Source: "test"}
+
+	var received []CoilFrame
+	for i := 1; i <= 40; i++ {
+		seq := c.CurrentFrame().Seq
+		waitFor(t, "the next frame", func() bool {
+			if c.CurrentFrame().Seq > seq {
+				return true
+			}
+			clock.Advance(c.window)
+			return false
+		})
+		// Only read every fourth window, so the frames in between are dropped unread
+		if i%4 == 0 {
+			received = append(received, nextFrame(t, c))
+		}
+	}
+	last := c.CurrentFrame().Seq
+	for received[len(received)-1].Seq != last {
+		received = append(received, nextFrame(t, c))
+	}
+
+	for i := 1; i < len(received); i++ {
+		if received[i].Seq <= received[i-1].Seq {
+			t.Fatalf("frame %d has seq %d after %d", i, received[i].Seq, received[i-1].Seq)
+		}
+		if received[i].Timestamp.Before(received[i-1].Timestamp) {
+			t.Errorf("seq %d has a timestamp before seq %d's", received[i].Seq, received[i-1].Seq)
+		}
+	}
+	// Every gap in the received sequence is a frame counted as dropped
+	if dropped := c.CurrentFrameChan.Dropped(); dropped != last-uint64(len(received)) {
+		t.Errorf("Dropped() = %d, want the %d frames missing from the received sequence", dropped, last-uint64(len(received)))
+	}
+	if got := c.Metrics().LastSeq; got != last {
+		t.Errorf("Metrics().LastSeq = %d, want %d", got, last)
+	}
+	history := c.History.Last(int(last))
+	if uint64(len(history)) != last {
+		t.Fatalf("history has %d frames, want all %d", len(history), last)
+	}
+	for i, frame := range history {
+		if frame.Seq != uint64(i+1) {
+			t.Fatalf("history frame %d has seq %d, want every seq from 1 in order", i, frame.Seq)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package coil
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrReplayDone is returned by a replay once every recorded reading has been played back.
+var ErrReplayDone = errors.New("replay finished")
+
+// Replay is a TempReader that plays back a recorded temperature trace, so different controller settings can be
+// compared against the same thermal profile. Readings are picked by how long the replay has been running,
+// measured on Clock, relative to the first recorded timestamp.
+type Replay struct {
+	times []time.Time
+	temps []float64 // InternalUnit
+	Clock Clock
+	start time.Time
+	done  bool
+
+	// read receives a value after every reading when the replay paces a MockClock
+	read chan struct{}
+}
+
+// LoadReplay reads a CSV of timestamp,temp rows, with temperatures in the coil's internal unit.
+// Timestamps are RFC 3339 or Unix seconds; a header row is skipped.
+func LoadReplay(r io.Reader, clock Clock) (*Replay, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.New("error while reading replay: " + err.Error())
+	}
+	replay := &Replay{Clock: clock}
+	for n, row := range rows {
+		if len(row) < 2 {
+			return nil, errors.New("error while reading replay: row " + strconv.Itoa(n+1) + " needs a timestamp and a temperature")
+		}
+		temp, err := strconv.ParseFloat(strings.TrimSpace(row[1]), 64)
+		if err != nil && n == 0 {
+			continue
+		}
+		if err != nil {
+			return nil, errors.New("error while parsing replay temperature on row " + strconv.Itoa(n+1) + ": " + err.Error())
+		}
+		t, err := parseReplayTime(strings.TrimSpace(row[0]))
+		if err != nil {
+			return nil, errors.New("error while parsing replay timestamp on row " + strconv.Itoa(n+1) + ": " + err.Error())
+		}
+		if len(replay.times) > 0 && t.Before(replay.times[len(replay.times)-1]) {
+			return nil, errors.New("error while reading replay: row " + strconv.Itoa(n+1) + " is out of order")
+		}
+		replay.times = append(replay.times, t)
+		replay.temps = append(replay.temps, temp)
+	}
+	if len(replay.times) == 0 {
+		return nil, errors.New("error while reading replay: no readings")
+	}
+	return replay, nil
+}
+
+func parseReplayTime(s string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// Start is when the first recorded reading was taken.
+func (r *Replay) Start() time.Time {
+	return r.times[0]
+}
+
+// ReadTemp returns the latest recorded reading at the current point in the replay, in the sensor's raw scale.
+func (r *Replay) ReadTemp() (float64, error) {
+	if r.read != nil {
+		defer func() { r.read <- struct{}{} }()
+	}
+	now := r.Clock.Now()
+	if r.start.IsZero() {
+		r.start = now
+	}
+	at := r.times[0].Add(now.Sub(r.start))
+	if at.After(r.times[len(r.times)-1]) {
+		r.done = true
+		return 0, ErrReplayDone
+	}
+	i := 0
+	for i+1 < len(r.times) && !r.times[i+1].After(at) {
+		i++
+	}
+	return (r.temps[i] - 32.0) * 20.0 / 9.0, nil
+}
+
+// pace advances clock one window per reading until the replay finishes or done is closed,
+// replaying the trace as fast as the run loop can take it.
+func (r *Replay) pace(clock *MockClock, window time.Duration, done <-chan struct{}) {
+	for !r.done {
+		clock.Advance(window)
+		select {
+		case <-r.read:
+		case <-done:
+			return
+		}
+	}
+}
+
+// logStatus is a StatusWriter with no device behind it; it only logs when the coil would switch.
+type logStatus struct {
+	log *log.Logger
+	on  bool
+}
+
+func (s *logStatus) SetStatus(on bool) error {
+	if on != s.on {
+		s.log.Printf("coil would turn %s\n", map[bool]string{true: "on", false: "off"}[on])
+	}
+	s.on = on
+	return nil
+}
+
+func newReplay(file string, fast bool, infoLog *log.Logger) (*Replay, Clock, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, errors.New("error while opening PI_HEATER_REPLAY_FILE: " + err.Error())
+	}
+	defer f.Close()
+	var clock Clock = RealClock{}
+	replay, err := LoadReplay(f, clock)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fast {
+		// Frames carry the recorded timestamps and the replay runs as fast as the loop allows
+		clock = NewMockClock(replay.Start())
+		replay.Clock = clock
+		replay.read = make(chan struct{}, 1)
+	}
+	infoLog.Printf("replaying %d readings from %s\n", len(replay.times), file)
+	return replay, clock, nil
+}
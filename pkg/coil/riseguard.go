@@ -0,0 +1,56 @@
+package coil
+
+import (
+	"math"
+	"time"
+)
+
+// FaultNoHeat is latched when the rise guard sees the coil fire hard without the temperature rising,
+// e.g. because the element is open circuit or the door was left open.
+const FaultNoHeat = "failed to heat"
+
+// armRiseGuard starts watching for the temperature to rise towards a target above the current setpoint, from the
+// next valid reading, since the target can be set before the first one. Lowering the target disarms it.
+func (c *Coil) armRiseGuard(target float64) {
+	if c.riseTimeout == 0 {
+		return
+	}
+	if target <= c.pid.Get() || (c.warmReads > 0 && c.Temp >= target-c.tolerance) {
+		c.riseStart = time.Time{}
+		return
+	}
+	c.riseStart, c.riseFrom, c.riseFired = c.Clock.Now(), math.NaN(), 0
+}
+
+// checkRise faults if the temperature hasn't risen by minRise within riseTimeout of the guard arming, while the coil
+// fired for at least riseMinDuty of the time. If it fired less, e.g. held back by a soft start, the guard starts
+// over from the current temperature instead. The guard disarms once the temperature rises enough.
+func (c *Coil) checkRise(now time.Time, fire time.Duration) {
+	if c.riseStart.IsZero() || c.fault != "" {
+		return
+	}
+	if math.IsNaN(c.riseFrom) {
+		c.riseStart, c.riseFrom = now, c.Temp
+	}
+	if c.Temp-c.riseFrom >= c.minRise {
+		c.riseStart = time.Time{}
+		return
+	}
+	elapsed := now.Sub(c.riseStart)
+	if elapsed < c.riseTimeout {
+		// Only count fire time from windows that are over by the time the guard checks
+		c.riseFired += fire
+		return
+	}
+	duty := 100 * float64(c.riseFired) / float64(elapsed)
+	if duty < c.riseMinDuty {
+		c.infoLog.Printf("rose %.2ff in %s but only fired %.0f%% of the time; restarting the rise guard\n",
+			c.Temp-c.riseFrom, elapsed, duty)
+		c.riseStart, c.riseFrom, c.riseFired = now, c.Temp, fire
+		return
+	}
+	c.riseStart = time.Time{}
+	c.errLog.Printf("temperature rose %.2ff in %s firing %.0f%% of the time, less than PI_HEATER_MIN_RISE %.2ff\n",
+		c.Temp-c.riseFrom, elapsed, duty, c.minRise)
+	c.latchFault(FaultNoHeat)
+}
@@ -0,0 +1,50 @@
+package coil
+
+import (
+	"testing"
+)
+
+func TestRiseGuard(t *testing.T) {
+	env := map[string]string{"PI_HEATER_RISE_TIMEOUT": "10s", "PI_HEATER_MIN_RISE": "10"}
+	for _, tc := range []struct {
+		name     string
+		heatRate float64
+		fault    string
+	}{
+		{"dead element", 0, FaultNoHeat},
+		{"working element", 5, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, clock := newTestCoil(t, env)
+			c.sim.HeatRate = tc.heatRate
+			startCoil(t, c, clock)
+			c.SetTarget <- TargetCommand{Target: 1000, Source: "test"}
+
+			var frame CoilFrame
+			for i := 0; i < 15; i++ {
+				if frame = step(t, c, clock); frame.Fault != "" {
+					break
+				}
+			}
+			if frame.Fault != tc.fault {
+				t.Fatalf("fault = %q after %d windows at full duty, want %q", frame.Fault, frame.Seq, tc.fault)
+			}
+			if tc.fault != "" && frame.FireTime != 0 {
+				t.Errorf("still firing for %dms once the guard tripped", frame.FireTime)
+			}
+		})
+	}
+}
+
+func TestRiseGuardDisabledByDefault(t *testing.T) {
+	c, clock := newTestCoil(t, nil)
+	c.sim.HeatRate = 0
+	startCoil(t, c, clock)
+	c.SetTarget <- TargetCommand{Target: 1000, Source: "test"}
+
+	for i := 0; i < 30; i++ {
+		if frame := step(t, c, clock); frame.Fault != "" {
+			t.Fatalf("fault %q with PI_HEATER_RISE_TIMEOUT unset", frame.Fault)
+		}
+	}
+}
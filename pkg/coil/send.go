@@ -0,0 +1,23 @@
+package coil
+
+import (
+	"errors"
+	"reflect"
+)
+
+// ErrStopped is returned by Send once the run loop has returned, since nothing will receive the command.
+var ErrStopped = errors.New("coil run loop has stopped")
+
+// Send sends v on ch, one of the coil's command channels such as SetTarget, blocking until the run loop receives it.
+// If Run has returned, or returns first, Send gives up with ErrStopped rather than block forever.
+// A command the loop received has been handled by the time a reply it carries arrives.
+func (c *Coil) Send(ch, v interface{}) error {
+	chosen, _, _ := reflect.Select([]reflect.SelectCase{
+		{Dir: reflect.SelectSend, Chan: reflect.ValueOf(ch), Send: reflect.ValueOf(v)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.done)},
+	})
+	if chosen == 1 {
+		return ErrStopped
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package coil
+
+import (
+	"sync"
+	"time"
+)
+
+// Simulator is an in-memory stand-in for a kiln, implementing both TempReader and StatusWriter.
+// While the element is on the temperature rises at HeatRate; it always loses heat towards Ambient at CoolRate.
+type Simulator struct {
+	mu   sync.Mutex
+	temp float64 // InternalUnit
+	on   bool
+	last time.Time
+
+	Clock    Clock
+	Ambient  float64 // InternalUnit
+	HeatRate float64 // degrees per second while the element is on
+	CoolRate float64 // fraction of the difference to Ambient lost per second
+}
+
+func NewSimulator(clock Clock, ambient float64) *Simulator {
+	return &Simulator{
+		temp:     ambient,
+		last:     clock.Now(),
+		Clock:    clock,
+		Ambient:  ambient,
+		HeatRate: 5,
+		CoolRate: 0.002,
+	}
+}
+
+// step advances the model to the current time; callers must hold mu.
+func (s *Simulator) step() {
+	now := s.Clock.Now()
+	dt := now.Sub(s.last).Seconds()
+	s.last = now
+	if dt <= 0 {
+		return
+	}
+	if s.on {
+		s.temp += s.HeatRate * dt
+	}
+	s.temp -= (s.temp - s.Ambient) * s.CoolRate * dt
+}
+
+// ReadTemp returns the simulated temperature in the sensor's raw scale.
+func (s *Simulator) ReadTemp() (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.step()
+	return (s.temp - 32.0) * 20.0 / 9.0, nil
+}
+
+func (s *Simulator) SetStatus(on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.step()
+	s.on = on
+	return nil
+}
+
+// SetTemp overrides the simulated temperature; the model carries on from the new value.
+func (s *Simulator) SetTemp(temp float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.step()
+	s.temp = temp
+}
@@ -0,0 +1,29 @@
+package coil
+
+import "time"
+
+// startSoftStart arms the soft start if target is far enough above the current setpoint.
+func (c *Coil) startSoftStart(target float64) {
+	if c.softStartWindows == 0 || target-c.pid.Get() < c.softStartStep {
+		return
+	}
+	c.softStartLeft = c.softStartWindows
+	c.infoLog.Printf("soft starting: capping fire time over the next %d windows\n", c.softStartWindows)
+}
+
+// softStartCap limits fire to a cap that rises evenly to the longest allowed pulse over the soft start's windows.
+// It returns fire unchanged once the soft start is over.
+func (c *Coil) softStartCap(fire time.Duration) time.Duration {
+	c.dbg.SoftStartCap = 0
+	if c.softStartLeft == 0 {
+		return fire
+	}
+	window := c.softStartWindows - c.softStartLeft + 1
+	c.softStartLeft--
+	limit := time.Duration(c.maxFire*float64(window)/float64(c.softStartWindows)) * time.Millisecond
+	c.dbg.SoftStartCap = float64(limit.Milliseconds())
+	if fire > limit {
+		return limit
+	}
+	return fire
+}
@@ -0,0 +1,49 @@
+package coil
+
+import "testing"
+
+func TestSoftStartCapRises(t *testing.T) {
+	c, clock := newTestCoil(t, map[string]string{"PI_HEATER_SOFTSTART_WINDOWS": "4", "PI_HEATER_DEBUG": "1"})
+	c.temp = newScriptedReader(100)
+	c.status = &recordingStatus{}
+	startCoil(t, c, clock)
+	full := int64(c.maxFire)
+
+	// Far below the target the controller asks for the longest pulse, so each window fires for the cap
+	check := func(what string, windows int) {
+		t.Helper()
+		for i := 1; i <= windows; i++ {
+			frame := step(t, c, clock)
+			if want := full * int64(i) / int64(windows); frame.FireTime != want || int64(frame.Debug.SoftStartCap) != want {
+				t.Errorf("%s window %d: fired for %dms with a cap of %vms, want both %dms", what, i, frame.FireTime, frame.Debug.SoftStartCap, want)
+			}
+		}
+		frame := step(t, c, clock)
+		if frame.FireTime != full || frame.Debug.SoftStartCap != 0 {
+			t.Errorf("%s: fired for %dms with a cap of %vms after the soft start, want %dms uncapped", what, frame.FireTime, frame.Debug.SoftStartCap, full)
+		}
+	}
+	c.SetTarget <- TargetCommand{Target: 1000, Source: "test"}
+	check("first increase", 4)
+
+	// A nudge smaller than PI_HEATER_SOFTSTART_STEP doesn't start again
+	c.SetTarget <- TargetCommand{Target: 1020, Source: "test"}
+	if frame := step(t, c, clock); frame.FireTime != full || frame.Debug.SoftStartCap != 0 {
+		t.Errorf("fired for %dms with a cap of %vms after a small increase, want %dms uncapped", frame.FireTime, frame.Debug.SoftStartCap, full)
+	}
+
+	c.SetTarget <- TargetCommand{Target: 1100, Source: "test"}
+	check("second increase", 4)
+}
+
+func TestSoftStartDisabledByDefault(t *testing.T) {
+	c, clock := newTestCoil(t, map[string]string{"PI_HEATER_DEBUG": "1"})
+	c.temp = newScriptedReader(100)
+	c.status = &recordingStatus{}
+	startCoil(t, c, clock)
+	c.SetTarget <- TargetCommand{Target: 1000, Source: "test"}
+
+	if frame := step(t, c, clock); frame.FireTime != int64(c.maxFire) || frame.Debug.SoftStartCap != 0 {
+		t.Errorf("fired for %dms with a cap of %vms with PI_HEATER_SOFTSTART_WINDOWS unset, want %vms uncapped", frame.FireTime, frame.Debug.SoftStartCap, c.maxFire)
+	}
+}
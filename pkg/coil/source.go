@@ -0,0 +1,158 @@
+package coil
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TempReader is a source of raw thermocouple readings.
+// Raw values are in quarter degrees Celsius, which the coil converts to InternalUnit.
+type TempReader interface {
+	ReadTemp() (float64, error)
+}
+
+// DetailedTempReader is implemented by sources that report more than the thermocouple temperature,
+// e.g. amplifiers exposing a cold-junction temperature and fault bits.
+type DetailedTempReader interface {
+	TempReader
+	ReadDetailed() (Reading, error)
+}
+
+// Reading is a single sample from a DetailedTempReader.
+type Reading struct {
+	Raw             float64 // same scale as TempReader.ReadTemp
+	ColdJunction    float64 // degrees Celsius; only meaningful if HasColdJunction
+	HasColdJunction bool
+	Fault           SensorFault
+}
+
+// SensorFault is a set of fault bits reported by a thermocouple amplifier.
+type SensorFault uint8
+
+const (
+	FaultOpen     SensorFault = 1 << iota // thermocouple is disconnected
+	FaultShortGND                         // thermocouple is shorted to ground
+	FaultShortVCC                         // thermocouple is shorted to supply
+)
+
+func (f SensorFault) String() string {
+	var faults []string
+	if f&FaultOpen != 0 {
+		faults = append(faults, "open circuit")
+	}
+	if f&FaultShortGND != 0 {
+		faults = append(faults, "short to GND")
+	}
+	if f&FaultShortVCC != 0 {
+		faults = append(faults, "short to VCC")
+	}
+	return strings.Join(faults, ", ")
+}
+
+// newTempReader opens devfile as the kind of source named by kind.
+// Plain file readings are divided by divisor to get degrees Celsius; other kinds have a fixed scale and ignore it.
+func newTempReader(kind, devfile string, divisor float64) (TempReader, error) {
+	f, err := os.OpenFile(devfile, os.O_RDONLY, os.ModeDevice)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "", "file":
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &fileTempReader{
+			f:     f,
+			b:     make([]byte, 32),
+			scale: 4 / divisor,
+			// Sysfs attributes like hwmon's are regular files that read from the start again each time
+			rewind: info.Mode()&os.ModeCharDevice == 0,
+		}, nil
+	case "max31855":
+		return &max31855Reader{f: f, b: make([]byte, 4)}, nil
+	}
+	f.Close()
+	return nil, errors.New("unknown temperature source: " + kind)
+}
+
+// fileTempReader reads plain numeric readings from a device file.
+type fileTempReader struct {
+	f      *os.File
+	b      []byte
+	scale  float64 // converts a reading to quarter degrees
+	rewind bool
+}
+
+func (r *fileTempReader) ReadTemp() (float64, error) {
+	var n int
+	var err error
+	if r.rewind {
+		n, err = r.f.ReadAt(r.b, 0)
+		if err == io.EOF {
+			err = nil
+		}
+	} else {
+		n, err = r.f.Read(r.b)
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := parseReading(r.b[:n])
+	return v * r.scale, err
+}
+
+// numberPattern matches a signed decimal number, with an optional exponent.
+var numberPattern = regexp.MustCompile(`[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?`)
+
+// parseReading parses the first number in b, keeping its sign and decimal point and ignoring whatever surrounds it,
+// e.g. whitespace or a unit suffix like "-5.25 C".
+func parseReading(b []byte) (float64, error) {
+	token := numberPattern.Find(b)
+	if token == nil {
+		return 0, ErrBadRead
+	}
+	return strconv.ParseFloat(string(token), 64)
+}
+
+func (r *fileTempReader) Close() error {
+	return r.f.Close()
+}
+
+// max31855Reader reads the 32 bit frame of a MAX31855 thermocouple amplifier, e.g. from a spidev device.
+type max31855Reader struct {
+	f *os.File
+	b []byte
+}
+
+func (r *max31855Reader) ReadTemp() (float64, error) {
+	reading, err := r.ReadDetailed()
+	return reading.Raw, err
+}
+
+func (r *max31855Reader) ReadDetailed() (Reading, error) {
+	_, err := io.ReadFull(r.f, r.b)
+	if err != nil {
+		return Reading{}, err
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	return Reading{
+		// D31-D18: signed thermocouple temperature in quarter degrees
+		Raw: float64(int32(v) >> 18),
+		// D15-D4: signed cold-junction temperature in sixteenths of a degree
+		ColdJunction:    float64(int32(v<<16)>>20) * 0.0625,
+		HasColdJunction: true,
+		// D2-D0: short to VCC, short to GND, open circuit
+		Fault: SensorFault(v & 0x7),
+	}, nil
+}
+
+func (r *max31855Reader) Close() error {
+	return r.f.Close()
+}
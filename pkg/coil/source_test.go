@@ -0,0 +1,96 @@
+package coil
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestMillidegreeDeviceFile(t *testing.T) {
+	path := filepath.Join(tempDir(t), "temp1_input")
+	write := func(contents string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("23456\n")
+	r, err := newTempReader("file", path, 1000)
+	if err != nil {
+		t.Fatalf("newTempReader: %s", err)
+	}
+	defer r.(*fileTempReader).Close()
+
+	// A hwmon attribute is rewritten in place, and reads from the start again each time
+	for _, tc := range []struct {
+		contents string
+		celsius  float64
+	}{
+		{"23456\n", 23.456},
+		{"1000000\n", 1000},
+		{"-5250\n", -5.25},
+		{"0\n", 0},
+	} {
+		write(tc.contents)
+		raw, err := r.ReadTemp()
+		if err != nil {
+			t.Fatalf("reading %q: %s", tc.contents, err)
+		}
+		got := ConvertRaw(raw, DefaultCalibration)
+		if want := tc.celsius*9/5 + 32; math.Abs(got-want) > 1e-9 {
+			t.Errorf("%q millidegrees read as %vf, want %vf", tc.contents, got, want)
+		}
+	}
+}
+
+func TestDefaultDivisorIsQuarterDegrees(t *testing.T) {
+	path := filepath.Join(tempDir(t), "thermocouple")
+	if err := ioutil.WriteFile(path, []byte("400\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newTempReader("", path, 4)
+	if err != nil {
+		t.Fatalf("newTempReader: %s", err)
+	}
+	defer r.(*fileTempReader).Close()
+	raw, err := r.ReadTemp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ConvertRaw(raw, DefaultCalibration); got != 212 {
+		t.Errorf("400 quarter degrees read as %vf, want 212f", got)
+	}
+}
+
+func TestParseReading(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want float64
+	}{
+		{"400\n", 400},
+		{"-5.25\n", -5.25},
+		{"  -0.5  ", -0.5},
+		{".75", 0.75},
+		{"+12", 12},
+		{"-5.25 C\n", -5.25},
+		{"23.5°C", 23.5},
+		{"t=-1250", -1250},
+		{"1.5e2", 150},
+		{"-40", -40},
+	} {
+		got, err := parseReading([]byte(tc.in))
+		if err != nil {
+			t.Errorf("parseReading(%q): %s", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseReading(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+	for _, in := range []string{"", "\n", "   ", "C", "-", "."} {
+		if got, err := parseReading([]byte(in)); err != ErrBadRead {
+			t.Errorf("parseReading(%q) = %v, %v; want ErrBadRead", in, got, err)
+		}
+	}
+}
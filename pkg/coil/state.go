@@ -0,0 +1,93 @@
+package coil
+
+import (
+	"errors"
+	"time"
+)
+
+// State is the coil's runtime settings, as exported by GET /state to clone a configured coil onto another.
+// It leaves out live measurements; temperatures are in Unit.
+type State struct {
+	Target    float64 `json:"target"`
+	Unit      Unit    `json:"unit"`
+	P         float64 `json:"p"`
+	I         float64 `json:"i"`
+	D         float64 `json:"d"`
+	Max       int64   `json:"max"` // milliseconds, as with PI_HEATER_PID_MAX
+	Tolerance float64 `json:"tolerance"`
+	// BakeHold is the hold of the bake in progress, which starts over on restore, in milliseconds.
+	BakeHold int64 `json:"bake_hold,omitempty"`
+	// Fault is latched on restore; restoring a state without one leaves a latched fault to be cleared as usual.
+	Fault string `json:"fault,omitempty"`
+}
+
+// StateRequest asks the run loop to restore State in a single step.
+// The state once restored, or why it was rejected, is sent on Reply, which must be buffered.
+type StateRequest struct {
+	State State
+	Reply chan StateResult
+}
+
+type StateResult struct {
+	State State
+	Err   error
+}
+
+// ValidateState reports why s can't be restored, if it can't; nothing is restored from an invalid state.
+func (c *Coil) ValidateState(s State) error {
+	if s.Max == 0 {
+		return errors.New("max is required")
+	}
+	if s.BakeHold < 0 {
+		return errors.New("bake_hold must not be negative")
+	}
+	if s.BakeHold > 0 && s.Target <= 0 {
+		return errors.New("a bake needs a target")
+	}
+	return c.ValidatePreset(s.preset())
+}
+
+// preset is the part of s a preset can apply.
+func (s State) preset() Preset {
+	return Preset{
+		Target:    &s.Target,
+		Unit:      s.Unit,
+		P:         &s.P,
+		I:         &s.I,
+		D:         &s.D,
+		Max:       &s.Max,
+		Tolerance: &s.Tolerance,
+	}
+}
+
+// state snapshots the coil's runtime settings; only the run loop may call it.
+func (c *Coil) state() State {
+	p, i, d := c.pid.Gains()
+	s := State{
+		Target:    c.Target(),
+		Unit:      InternalUnit,
+		P:         p,
+		I:         i,
+		D:         d,
+		Max:       c.window.Milliseconds(),
+		Tolerance: c.tolerance,
+		Fault:     c.fault,
+	}
+	if c.bake != nil {
+		s.BakeHold = c.bake.hold.Milliseconds()
+	}
+	return s
+}
+
+// restoreState restores a validated state; it reports whether the window length changed.
+func (c *Coil) restoreState(s State) bool {
+	windowChanged := c.applyPreset(s.preset())
+	if s.BakeHold > 0 && c.rampStart.IsZero() {
+		c.bake = &bake{hold: time.Duration(s.BakeHold) * time.Millisecond}
+		c.infoLog.Printf("restored a bake holding for %s once within tolerance\n", c.bake.hold)
+	}
+	if s.Fault != "" {
+		c.latchFault(s.Fault)
+	}
+	return windowChanged
+}
@@ -0,0 +1,85 @@
+package coil
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// StatusWriter switches the coil's heating element on and off.
+type StatusWriter interface {
+	SetStatus(on bool) error
+}
+
+// readableStatus is a StatusWriter that can also read back whether the coil is on.
+type readableStatus interface {
+	StatusWriter
+	Status() (bool, error)
+}
+
+// fileStatus drives the element by writing to a status device file, e.g. a sysfs GPIO value.
+type fileStatus struct {
+	f *os.File
+	b []byte
+	// Bytes written to f to turn the coil on and off
+	onValue  []byte
+	offValue []byte
+}
+
+// newFileStatus opens devfile, configured by PI_HEATER_STATUS_ON, PI_HEATER_STATUS_OFF and PI_HEATER_INVERT_OUTPUT.
+func newFileStatus(devfile string) (*fileStatus, error) {
+	s := &fileStatus{onValue: []byte("1"), offValue: []byte("0")}
+	for _, v := range []struct {
+		name  string
+		value *[]byte
+	}{{"PI_HEATER_STATUS_ON", &s.onValue}, {"PI_HEATER_STATUS_OFF", &s.offValue}} {
+		if env, ok := os.LookupEnv(v.name); ok {
+			if env == "" {
+				return nil, errors.New(v.name + " must not be empty")
+			}
+			*v.value = []byte(env)
+		}
+	}
+	if string(s.onValue) == string(s.offValue) {
+		return nil, errors.New("PI_HEATER_STATUS_ON and PI_HEATER_STATUS_OFF must differ")
+	}
+	s.b = make([]byte, len(s.onValue)+len(s.offValue)+1)
+	invert, err := envBool("PI_HEATER_INVERT_OUTPUT")
+	if err != nil {
+		return nil, err
+	}
+	if invert {
+		// Active-low relays energize the coil on the off value
+		s.onValue, s.offValue = s.offValue, s.onValue
+	}
+
+	s.f, err = os.OpenFile(devfile, os.O_RDWR, os.ModeDevice)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileStatus) SetStatus(on bool) error {
+	b := s.offValue
+	if on {
+		b = s.onValue
+	}
+	_, err := s.f.Write(b)
+	return err
+}
+
+// Status reads back whether the device reports the coil on.
+func (s *fileStatus) Status() (bool, error) {
+	n, err := s.f.Read(s.b)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	s.f.Seek(0, io.SeekStart)
+	return strings.TrimSpace(string(s.b[:n])) == strings.TrimSpace(string(s.onValue)), nil
+}
+
+func (s *fileStatus) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,117 @@
+package coil
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// lastWrite returns the last byte written to a status file, where every write is a single byte.
+func lastWrite(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 {
+		return ""
+	}
+	return string(data[len(data)-1:])
+}
+
+func TestOffPathsWriteConfiguredPolarity(t *testing.T) {
+	paths := []struct {
+		name string
+		off  func(t *testing.T, c *Coil, clock *MockClock, reader *scriptedReader)
+	}{
+		{"end of pulse", func(t *testing.T, c *Coil, clock *MockClock, reader *scriptedReader) {
+			c.SetTarget <- TargetCommand{Target: 140, Source: "test"}
+			frame := step(t, c, clock)
+			if frame.FireTime == 0 || frame.FireTime >= frame.FrameDuration {
+				t.Fatalf("fired for %dms of %dms, want a partial pulse", frame.FireTime, frame.FrameDuration)
+			}
+			clock.Advance(time.Duration(frame.FireTime) * time.Millisecond)
+		}},
+		{"disabled", func(t *testing.T, c *Coil, clock *MockClock, reader *scriptedReader) {
+			c.SetTarget <- TargetCommand{Off: true, Source: "test"}
+			step(t, c, clock)
+		}},
+		{"emergency stop", func(t *testing.T, c *Coil, clock *MockClock, reader *scriptedReader) {
+			c.EStop <- struct{}{}
+		}},
+		{"fault", func(t *testing.T, c *Coil, clock *MockClock, reader *scriptedReader) {
+			reader.hold(100 + MaxTempDiff)
+			if frame := step(t, c, clock); frame.Fault == "" {
+				t.Fatal("a spiking reading didn't fault")
+			}
+		}},
+		{"stop", func(t *testing.T, c *Coil, clock *MockClock, reader *scriptedReader) {
+			c.Stop <- struct{}{}
+			<-c.Done()
+		}},
+	}
+	for _, invert := range []bool{false, true} {
+		on, off := "1", "0"
+		if invert {
+			on, off = off, on
+		}
+		for _, path := range paths {
+			t.Run(path.name+" inverted "+strconv.FormatBool(invert), func(t *testing.T) {
+				// The coalesce gap keeps the coil on from one full window to the next, so only the path turns it off
+				c, clock := newTestCoil(t, map[string]string{
+					"PI_HEATER_INVERT_OUTPUT": strconv.FormatBool(invert),
+					"PI_HEATER_COALESCE_GAP":  "50ms",
+				})
+				file := filepath.Join(tempDir(t), "value")
+				if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+				status, err := newFileStatus(file)
+				if err != nil {
+					t.Fatalf("newFileStatus: %s", err)
+				}
+				defer status.f.Close()
+				c.status = status
+				reader := newScriptedReader(100)
+				c.temp = reader
+				startCoil(t, c, clock)
+				c.SetTarget <- TargetCommand{Target: 1000, Source: "test"}
+				step(t, c, clock)
+				step(t, c, clock)
+				if got := lastWrite(t, file); got != on {
+					t.Fatalf("wrote %q firing at full duty, want the on value %q", got, on)
+				}
+
+				path.off(t, c, clock, reader)
+				waitFor(t, "the off value", func() bool { return lastWrite(t, file) == off })
+			})
+		}
+	}
+}
+
+func TestStartupOffWritesConfiguredPolarity(t *testing.T) {
+	for _, invert := range []bool{false, true} {
+		on, off := "1", "0"
+		if invert {
+			on, off = off, on
+		}
+		c, _ := newTestCoil(t, map[string]string{"PI_HEATER_INVERT_OUTPUT": strconv.FormatBool(invert)})
+		file := filepath.Join(tempDir(t), "value")
+		if err := ioutil.WriteFile(file, []byte(on+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		status, err := newFileStatus(file)
+		if err != nil {
+			t.Fatalf("newFileStatus: %s", err)
+		}
+		if err := c.reconcileStatus(status); err != nil {
+			t.Fatalf("reconcileStatus: %s", err)
+		}
+		status.f.Close()
+		if data, _ := ioutil.ReadFile(file); string(data[:1]) != off {
+			t.Errorf("inverted %v: a coil left on at startup was written %q, want the off value %q", invert, data, off)
+		}
+	}
+}
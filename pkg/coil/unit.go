@@ -0,0 +1,91 @@
+package coil
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Unit is a temperature scale.
+type Unit string
+
+const (
+	Fahrenheit Unit = "F"
+	Celsius    Unit = "C"
+	Kelvin     Unit = "K"
+)
+
+// InternalUnit is the unit temperatures and targets are reported and controlled in.
+const InternalUnit = Fahrenheit
+
+// ParseUnit parses a unit symbol case-insensitively; an empty string yields InternalUnit.
+func ParseUnit(s string) (Unit, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "":
+		return InternalUnit, nil
+	case "F":
+		return Fahrenheit, nil
+	case "C":
+		return Celsius, nil
+	case "K":
+		return Kelvin, nil
+	}
+	return "", errors.New("unknown temperature unit: " + s)
+}
+
+// ToInternal converts v from u to InternalUnit.
+func (u Unit) ToInternal(v float64) float64 {
+	switch u {
+	case Celsius:
+		return v*9.0/5.0 + 32.0
+	case Kelvin:
+		return (v-273.15)*9.0/5.0 + 32.0
+	}
+	return v
+}
+
+// FromInternal converts v from InternalUnit to u.
+func (u Unit) FromInternal(v float64) float64 {
+	switch u {
+	case Celsius:
+		return (v - 32.0) * 5.0 / 9.0
+	case Kelvin:
+		return (v-32.0)*5.0/9.0 + 273.15
+	}
+	return v
+}
+
+// Display holds hints for how clients should render temperatures for people, as reported by GET /config.
+// Frames on the wire stay numeric, in InternalUnit.
+type Display struct {
+	// Unit is what to convert temperatures to before rendering; empty renders them raw, in InternalUnit without a symbol.
+	Unit     Unit   `json:"unit,omitempty"`
+	Symbol   string `json:"symbol,omitempty"`
+	Decimals int    `json:"decimals"`
+}
+
+// DefaultDisplay renders in InternalUnit to two decimals, as clients did before display hints.
+var DefaultDisplay = Display{Unit: InternalUnit, Symbol: "°" + string(InternalUnit), Decimals: 2}
+
+// parseDisplay builds the display hints for a PI_HEATER_DISPLAY_UNIT of F, C, K or raw, and a number of decimals.
+func parseDisplay(unit string, decimals int) (Display, error) {
+	if decimals < 0 || decimals > 6 {
+		return Display{}, errors.New("PI_HEATER_DISPLAY_DECIMALS must be from 0 to 6")
+	}
+	if strings.EqualFold(unit, "raw") {
+		return Display{Decimals: decimals}, nil
+	}
+	u, err := ParseUnit(unit)
+	if err != nil {
+		return Display{}, errors.New("invalid PI_HEATER_DISPLAY_UNIT: " + err.Error())
+	}
+	return Display{Unit: u, Symbol: "°" + string(u), Decimals: decimals}, nil
+}
+
+// Format renders temp, in InternalUnit, following the hints.
+func (d Display) Format(temp float64) string {
+	if d.Unit != "" {
+		temp = d.Unit.FromInternal(temp)
+	}
+	return strconv.FormatFloat(temp, 'f', d.Decimals, 64) + d.Symbol
+}
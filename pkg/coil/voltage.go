@@ -0,0 +1,37 @@
+package coil
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Supply voltages further than this fraction from nominal are taken to be bad measurements rather than sags.
+const maxVoltageDeviation = 0.5
+
+// voltageScale is the factor that keeps a duty delivering the power it would at the nominal voltage;
+// a resistive element's power goes with the square of the voltage. It is 1 without voltage compensation.
+func (c *Coil) voltageScale() float64 {
+	if c.nominalVoltage == 0 {
+		return 1
+	}
+	ratio := c.nominalVoltage / c.supplyVoltage
+	return ratio * ratio
+}
+
+// ValidateVoltage reports why v can't be the measured supply voltage, if it can't.
+func (c *Coil) ValidateVoltage(v float64) error {
+	if c.nominalVoltage == 0 {
+		return errors.New("voltage compensation is off; set PI_HEATER_NOMINAL_VOLTAGE")
+	}
+	if v < c.nominalVoltage*(1-maxVoltageDeviation) || v > c.nominalVoltage*(1+maxVoltageDeviation) {
+		return errors.New("supply voltage must be within 50% of PI_HEATER_NOMINAL_VOLTAGE " +
+			strconv.FormatFloat(c.nominalVoltage, 'g', -1, 64))
+	}
+	return nil
+}
+
+// setSupplyVoltage records a validated measured supply voltage; only the run loop may call it.
+func (c *Coil) setSupplyVoltage(v float64) {
+	c.supplyVoltage = v
+	c.infoLog.Printf("supply voltage is %.1fV; scaling fire time by %.3f\n", v, c.voltageScale())
+}
@@ -0,0 +1,193 @@
+package coil
+
+import (
+	"sync"
+	"time"
+)
+
+// WheelClock is a Clock whose timers all share one goroutine and one time.Ticker, hashed into the slots of a timer wheel.
+// Timers fire on the first tick of the wheel at or after they come due, so they are only as precise as its resolution.
+// Many coils can share a WheelClock without each one's windows and pulses needing its own runtime timers.
+type WheelClock struct {
+	mu         sync.Mutex
+	start      time.Time
+	resolution time.Duration
+	slots      [][]*wheelTimer
+	tick       int64 // ticks processed so far
+	stop       chan struct{}
+}
+
+type wheelTimer struct {
+	clock   *WheelClock
+	due     int64 // tick on which the timer fires
+	when    time.Time
+	period  time.Duration // zero for one-shot timers
+	stopped bool
+	c       chan time.Time
+}
+
+// NewWheelClock starts a wheel that turns every resolution, with slots buckets of timers.
+// More slots means fewer timers to check each tick when timers are spread out.
+func NewWheelClock(resolution time.Duration, slots int) *WheelClock {
+	if resolution <= 0 {
+		panic("non-positive resolution for WheelClock")
+	}
+	if slots < 1 {
+		slots = 1
+	}
+	w := &WheelClock{
+		start:      time.Now(),
+		resolution: resolution,
+		slots:      make([][]*wheelTimer, slots),
+		stop:       make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *WheelClock) Now() time.Time {
+	return time.Now()
+}
+
+func (w *WheelClock) Tick(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for WheelClock.Tick")
+	}
+	return w.add(time.Now().Add(d), d)
+}
+
+func (w *WheelClock) After(d time.Duration) <-chan time.Time {
+	return w.add(time.Now().Add(d), 0).c
+}
+
+// Stop stops the wheel; none of its timers fire afterwards.
+func (w *WheelClock) Stop() {
+	close(w.stop)
+}
+
+func (w *WheelClock) add(when time.Time, period time.Duration) *wheelTimer {
+	t := &wheelTimer{clock: w, when: when, period: period, c: make(chan time.Time, 1)}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.schedule(t)
+	return t
+}
+
+// schedule puts t in the slot for the first tick at or after t.when; callers must hold mu.
+func (w *WheelClock) schedule(t *wheelTimer) {
+	elapsed := t.when.Sub(w.start)
+	t.due = int64((elapsed + w.resolution - 1) / w.resolution)
+	if t.due <= w.tick {
+		t.due = w.tick + 1
+	}
+	slot := t.due % int64(len(w.slots))
+	w.slots[slot] = append(w.slots[slot], t)
+}
+
+func (w *WheelClock) run() {
+	ticker := time.NewTicker(w.resolution)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			w.turn(now)
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// turn fires every timer due by now, catching up on any ticks missed while the process was descheduled.
+func (w *WheelClock) turn(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	current := int64(now.Sub(w.start) / w.resolution)
+	for w.tick < current {
+		w.tick++
+		slot := w.tick % int64(len(w.slots))
+		pending := w.slots[slot]
+		w.slots[slot] = nil
+		for _, t := range pending {
+			switch {
+			case t.stopped:
+				continue
+			case t.due > w.tick:
+				// Belongs to a later turn of the wheel
+				w.slots[slot] = append(w.slots[slot], t)
+				continue
+			}
+			// Like the time package, a tick is dropped if the previous one has not been received yet
+			select {
+			case t.c <- now:
+			default:
+			}
+			if t.period > 0 {
+				t.when = t.when.Add(t.period)
+				w.schedule(t)
+			}
+		}
+	}
+}
+
+func (t *wheelTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *wheelTimer) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.stopped = true
+}
+
+// Scheduler runs short jobs on a fixed pool of goroutines rather than one goroutine per job.
+type Scheduler struct {
+	jobs chan func()
+}
+
+func NewScheduler(workers int) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	s := &Scheduler{jobs: make(chan func(), 4*workers)}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range s.jobs {
+				job()
+			}
+		}()
+	}
+	return s
+}
+
+// Go queues job for the pool; if every worker is busy and the queue is full, job runs on the caller's goroutine instead.
+func (s *Scheduler) Go(job func()) {
+	select {
+	case s.jobs <- job:
+	default:
+		job()
+	}
+}
+
+var (
+	wheelOnce sync.Once
+	wheel     *WheelClock
+	schedOnce sync.Once
+	sched     *Scheduler
+)
+
+// sharedWheel is the WheelClock shared by every coil configured with PI_HEATER_TIMER_WORKERS.
+func sharedWheel() *WheelClock {
+	wheelOnce.Do(func() {
+		wheel = NewWheelClock(10*time.Millisecond, 512)
+	})
+	return wheel
+}
+
+// sharedScheduler is the worker pool shared by every coil configured with PI_HEATER_TIMER_WORKERS;
+// the first coil to ask decides its size.
+func sharedScheduler(workers int) *Scheduler {
+	schedOnce.Do(func() {
+		sched = NewScheduler(workers)
+	})
+	return sched
+}
@@ -0,0 +1,67 @@
+//go:build linux
+// +build linux
+
+package coil
+
+import (
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// cpuTime is how much CPU the process has used, user and system together.
+func cpuTime(b *testing.B) time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		b.Fatalf("Getrusage: %s", err)
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// BenchmarkWheel32Zones runs 32 simulated coils with 50ms windows off the shared wheel and worker pool.
+// Each op is one window of one zone; cpu/window is what the Pi has to spend on it and cores is the load averaged over the run.
+func BenchmarkWheel32Zones(b *testing.B) {
+	const zones = 32
+	setEnv(b, map[string]string{
+		"PI_HEATER_PID_MAX":       "50",
+		"PI_HEATER_TIMER_WORKERS": "4",
+	})
+	var frames int64
+	for i := 0; i < zones; i++ {
+		c, err := NewCoil(nil, nil)
+		if err != nil {
+			b.Fatalf("NewCoil: %s", err)
+		}
+		go c.Run()
+		go func() {
+			for {
+				select {
+				case <-c.CurrentFrameChan.C():
+					atomic.AddInt64(&frames, 1)
+				case <-c.Done():
+					return
+				}
+			}
+		}()
+		b.Cleanup(func() {
+			c.Stop <- struct{}{}
+			<-c.Done()
+		})
+		if err := c.Send(c.SetTarget, TargetCommand{Target: 300, Source: "benchmark"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	start, cpuStart := time.Now(), cpuTime(b)
+	from := atomic.LoadInt64(&frames)
+	for atomic.LoadInt64(&frames)-from < int64(b.N) {
+		time.Sleep(time.Millisecond)
+	}
+	wall, cpu := time.Since(start), cpuTime(b)-cpuStart
+	b.StopTimer()
+	windows := atomic.LoadInt64(&frames) - from
+	b.ReportMetric(float64(cpu.Nanoseconds())/float64(windows), "cpu-ns/window")
+	b.ReportMetric(cpu.Seconds()/wall.Seconds(), "cores")
+}
@@ -0,0 +1,52 @@
+package coil
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// WindowRequest asks the run loop to change the control window to Window milliseconds, as PI_HEATER_PID_MAX sets it.
+// The config once changed, or why the window was rejected, is sent on Reply, which must be buffered.
+type WindowRequest struct {
+	Window int64
+	Reply  chan WindowResult
+}
+
+type WindowResult struct {
+	Config Config
+	Err    error
+}
+
+// ValidateWindow reports why a window of ms milliseconds can't be used, if it can't.
+// The window must leave room for a pulse once the blip off at its end is taken out, and for the coalescing gap.
+func (c *Coil) ValidateWindow(ms int64) error {
+	if ms <= maxWiggle {
+		return errors.New("window must be greater than " + strconv.Itoa(maxWiggle) + " milliseconds")
+	}
+	if c.coalesce > 0 && time.Duration(ms)*time.Millisecond <= c.coalesce {
+		return errors.New("window must be longer than PI_HEATER_COALESCE_GAP " + c.coalesce.String())
+	}
+	return nil
+}
+
+// changeWindow is the only way the window changes: it switches to a validated window of ms milliseconds and reports
+// whether that changed anything. Past NewCoil only the run loop may call it, and it must then restart its ticker.
+// The fire time is clamped to the new window straight away rather than at the next tick.
+func (c *Coil) changeWindow(ms int64) bool {
+	old := c.window
+	if time.Duration(ms)*time.Millisecond == old {
+		return false
+	}
+	c.window = time.Duration(ms) * time.Millisecond
+	c.maxFire = float64(ms - maxWiggle)
+	c.pid.SetOutputLimits(0, c.maxFire)
+	if max := time.Duration(c.maxFire) * time.Millisecond; c.FireTime > max {
+		c.FireTime = max
+	}
+	c.refreshConfig()
+	if old != 0 {
+		c.infoLog.Printf("changed the control window from %s to %s\n", old, c.window)
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package coil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetWindowChangesTickRate(t *testing.T) {
+	c, clock := newTestCoil(t, nil)
+	c.status = &recordingStatus{}
+	startCoil(t, c, clock)
+	c.SetTarget <- TargetCommand{Target: 300, Source: "test"}
+
+	first := step(t, c, clock)
+	if first.FrameDuration != 1000 {
+		t.Fatalf("frame duration = %dms, want PI_HEATER_PID_MAX's 1000ms", first.FrameDuration)
+	}
+
+	reply := make(chan WindowResult, 1)
+	c.SetWindow <- WindowRequest{Window: 2500, Reply: reply}
+	result := <-reply
+	if result.Err != nil || result.Config.Window != 2500 {
+		t.Fatalf("SetWindow(2500) = %+v", result)
+	}
+	if max := result.Config.MaxFire; max != 2500-maxWiggle {
+		t.Errorf("max fire = %v, want %d", max, 2500-maxWiggle)
+	}
+	changed := clock.Now()
+
+	// The old ticker would have ticked by now
+	clock.Advance(time.Second)
+	select {
+	case frame := <-c.CurrentFrameChan.C():
+		t.Fatalf("a frame %s after changing to a 2.5s window", frame.FrameStart.Sub(changed))
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(1500 * time.Millisecond)
+	frame := nextFrame(t, c)
+	if got := frame.FrameStart.Sub(changed); got != 2500*time.Millisecond {
+		t.Errorf("first frame %s after the change, want 2.5s", got)
+	}
+	prev := frame
+	for i := 0; i < 3; i++ {
+		frame = step(t, c, clock)
+		if got := frame.FrameStart.Sub(prev.FrameStart); got != 2500*time.Millisecond {
+			t.Errorf("frames %s apart, want 2.5s", got)
+		}
+		if frame.FrameDuration != 2500 || frame.FireTime > 2500-maxWiggle {
+			t.Errorf("frame duration %dms fire time %dms, want a 2.5s window", frame.FrameDuration, frame.FireTime)
+		}
+		prev = frame
+	}
+
+	c.SetWindow <- WindowRequest{Window: maxWiggle, Reply: reply}
+	if result := <-reply; result.Err == nil {
+		t.Errorf("SetWindow(%d) was accepted", maxWiggle)
+	}
+	if c.Config().Window != 2500 {
+		t.Errorf("a rejected window changed the window to %dms", c.Config().Window)
+	}
+}
+
+func TestNewCoilRejectsShortWindow(t *testing.T) {
+	for _, max := range []string{"0", "-100", "15"} {
+		setEnv(t, map[string]string{"PI_HEATER_PID_MAX": max})
+		if _, err := NewCoil(nil, nil); err == nil {
+			t.Errorf("NewCoil accepted PI_HEATER_PID_MAX=%s", max)
+		}
+	}
+}
+
+func TestSetWindowToSameWindowKeepsTicking(t *testing.T) {
+	c, clock := newTestCoil(t, nil)
+	c.status = &recordingStatus{}
+	startCoil(t, c, clock)
+	first := step(t, c, clock)
+
+	clock.Advance(400 * time.Millisecond)
+	reply := make(chan WindowResult, 1)
+	c.SetWindow <- WindowRequest{Window: 1000, Reply: reply}
+	if result := <-reply; result.Err != nil {
+		t.Fatalf("SetWindow(1000) = %+v", result)
+	}
+	// Restarting the ticker would put the next frame a full window after the request
+	clock.Advance(600 * time.Millisecond)
+	if got := nextFrame(t, c).FrameStart.Sub(first.FrameStart); got != time.Second {
+		t.Errorf("next frame %s after the last, want 1s", got)
+	}
+}
+
+func TestPresetWindowGoesThroughChangeWindow(t *testing.T) {
+	c, clock := newTestCoil(t, map[string]string{"PI_HEATER_COALESCE_GAP": "500ms"})
+	c.temp = newScriptedReader(100)
+	c.status = &recordingStatus{}
+	startCoil(t, c, clock)
+	c.SetTarget <- TargetCommand{Target: 1000, Source: "test"}
+	if frame := step(t, c, clock); frame.FireTime != 1000-maxWiggle {
+		t.Fatalf("fired for %dms far below the target, want %dms", frame.FireTime, 1000-maxWiggle)
+	}
+
+	reply := make(chan PresetResult, 1)
+	for _, max := range []int64{maxWiggle, 500} {
+		c.ApplyPreset <- PresetRequest{Preset: Preset{Max: &max}, Reply: reply}
+		if result := <-reply; result.Err == nil {
+			t.Errorf("a preset with max %d was accepted", max)
+		}
+	}
+	if c.Config().Window != 1000 {
+		t.Fatalf("a rejected preset changed the window to %dms", c.Config().Window)
+	}
+
+	max := int64(600)
+	c.ApplyPreset <- PresetRequest{Preset: Preset{Max: &max}, Reply: reply}
+	result := <-reply
+	if result.Err != nil || result.Config.Window != 600 || result.Config.MaxFire != 600-maxWiggle {
+		t.Fatalf("preset with max 600 = %+v", result)
+	}
+	if want := (600 - maxWiggle) * time.Millisecond; c.FireTime != want {
+		t.Errorf("fire time = %s once the window shrank, want it clamped to %s", c.FireTime, want)
+	}
+}
@@ -0,0 +1,67 @@
+package coil
+
+import (
+	"bytes"
+	"encoding/json"
+	"unicode"
+)
+
+// Frames are sent with snake_case names, each its Go field's name split at word boundaries, e.g. frame_start and
+// pid_reset. Optional fields are left out when unset; timestamp, temp, target, frame_start, frame_duration and
+// fire_time are always there, except in heartbeat frames. GET /openapi.json describes the full schema.
+//
+// Frames used to be sent with their Go field names; UnmarshalJSON still reads those, so clients keep working against
+// older devices and the histories recorded from them.
+func (f *CoilFrame) UnmarshalJSON(data []byte) error {
+	type wire CoilFrame
+	// Every frame in the old format has a Timestamp; one in the new format could only match in a string value,
+	// in which case renaming its keys changes nothing
+	if !bytes.Contains(data, []byte(`"Timestamp":`)) {
+		return json.Unmarshal(data, (*wire)(f))
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var legacy interface{}
+	if err := dec.Decode(&legacy); err != nil {
+		return err
+	}
+	data, err := json.Marshal(snakeKeys(legacy))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, (*wire)(f))
+}
+
+// snakeKeys renames the keys of every object in v to snake case.
+func snakeKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			renamed[snakeCase(key)] = snakeKeys(value)
+		}
+		return renamed
+	case []interface{}:
+		for i := range v {
+			v[i] = snakeKeys(v[i])
+		}
+	}
+	return v
+}
+
+// snakeCase splits a Go name at word boundaries, keeping initialisms together: PIDReset becomes pid_reset.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b []rune
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b = append(b, '_')
+			}
+		}
+		b = append(b, unicode.ToLower(r))
+	}
+	return string(b)
+}
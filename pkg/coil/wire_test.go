@@ -0,0 +1,153 @@
+package coil
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// fill sets every field reachable from v, which must be settable, to a value that depends only on its position,
+// so a new CoilFrame field shows up in the golden file whether or not it would be omitted when empty.
+func fill(v reflect.Value, n *int) {
+	*n++
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(*n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(*n))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(*n) + 0.5)
+	case reflect.String:
+		v.SetString("value " + string(rune('a'+*n%26)))
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem(), n)
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 2, 2))
+		for i := 0; i < v.Len(); i++ {
+			fill(v.Index(i), n)
+		}
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			v.Set(reflect.ValueOf(testEpoch.Add(time.Duration(*n) * time.Second)))
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				fill(v.Field(i), n)
+			}
+		}
+	}
+}
+
+// checkGolden compares got with testdata/name, rewriting it instead with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error while reading golden file, run with -update to create it: %s", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s changed; if that's intended, run go test -update and commit it\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestFrameWireFormat(t *testing.T) {
+	var full CoilFrame
+	fill(reflect.ValueOf(&full).Elem(), new(int))
+	minimal := CoilFrame{
+		Timestamp:     testEpoch,
+		Temp:          151.25,
+		Target:        300,
+		FrameStart:    testEpoch,
+		FrameDuration: 1000,
+	}
+	for name, frame := range map[string]CoilFrame{
+		"frame_full.golden":    full,
+		"frame_minimal.golden": minimal,
+	} {
+		got, err := json.MarshalIndent(&frame, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, name, append(got, '\n'))
+
+		var decoded CoilFrame
+		if err := json.Unmarshal(got, &decoded); err != nil {
+			t.Fatalf("%s: error while decoding: %s", name, err)
+		}
+		if !reflect.DeepEqual(decoded, frame) {
+			t.Errorf("%s doesn't round trip:\n%+v\nwant\n%+v", name, decoded, frame)
+		}
+	}
+}
+
+func TestLegacyFrameDecode(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "frame_legacy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frame CoilFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		t.Fatalf("error while decoding legacy frame: %s", err)
+	}
+	at := testEpoch.Add(10 * time.Second)
+	timeToTarget, coldJunction := 42.5, 77.5
+	want := CoilFrame{
+		Timestamp:     at,
+		Temp:          151.25,
+		Target:        300,
+		FrameStart:    at,
+		FrameDuration: 1000,
+		FireTime:      985,
+		TimeToTarget:  &timeToTarget,
+		ContinuousOn:  2970,
+		IdleRemaining: 3590000,
+		PIDReset:      true,
+		ColdJunction:  &coldJunction,
+	}
+	if !reflect.DeepEqual(frame, want) {
+		t.Errorf("legacy frame decoded as\n%+v\nwant\n%+v", frame, want)
+	}
+
+	// A frame in the current format mentioning the old key only in a string value decodes as is
+	current := []byte(`{"timestamp":"2020-01-01T00:00:00Z","temp":100,"fault":"\"Timestamp\": missing"}`)
+	if err := json.Unmarshal(current, &frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Temp != 100 || frame.Fault != `"Timestamp": missing` {
+		t.Errorf("current frame decoded as %+v", frame)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, want := range map[string]string{
+		"Timestamp":     "timestamp",
+		"FrameStart":    "frame_start",
+		"PIDReset":      "pid_reset",
+		"TimeToTarget":  "time_to_target",
+		"DroppedFrames": "dropped_frames",
+		"P":             "p",
+		"Cycle2Period":  "cycle2_period",
+	} {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}